@@ -8,6 +8,7 @@ require (
 	github.com/diamondburned/go-lovense v0.0.0-20211124112327-919ccd70ecea
 	github.com/diamondburned/gotk4/pkg v0.0.0-20211121095826-148e5d6f3165
 	github.com/diamondburned/vgcairo v0.0.0-20211121084140-bec98bb26e72
+	github.com/gorilla/websocket v1.4.2
 	github.com/gregjones/httpcache v0.0.0-20190611155906-901d90724c79
 	github.com/pkg/errors v0.9.1
 	gonum.org/v1/plot v0.10.0
@@ -21,7 +22,6 @@ require (
 	github.com/go-pdf/fpdf v0.5.0 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/google/btree v1.0.1 // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/peterbourgon/diskv v2.0.1+incompatible // indirect
 	go4.org/unsafe/assume-no-moving-gc v0.0.0-20201222180813-1025295fd063 // indirect
 	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d // indirect
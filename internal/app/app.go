@@ -1,6 +1,10 @@
 package app
 
-import "github.com/diamondburned/gotk4/pkg/gtk/v4"
+import (
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+)
 
 var instance *gtk.Application
 
@@ -21,3 +25,18 @@ func Init(id string) *gtk.Application {
 	instance = gtk.NewApplication(id, 0)
 	return instance
 }
+
+// AddAction adds a stateless application action that calls f when activated.
+// The action can be referred to as "app.<name>", and it is bound to the given
+// accelerators, if any.
+func AddAction(name string, accels []string, f func()) {
+	action := gio.NewSimpleAction(name, nil)
+	action.ConnectActivate(func(*glib.Variant) { f() })
+
+	app := Require()
+	app.AddAction(action)
+
+	if len(accels) > 0 {
+		app.SetAccelsForAction("app."+name, accels)
+	}
+}
@@ -0,0 +1,53 @@
+// Package atomicfile writes files atomically, so that a file is never left
+// half-written if the application stops in the middle of saving it.
+package atomicfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes b into a temporary file next to the file at path, then
+// renames it over that file. The parent directories are created if needed.
+// Every write goes through its own temporary file, so concurrent writes never
+// mix.
+func WriteFile(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot make directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %w", filepath.Base(path), err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write %s: %w", filepath.Base(path), err)
+	}
+
+	// CreateTemp makes the file private.
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write %s: %w", filepath.Base(path), err)
+	}
+
+	// Otherwise, the rename may reach the disk before the data does.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write %s: %w", filepath.Base(path), err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot write %s: %w", filepath.Base(path), err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("cannot commit %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+// Package backoff provides an exponential backoff calculator.
+package backoff
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultFactor is the default growth factor between attempts.
+const DefaultFactor = 2
+
+// Backoff calculates exponentially growing delays between attempts. It is not
+// safe to be used concurrently.
+type Backoff struct {
+	// Min is the delay before the first retry.
+	Min time.Duration
+	// Max caps the delay.
+	Max time.Duration
+	// Factor is what the delay is multiplied by on every attempt.
+	Factor float64
+
+	attempts int
+}
+
+// New creates a new Backoff with DefaultFactor.
+func New(min, max time.Duration) *Backoff {
+	return &Backoff{
+		Min:    min,
+		Max:    max,
+		Factor: DefaultFactor,
+	}
+}
+
+// Next returns the delay to wait before the next attempt and counts the
+// attempt.
+func (b *Backoff) Next() time.Duration {
+	d := float64(b.Min) * math.Pow(b.Factor, float64(b.attempts))
+	if d >= float64(b.Max) {
+		return b.Max
+	}
+
+	b.attempts++
+	return time.Duration(d)
+}
+
+// Reset resets the Backoff back to its minimum delay.
+func (b *Backoff) Reset() {
+	b.attempts = 0
+}
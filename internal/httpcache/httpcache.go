@@ -7,18 +7,67 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 
 	"github.com/diamondburned/go-lovense/api"
 	"github.com/gregjones/httpcache"
 	"github.com/gregjones/httpcache/diskcache"
 )
 
-// Path is the cache path.
-var Path = filepath.Join(os.TempDir(), "intiface-gtk", "cache")
+// DefaultPath is the default cache path.
+var DefaultPath = filepath.Join(os.TempDir(), "intiface-gtk", "cache")
 
-// Client is the cached client.
+// Client is the cached client. Its cache directory can be changed using
+// SetPath.
 var Client = http.Client{
-	Transport: httpcache.NewTransport(diskcache.New(Path)),
+	Transport: &transport,
+}
+
+var transport swappableTransport
+
+func init() {
+	transport.setPath(DefaultPath)
+}
+
+// SetPath changes the cache directory. It is safe to be called while requests
+// are being made.
+func SetPath(path string) {
+	transport.setPath(path)
+}
+
+// Path returns the current cache directory.
+func Path() string {
+	return transport.path()
+}
+
+type cacheTransport struct {
+	path      string
+	transport *httpcache.Transport
+}
+
+// swappableTransport is a RoundTripper whose cache can be swapped out
+// atomically.
+type swappableTransport struct {
+	v atomic.Value // *cacheTransport
+}
+
+func (t *swappableTransport) setPath(path string) {
+	if current, ok := t.v.Load().(*cacheTransport); ok && current.path == path {
+		return
+	}
+
+	t.v.Store(&cacheTransport{
+		path:      path,
+		transport: httpcache.NewTransport(diskcache.New(path)),
+	})
+}
+
+func (t *swappableTransport) path() string {
+	return t.v.Load().(*cacheTransport).path
+}
+
+func (t *swappableTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return t.v.Load().(*cacheTransport).transport.RoundTrip(r)
 }
 
 // DownloadPatternBytes downloads the given pattern into bytes. It does not
@@ -43,6 +92,30 @@ func DownloadPatternBytes(ctx context.Context, apiPattern *api.Pattern) ([]byte,
 	return buf.Bytes(), nil
 }
 
+// CachedPatternBytes returns the given pattern's bytes if they're already in
+// the cache. It never makes a request.
+func CachedPatternBytes(apiPattern *api.Pattern) ([]byte, bool) {
+	q, err := http.NewRequest("GET", apiPattern.CDNPath, nil)
+	if err != nil {
+		return nil, false
+	}
+
+	cache := transport.v.Load().(*cacheTransport).transport.Cache
+
+	r, err := httpcache.CachedResponse(cache, q)
+	if err != nil || r == nil {
+		return nil, false
+	}
+	defer r.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		return nil, false
+	}
+
+	return buf.Bytes(), true
+}
+
 /*
 // DownloadPattern downloads the givne pattern and parses it.
 func DownloadPattern(ctx context.Context, apiPattern *api.Pattern) (*pattern.Pattern, error) {
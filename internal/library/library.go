@@ -0,0 +1,366 @@
+// Package library manages a directory of pattern files along with an index of
+// their metadata.
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+	"github.com/diamondburned/intiface-gtk/internal/atomicfile"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+)
+
+// IndexName is the name of the index file inside the library directory.
+const IndexName = "index.json"
+
+// fileExt is the extension of pattern files added to the library.
+const fileExt = ".pattern"
+
+// MaxRating is the highest rating of an entry.
+const MaxRating = 5
+
+// Entry describes a pattern file in the library.
+type Entry struct {
+	// File is the name of the pattern file inside the library directory. It
+	// identifies the entry.
+	File string `json:"file"`
+	// Name is the display name of the pattern.
+	Name string `json:"name"`
+	// Author is whoever made the pattern, if known.
+	Author string `json:"author,omitempty"`
+	// Source is the URL that the pattern was downloaded from, if any.
+	Source string `json:"source,omitempty"`
+	// DurationMillis is the duration of the pattern.
+	DurationMillis int64 `json:"duration_ms"`
+	// Features are the features declared by the pattern.
+	Features []pattern.Feature `json:"features,omitempty"`
+	// Tags are free-form labels given by the user.
+	Tags []string `json:"tags,omitempty"`
+	// Notes are free-form notes given by the user.
+	Notes string `json:"notes,omitempty"`
+	// Rating is within [0, MaxRating], where 0 means unrated.
+	Rating int `json:"rating,omitempty"`
+	// Added is when the pattern was added to the library.
+	Added time.Time `json:"added"`
+}
+
+// Duration returns DurationMillis as a duration.
+func (e Entry) Duration() time.Duration {
+	return time.Duration(e.DurationMillis) * time.Millisecond
+}
+
+// Matches returns true if every word of the query is found in the entry's
+// name, author, features, tags or notes. The search is case-insensitive.
+func (e Entry) Matches(query string) bool {
+	fields := []string{e.Name, e.Author, e.Notes}
+	fields = append(fields, e.Tags...)
+	for _, feature := range e.Features {
+		fields = append(fields, feature.String())
+	}
+
+	haystack := strings.ToLower(strings.Join(fields, "\n"))
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(haystack, word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e Entry) copy() Entry {
+	cpy := e
+	cpy.Features = append([]pattern.Feature(nil), e.Features...)
+	cpy.Tags = append([]string(nil), e.Tags...)
+	return cpy
+}
+
+// Library is a directory of pattern files. A Library is safe to use
+// concurrently.
+type Library struct {
+	dir string
+
+	mutex   sync.Mutex
+	entries map[string]Entry
+}
+
+// Open opens the library in the given directory, creating it if needed, and
+// scans it for pattern files that aren't indexed yet.
+func Open(dir string) (*Library, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot make library directory: %w", err)
+	}
+
+	l := &Library{
+		dir:     dir,
+		entries: make(map[string]Entry),
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, IndexName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("cannot read library index: %w", err)
+	}
+
+	if err == nil {
+		var entries []Entry
+		if err := json.Unmarshal(b, &entries); err != nil {
+			return nil, fmt.Errorf("cannot decode library index: %w", err)
+		}
+		for _, entry := range entries {
+			l.entries[entry.File] = entry
+		}
+	}
+
+	if err := l.Scan(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Dir returns the library directory.
+func (l *Library) Dir() string {
+	return l.dir
+}
+
+// Path returns the path to the entry's pattern file.
+func (l *Library) Path(e Entry) string {
+	return filepath.Join(l.dir, e.File)
+}
+
+// Scan indexes the pattern files in the library directory that aren't indexed
+// yet and forgets the entries whose files are gone. Files that aren't valid
+// patterns are skipped.
+func (l *Library) Scan() error {
+	files, err := os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("cannot read library directory: %w", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	found := make(map[string]bool, len(files))
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || name == IndexName || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+
+		found[name] = true
+		if _, ok := l.entries[name]; ok {
+			continue
+		}
+
+		entry := Entry{
+			File:  name,
+			Name:  strings.TrimSuffix(name, filepath.Ext(name)),
+			Added: time.Now(),
+		}
+		if info, err := file.Info(); err == nil {
+			entry.Added = info.ModTime()
+		}
+
+		p, err := playback.Open(filepath.Join(l.dir, name))
+		if err != nil {
+			continue
+		}
+		describe(&entry, p)
+
+		l.entries[name] = entry
+	}
+
+	for name := range l.entries {
+		if !found[name] {
+			delete(l.entries, name)
+		}
+	}
+
+	return l.save()
+}
+
+// Entries returns all entries sorted by their names.
+func (l *Library) Entries() []Entry {
+	return l.Search("")
+}
+
+// Search returns the entries that match the query sorted by their names. See
+// Entry.Matches.
+func (l *Library) Search(query string) []Entry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entries := make([]Entry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		if entry.Matches(query) {
+			entries = append(entries, entry.copy())
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := strings.ToLower(entries[i].Name), strings.ToLower(entries[j].Name)
+		if a != b {
+			return a < b
+		}
+		return entries[i].File < entries[j].File
+	})
+
+	return entries
+}
+
+// Get returns the entry of the given file.
+func (l *Library) Get(file string) (Entry, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry, ok := l.entries[file]
+	return entry.copy(), ok
+}
+
+// Add adds the pattern in data to the library as a new file. The name,
+// author, source and tags of meta are kept; the rest is read from the
+// pattern.
+func (l *Library) Add(data []byte, meta Entry) (Entry, error) {
+	p, err := playback.Load(bytes.NewReader(data))
+	if err != nil {
+		return Entry{}, err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry := Entry{
+		File:   l.newFile(meta.Name),
+		Name:   meta.Name,
+		Author: meta.Author,
+		Source: meta.Source,
+		Tags:   append([]string(nil), meta.Tags...),
+		Added:  time.Now(),
+	}
+	describe(&entry, p)
+
+	if err := atomicfile.WriteFile(filepath.Join(l.dir, entry.File), data); err != nil {
+		return Entry{}, err
+	}
+
+	l.entries[entry.File] = entry
+	return entry.copy(), l.save()
+}
+
+// Import copies the pattern file at path into the library.
+func (l *Library) Import(path string) (Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Entry{}, fmt.Errorf("cannot read pattern: %w", err)
+	}
+
+	name := filepath.Base(path)
+	return l.Add(data, Entry{Name: strings.TrimSuffix(name, filepath.Ext(name))})
+}
+
+// Update calls f with the entry of the given file, then saves whatever f
+// changed. The file of the entry cannot be changed.
+func (l *Library) Update(file string, f func(e *Entry)) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry, ok := l.entries[file]
+	if !ok {
+		return fmt.Errorf("%s is not in the library", file)
+	}
+
+	entry = entry.copy()
+	f(&entry)
+
+	entry.File = file
+	if entry.Rating < 0 {
+		entry.Rating = 0
+	}
+	if entry.Rating > MaxRating {
+		entry.Rating = MaxRating
+	}
+
+	l.entries[file] = entry
+	return l.save()
+}
+
+// Remove deletes the pattern file of the given entry and forgets it.
+func (l *Library) Remove(file string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.entries[file]; !ok {
+		return fmt.Errorf("%s is not in the library", file)
+	}
+
+	err := os.Remove(filepath.Join(l.dir, file))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot remove pattern: %w", err)
+	}
+
+	delete(l.entries, file)
+	return l.save()
+}
+
+// newFile returns an unused file name for a pattern with the given name. The
+// mutex must be held.
+func (l *Library) newFile(name string) string {
+	base := strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+
+	if base == "" || base == "." || base == ".." {
+		base = "pattern"
+	}
+
+	file := base + fileExt
+	for i := 2; ; i++ {
+		_, indexed := l.entries[file]
+		_, err := os.Stat(filepath.Join(l.dir, file))
+		if !indexed && errors.Is(err, fs.ErrNotExist) {
+			return file
+		}
+		file = base + " (" + strconv.Itoa(i) + ")" + fileExt
+	}
+}
+
+// save writes the index. The mutex must be held.
+func (l *Library) save() error {
+	entries := make([]Entry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].File < entries[j].File
+	})
+
+	b, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return fmt.Errorf("cannot encode library index: %w", err)
+	}
+
+	return atomicfile.WriteFile(filepath.Join(l.dir, IndexName), b)
+}
+
+// describe fills the entry with what's read from the pattern.
+func describe(e *Entry, p *pattern.Pattern) {
+	duration := p.Interval * time.Duration(len(p.Points))
+	e.DurationMillis = duration.Milliseconds()
+	e.Features = append([]pattern.Feature(nil), p.Features...)
+}
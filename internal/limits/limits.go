@@ -0,0 +1,99 @@
+// Package limits provides maximum output caps for device actuators.
+package limits
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/diamondburned/go-buttplug"
+)
+
+// Limits describes the maximum output of a single device's actuators. All
+// values are within [0, 1].
+type Limits struct {
+	// Max caps every actuator of the device.
+	Max float64 `json:"max"`
+	// Actuators caps individual actuators. It is keyed by Key.
+	Actuators map[string]float64 `json:"actuators,omitempty"`
+}
+
+// None returns Limits that don't cap anything.
+func None() Limits {
+	return Limits{Max: 1}
+}
+
+// UnmarshalJSON decodes l. A missing max doesn't cap the device, so that an
+// entry with only actuator caps doesn't silence it.
+func (l *Limits) UnmarshalJSON(b []byte) error {
+	type raw Limits
+	r := raw(None())
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+	*l = Limits(r)
+	return nil
+}
+
+// Key returns the key of the actuator in Actuators.
+func Key(kind buttplug.MessageType, index int) string {
+	return fmt.Sprintf("%s:%d", kind, index)
+}
+
+// Cap returns the maximum value that the given actuator may be sent.
+func (l Limits) Cap(kind buttplug.MessageType, index int) float64 {
+	max := clamp(l.Max)
+
+	if v, ok := l.Actuators[Key(kind, index)]; ok {
+		if v = clamp(v); v < max {
+			max = v
+		}
+	}
+
+	return max
+}
+
+// Apply caps v for the given actuator. v is also clamped to be within [0, 1],
+// and NaN is sent as 0.
+func (l Limits) Apply(kind buttplug.MessageType, index int, v float64) float64 {
+	v = clamp(v)
+	if max := l.Cap(kind, index); v > max {
+		return max
+	}
+	return v
+}
+
+// IsNone returns true if l doesn't cap anything.
+func (l Limits) IsNone() bool {
+	if clamp(l.Max) < 1 {
+		return false
+	}
+	for _, v := range l.Actuators {
+		if clamp(v) < 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy returns a deep copy of l.
+func (l Limits) Copy() Limits {
+	cpy := Limits{Max: l.Max}
+	if l.Actuators != nil {
+		cpy.Actuators = make(map[string]float64, len(l.Actuators))
+		for k, v := range l.Actuators {
+			cpy.Actuators[k] = v
+		}
+	}
+	return cpy
+}
+
+func clamp(v float64) float64 {
+	if v < 0 || math.IsNaN(v) {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
@@ -0,0 +1,105 @@
+package limits
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/diamondburned/go-buttplug"
+)
+
+func TestCap(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		kind   buttplug.MessageType
+		index  int
+		want   float64
+	}{
+		{"none", None(), buttplug.VibrateCmdMessage, 0, 1},
+		{"max", Limits{Max: 0.5}, buttplug.VibrateCmdMessage, 0, 0.5},
+		{"max above 1", Limits{Max: 2}, buttplug.VibrateCmdMessage, 0, 1},
+		{"max below 0", Limits{Max: -1}, buttplug.VibrateCmdMessage, 0, 0},
+		{"max NaN", Limits{Max: math.NaN()}, buttplug.VibrateCmdMessage, 0, 0},
+		{
+			"actuator below max",
+			Limits{Max: 0.8, Actuators: map[string]float64{"VibrateCmd:1": 0.3}},
+			buttplug.VibrateCmdMessage, 1, 0.3,
+		},
+		{
+			"actuator above max",
+			Limits{Max: 0.5, Actuators: map[string]float64{"VibrateCmd:1": 0.9}},
+			buttplug.VibrateCmdMessage, 1, 0.5,
+		},
+		{
+			"other actuator",
+			Limits{Max: 0.8, Actuators: map[string]float64{"VibrateCmd:1": 0.3}},
+			buttplug.VibrateCmdMessage, 0, 0.8,
+		},
+		{
+			"other kind",
+			Limits{Max: 0.8, Actuators: map[string]float64{"VibrateCmd:1": 0.3}},
+			buttplug.RotateCmdMessage, 1, 0.8,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.limits.Cap(test.kind, test.index); got != test.want {
+				t.Fatalf("Cap = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	limits := Limits{Max: 0.8, Actuators: map[string]float64{"LinearCmd:0": 0.5}}
+
+	tests := []struct {
+		name  string
+		kind  buttplug.MessageType
+		index int
+		v     float64
+		want  float64
+	}{
+		{"below cap", buttplug.VibrateCmdMessage, 0, 0.4, 0.4},
+		{"above cap", buttplug.VibrateCmdMessage, 0, 0.9, 0.8},
+		{"above actuator cap", buttplug.LinearCmdMessage, 0, 0.7, 0.5},
+		{"negative", buttplug.VibrateCmdMessage, 0, -0.5, 0},
+		{"NaN", buttplug.VibrateCmdMessage, 0, math.NaN(), 0},
+		{"infinity", buttplug.VibrateCmdMessage, 0, math.Inf(1), 0.8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := limits.Apply(test.kind, test.index, test.v); got != test.want {
+				t.Fatalf("Apply(%v) = %v, want %v", test.v, got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want float64 // cap of VibrateCmd:0
+	}{
+		{"empty", `{}`, 1},
+		{"only actuators", `{"actuators":{"VibrateCmd:1":0.5}}`, 1},
+		{"max", `{"max":0.4}`, 0.4},
+		{"zero max", `{"max":0}`, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var l Limits
+			if err := json.Unmarshal([]byte(test.json), &l); err != nil {
+				t.Fatal("cannot decode:", err)
+			}
+			if got := l.Cap(buttplug.VibrateCmdMessage, 0); got != test.want {
+				t.Fatalf("Cap = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
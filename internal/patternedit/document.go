@@ -0,0 +1,388 @@
+// Package patternedit provides Lovense patterns that can be edited, either point
+// by point with undo and redo, or as a grid of steps played at a tempo.
+package patternedit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+)
+
+// maxUndo is the number of edits that can be undone.
+const maxUndo = 200
+
+// Document is a version 1 pattern that's being edited. Every edit can be
+// undone, except that Set and Line are grouped into strokes: they belong to
+// the edit started by the last Checkpoint. A Document isn't safe to use
+// concurrently.
+type Document struct {
+	state state
+	undo  []state
+	redo  []state
+	saved int // revision
+	revs  int // last revision
+}
+
+// state is a snapshot of a document.
+type state struct {
+	interval time.Duration
+	features []pattern.Feature
+	points   pattern.Points
+	rev      int
+}
+
+func (s state) copy() state {
+	points := make(pattern.Points, len(s.points))
+	for i, point := range s.points {
+		points[i] = append(pattern.Point(nil), point...)
+	}
+
+	return state{
+		interval: s.interval,
+		features: append([]pattern.Feature(nil), s.features...),
+		points:   points,
+		rev:      s.rev,
+	}
+}
+
+// New creates a document of length points that are all 0, with one channel
+// for each feature.
+func New(features []pattern.Feature, interval time.Duration, length int) *Document {
+	if len(features) == 0 {
+		features = []pattern.Feature{pattern.Vibrate}
+	}
+	if length < 1 {
+		length = 1
+	}
+
+	d := &Document{
+		state: state{
+			interval: roundInterval(interval),
+			features: append([]pattern.Feature(nil), features...),
+		},
+	}
+	d.resize(length)
+	return d
+}
+
+// Open creates a document from a copy of p, whose points are converted to
+// the scale of version 1.
+func Open(p *pattern.Pattern) *Document {
+	channels := playback.Channels(p)
+
+	features := append([]pattern.Feature(nil), p.Features...)
+	for len(features) < channels {
+		features = append(features, channelFeature(len(features), channels))
+	}
+
+	d := New(features, p.Interval, len(p.Points))
+	for i, point := range p.Points {
+		for ch, v := range point.Scale(p.Version) {
+			d.Set(ch, i, v)
+		}
+	}
+
+	return d
+}
+
+// channelFeature returns the feature of a channel in a pattern that doesn't
+// name its features.
+func channelFeature(ch, channels int) pattern.Feature {
+	if channels == 1 {
+		return pattern.Vibrate
+	}
+	return pattern.Feature("v" + strconv.Itoa(ch+1))
+}
+
+func roundInterval(interval time.Duration) time.Duration {
+	interval = interval.Round(time.Millisecond)
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	return interval
+}
+
+// Pattern returns a copy of the document as a pattern.
+func (d *Document) Pattern() *pattern.Pattern {
+	s := d.state.copy()
+
+	p := &pattern.Pattern{Points: s.points}
+	p.Version = 1
+	p.Interval = s.interval
+	p.Features = s.features
+	return p
+}
+
+// Len returns the number of points.
+func (d *Document) Len() int {
+	return len(d.state.points)
+}
+
+// Channels returns the number of channels.
+func (d *Document) Channels() int {
+	return len(d.state.features)
+}
+
+// Interval returns the time between two points.
+func (d *Document) Interval() time.Duration {
+	return d.state.interval
+}
+
+// Duration returns the duration of the pattern.
+func (d *Document) Duration() time.Duration {
+	return d.state.interval * time.Duration(len(d.state.points))
+}
+
+// Features returns the feature of every channel.
+func (d *Document) Features() []pattern.Feature {
+	return append([]pattern.Feature(nil), d.state.features...)
+}
+
+// Value returns the value within [0, 1] of a channel at point i.
+func (d *Document) Value(ch, i int) float64 {
+	if !d.contains(ch, i) {
+		return 0
+	}
+	return float64(d.state.points[i][ch]) / playback.MaxStrength
+}
+
+// Values returns the values within [0, 1] of a channel at every point.
+func (d *Document) Values(ch int) []float64 {
+	values := make([]float64, len(d.state.points))
+	for i := range values {
+		values[i] = d.Value(ch, i)
+	}
+	return values
+}
+
+func (d *Document) contains(ch, i int) bool {
+	return ch >= 0 && ch < d.Channels() && i >= 0 && i < d.Len()
+}
+
+// Modified returns true if the document has changed since it was last marked
+// as saved.
+func (d *Document) Modified() bool {
+	return d.state.rev != d.saved
+}
+
+// MarkSaved marks the document as saved.
+func (d *Document) MarkSaved() {
+	d.saved = d.state.rev
+}
+
+// CanUndo returns true if there is an edit to undo.
+func (d *Document) CanUndo() bool {
+	return len(d.undo) > 0
+}
+
+// CanRedo returns true if there is an undone edit to redo.
+func (d *Document) CanRedo() bool {
+	return len(d.redo) > 0
+}
+
+// Undo undoes the last edit. False is returned if there is none.
+func (d *Document) Undo() bool {
+	if len(d.undo) == 0 {
+		return false
+	}
+
+	d.redo = append(d.redo, d.state)
+	d.state = d.undo[len(d.undo)-1]
+	d.undo = d.undo[:len(d.undo)-1]
+	return true
+}
+
+// Redo redoes the last undone edit. False is returned if there is none.
+func (d *Document) Redo() bool {
+	if len(d.redo) == 0 {
+		return false
+	}
+
+	d.undo = append(d.undo, d.state)
+	d.state = d.redo[len(d.redo)-1]
+	d.redo = d.redo[:len(d.redo)-1]
+	return true
+}
+
+// Checkpoint starts a new edit, which can be undone on its own. Edits other
+// than Set and Line start their own.
+func (d *Document) Checkpoint() {
+	d.undo = append(d.undo, d.state.copy())
+	if len(d.undo) > maxUndo {
+		d.undo = d.undo[len(d.undo)-maxUndo:]
+	}
+	d.redo = nil
+
+	d.revs++
+	d.state.rev = d.revs
+}
+
+// Set sets the value within [0, 1] of a channel at point i.
+func (d *Document) Set(ch, i int, v float64) {
+	if d.contains(ch, i) {
+		d.state.points[i][ch] = playback.Strength(v)
+	}
+}
+
+// Line sets the values of a channel from point i0 to point i1 along a
+// straight line from v0 to v1.
+func (d *Document) Line(ch, i0 int, v0 float64, i1 int, v1 float64) {
+	if i0 > i1 {
+		i0, v0, i1, v1 = i1, v1, i0, v0
+	}
+
+	if i0 == i1 {
+		d.Set(ch, i1, v1)
+		return
+	}
+
+	for i := i0; i <= i1; i++ {
+		t := float64(i-i0) / float64(i1-i0)
+		d.Set(ch, i, v0+(v1-v0)*t)
+	}
+}
+
+// SetInterval sets the time between two points, which is rounded to
+// milliseconds.
+func (d *Document) SetInterval(interval time.Duration) {
+	interval = roundInterval(interval)
+	if interval == d.state.interval {
+		return
+	}
+
+	d.Checkpoint()
+	d.state.interval = interval
+}
+
+// SetFeature sets the feature of a channel.
+func (d *Document) SetFeature(ch int, f pattern.Feature) {
+	if ch < 0 || ch >= d.Channels() || d.state.features[ch] == f {
+		return
+	}
+
+	d.Checkpoint()
+	d.state.features[ch] = f
+}
+
+// AddChannel adds a channel with the given feature, whose values are all 0.
+func (d *Document) AddChannel(f pattern.Feature) {
+	d.Checkpoint()
+	d.state.features = append(d.state.features, f)
+	for i, point := range d.state.points {
+		d.state.points[i] = append(point, 0)
+	}
+}
+
+// RemoveChannel removes a channel. The last channel cannot be removed.
+func (d *Document) RemoveChannel(ch int) {
+	if ch < 0 || ch >= d.Channels() || d.Channels() == 1 {
+		return
+	}
+
+	d.Checkpoint()
+	d.state.features = append(d.state.features[:ch], d.state.features[ch+1:]...)
+	for i, point := range d.state.points {
+		d.state.points[i] = append(point[:ch], point[ch+1:]...)
+	}
+}
+
+// SetLength sets the number of points, which is at least 1. Points are
+// added or removed at the end; added points are 0.
+func (d *Document) SetLength(length int) {
+	if length < 1 {
+		length = 1
+	}
+	if length == d.Len() {
+		return
+	}
+
+	d.Checkpoint()
+	d.resize(length)
+}
+
+func (d *Document) resize(length int) {
+	if length < len(d.state.points) {
+		d.state.points = d.state.points[:length]
+		return
+	}
+
+	for len(d.state.points) < length {
+		d.state.points = append(d.state.points, make(pattern.Point, d.Channels()))
+	}
+}
+
+// Clip is a copied range of points.
+type Clip struct {
+	points pattern.Points
+}
+
+// Len returns the number of points in the clip.
+func (c Clip) Len() int {
+	return len(c.points)
+}
+
+// clamp clamps the range [from, to) of points into the document.
+func (d *Document) clamp(from, to int) (int, int) {
+	if from < 0 {
+		from = 0
+	}
+	if to > d.Len() {
+		to = d.Len()
+	}
+	if to < from {
+		to = from
+	}
+	return from, to
+}
+
+// Copy copies the points in the range [from, to).
+func (d *Document) Copy(from, to int) Clip {
+	from, to = d.clamp(from, to)
+
+	points := make(pattern.Points, 0, to-from)
+	for _, point := range d.state.points[from:to] {
+		points = append(points, append(pattern.Point(nil), point...))
+	}
+
+	return Clip{points: points}
+}
+
+// Cut copies the points in the range [from, to) and removes them. At least
+// one point is always kept.
+func (d *Document) Cut(from, to int) Clip {
+	clip := d.Copy(from, to)
+	if clip.Len() == 0 {
+		return clip
+	}
+
+	from, to = d.clamp(from, to)
+
+	d.Checkpoint()
+	d.state.points = append(d.state.points[:from], d.state.points[to:]...)
+	if len(d.state.points) == 0 {
+		d.resize(1)
+	}
+
+	return clip
+}
+
+// Paste writes the points of a clip over those starting at point at, adding
+// points at the end if needed. The channels of the clip are pasted onto those
+// with the same index; the ones that the document doesn't have are dropped,
+// and the ones that the clip doesn't have are left alone.
+func (d *Document) Paste(at int, clip Clip) {
+	if clip.Len() == 0 || at < 0 {
+		return
+	}
+
+	d.Checkpoint()
+	if end := at + clip.Len(); end > d.Len() {
+		d.resize(end)
+	}
+
+	for i, point := range clip.points {
+		copy(d.state.points[at+i], point)
+	}
+}
@@ -0,0 +1,331 @@
+package patternedit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+)
+
+// assertValues asserts the values of a channel at every point.
+func assertValues(t *testing.T, d *Document, ch int, want ...float64) {
+	t.Helper()
+
+	got := d.Values(ch)
+	if len(got) != len(want) {
+		t.Fatalf("channel %d has values %v, want %v", ch, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("channel %d has values %v, want %v", ch, got, want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	d := New(nil, 1500*time.Microsecond, 0)
+
+	if d.Len() != 1 {
+		t.Errorf("Len = %d, want 1", d.Len())
+	}
+	if f := d.Features(); len(f) != 1 || f[0] != pattern.Vibrate {
+		t.Errorf("Features = %v, want [v]", f)
+	}
+	if d.Interval() != 2*time.Millisecond {
+		t.Errorf("Interval = %v, want 2ms", d.Interval())
+	}
+	if d.Modified() || d.CanUndo() || d.CanRedo() {
+		t.Error("new document has history")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	p := &pattern.Pattern{Points: pattern.Points{{0, 20}, {10, 5}}}
+	p.Version = pattern.V1
+	p.Interval = 100 * time.Millisecond
+
+	d := Open(p)
+
+	if f := d.Features(); len(f) != 2 || f[0] != "v1" || f[1] != "v2" {
+		t.Fatalf("Features = %v, want [v1 v2]", f)
+	}
+	assertValues(t, d, 0, 0, 0.5)
+	assertValues(t, d, 1, 1, 0.25)
+
+	if d.Modified() || d.CanUndo() {
+		t.Error("opened document has history")
+	}
+
+	// The document is a copy.
+	d.Set(0, 0, 1)
+	if p.Points[0][0] != 0 {
+		t.Error("editing the document changed the pattern")
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	d := New(nil, time.Second, 2)
+
+	d.Checkpoint()
+	d.Set(0, 0, 0.5)
+	d.Checkpoint()
+	d.Set(0, 1, 0.25)
+	assertValues(t, d, 0, 0.5, 0.25)
+
+	if !d.Undo() {
+		t.Fatal("nothing to undo")
+	}
+	assertValues(t, d, 0, 0.5, 0)
+
+	if !d.Undo() {
+		t.Fatal("nothing to undo")
+	}
+	assertValues(t, d, 0, 0, 0)
+
+	if d.Undo() || d.CanUndo() {
+		t.Fatal("undid past the first edit")
+	}
+
+	if !d.Redo() {
+		t.Fatal("nothing to redo")
+	}
+	assertValues(t, d, 0, 0.5, 0)
+
+	// A new edit drops what was undone.
+	d.Checkpoint()
+	d.Set(0, 1, 1)
+	if d.CanRedo() || d.Redo() {
+		t.Fatal("redid after a new edit")
+	}
+	assertValues(t, d, 0, 0.5, 1)
+}
+
+func TestStroke(t *testing.T) {
+	d := New(nil, time.Second, 5)
+
+	// Set and Line belong to the edit of the last Checkpoint.
+	d.Checkpoint()
+	d.Set(0, 0, 1)
+	d.Line(0, 1, 0.25, 4, 1)
+	d.Set(0, 4, 0.5)
+	assertValues(t, d, 0, 1, 0.25, 0.5, 0.75, 0.5)
+
+	d.Undo()
+	assertValues(t, d, 0, 0, 0, 0, 0, 0)
+	if d.CanUndo() {
+		t.Fatal("stroke was split into several edits")
+	}
+}
+
+func TestLine(t *testing.T) {
+	d := New(nil, time.Second, 5)
+
+	d.Line(0, 4, 1, 0, 0)
+	assertValues(t, d, 0, 0, 0.25, 0.5, 0.75, 1)
+
+	d.Line(0, 2, 0.5, 2, 0)
+	assertValues(t, d, 0, 0, 0.25, 0, 0.75, 1)
+
+	// Points outside of the document are dropped.
+	d.Line(0, 3, 0, 6, 0.75)
+	assertValues(t, d, 0, 0, 0.25, 0, 0, 0.25)
+}
+
+func TestMaxUndo(t *testing.T) {
+	d := New(nil, time.Second, 1)
+
+	for i := 0; i < maxUndo+10; i++ {
+		d.Checkpoint()
+	}
+
+	var undone int
+	for d.Undo() {
+		undone++
+	}
+
+	if undone != maxUndo {
+		t.Fatalf("undid %d edits, want %d", undone, maxUndo)
+	}
+}
+
+func TestModified(t *testing.T) {
+	d := New(nil, time.Second, 2)
+
+	d.Checkpoint()
+	d.Set(0, 0, 1)
+	if !d.Modified() {
+		t.Fatal("edited document isn't modified")
+	}
+
+	d.MarkSaved()
+	if d.Modified() {
+		t.Fatal("saved document is modified")
+	}
+
+	d.Undo()
+	if !d.Modified() {
+		t.Fatal("document isn't modified after undoing past the save")
+	}
+
+	d.Redo()
+	if d.Modified() {
+		t.Fatal("document is modified after redoing back to the save")
+	}
+
+	d.Undo()
+	d.Checkpoint()
+	d.Set(0, 0, 1)
+	if !d.Modified() {
+		t.Fatal("a new edit has the revision of the saved one")
+	}
+}
+
+func TestEditsCheckpoint(t *testing.T) {
+	edits := []struct {
+		name string
+		edit func(d *Document)
+	}{
+		{"SetInterval", func(d *Document) { d.SetInterval(time.Second) }},
+		{"SetFeature", func(d *Document) { d.SetFeature(0, pattern.Rotate) }},
+		{"AddChannel", func(d *Document) { d.AddChannel(pattern.Rotate) }},
+		{"RemoveChannel", func(d *Document) { d.RemoveChannel(1) }},
+		{"SetLength", func(d *Document) { d.SetLength(5) }},
+		{"Cut", func(d *Document) { d.Cut(0, 1) }},
+		{"Paste", func(d *Document) { d.Paste(0, d.Copy(1, 2)) }},
+	}
+
+	for _, test := range edits {
+		t.Run(test.name, func(t *testing.T) {
+			d := New([]pattern.Feature{"v1", "v2"}, 100*time.Millisecond, 3)
+			d.Set(0, 1, 0.5)
+			before := d.Pattern()
+
+			test.edit(d)
+			if !d.Modified() || !d.Undo() {
+				t.Fatal("edit cannot be undone")
+			}
+
+			after := d.Pattern()
+			if after.Interval != before.Interval || len(after.Features) != len(before.Features) ||
+				len(after.Points) != len(before.Points) || after.Points[1][0] != before.Points[1][0] {
+				t.Fatalf("undo gave %+v, want %+v", after, before)
+			}
+		})
+	}
+}
+
+func TestNoopEdits(t *testing.T) {
+	d := New(nil, 100*time.Millisecond, 3)
+
+	d.SetInterval(100 * time.Millisecond)
+	d.SetFeature(0, pattern.Vibrate)
+	d.SetFeature(1, pattern.Rotate)
+	d.RemoveChannel(0)
+	d.SetLength(3)
+	d.Cut(3, 5)
+	d.Paste(0, Clip{})
+	d.Paste(-1, d.Copy(0, 1))
+
+	if d.CanUndo() || d.Modified() {
+		t.Fatal("an edit that changes nothing can be undone")
+	}
+}
+
+func TestCut(t *testing.T) {
+	d := New([]pattern.Feature{"v1", "v2"}, time.Second, 5)
+	d.Line(0, 0, 0, 4, 1)
+
+	clip := d.Cut(1, 3)
+	if clip.Len() != 2 {
+		t.Fatalf("cut %d points, want 2", clip.Len())
+	}
+	assertValues(t, d, 0, 0, 0.75, 1)
+	assertValues(t, d, 1, 0, 0, 0)
+
+	// The range is clamped, and at least one point is kept.
+	clip = d.Cut(-5, 10)
+	if clip.Len() != 3 {
+		t.Fatalf("cut %d points, want 3", clip.Len())
+	}
+	assertValues(t, d, 0, 0)
+	assertValues(t, d, 1, 0)
+
+	d.Undo()
+	assertValues(t, d, 0, 0, 0.75, 1)
+}
+
+func TestPaste(t *testing.T) {
+	d := New([]pattern.Feature{"v1", "v2"}, time.Second, 3)
+	d.Line(0, 0, 0, 2, 1)
+	d.Line(1, 0, 1, 2, 0)
+
+	// Pasting past the end grows the document.
+	d.Paste(2, d.Copy(0, 2))
+	assertValues(t, d, 0, 0, 0.5, 0, 0.5)
+	assertValues(t, d, 1, 1, 0.5, 1, 0.5)
+
+	// Channels that the document doesn't have are dropped.
+	wide := New([]pattern.Feature{"v1", "v2", "v3"}, time.Second, 1)
+	wide.Set(0, 0, 0.25)
+	wide.Set(1, 0, 0.25)
+	wide.Set(2, 0, 0.25)
+	d.Paste(0, wide.Copy(0, 1))
+	assertValues(t, d, 0, 0.25, 0.5, 0, 0.5)
+	assertValues(t, d, 1, 0.25, 0.5, 1, 0.5)
+
+	// Channels that the clip doesn't have are left alone.
+	narrow := New(nil, time.Second, 1)
+	narrow.Set(0, 0, 1)
+	d.Paste(1, narrow.Copy(0, 1))
+	assertValues(t, d, 0, 0.25, 1, 0, 0.5)
+	assertValues(t, d, 1, 0.25, 0.5, 1, 0.5)
+
+	// The clip is a copy.
+	clip := d.Copy(0, 1)
+	d.Set(0, 0, 0)
+	d.Paste(3, clip)
+	assertValues(t, d, 0, 0, 1, 0, 0.25)
+}
+
+func TestChannels(t *testing.T) {
+	d := New(nil, time.Second, 2)
+
+	d.AddChannel(pattern.Rotate)
+	d.Set(1, 0, 1)
+	if d.Channels() != 2 {
+		t.Fatalf("Channels = %d, want 2", d.Channels())
+	}
+	assertValues(t, d, 1, 1, 0)
+
+	d.RemoveChannel(0)
+	if f := d.Features(); len(f) != 1 || f[0] != pattern.Rotate {
+		t.Fatalf("Features = %v, want [r]", f)
+	}
+	assertValues(t, d, 0, 1, 0)
+
+	// The last channel cannot be removed.
+	d.RemoveChannel(0)
+	if d.Channels() != 1 {
+		t.Fatalf("Channels = %d, want 1", d.Channels())
+	}
+}
+
+func TestSetLength(t *testing.T) {
+	d := New(nil, time.Second, 2)
+	d.Set(0, 1, 1)
+
+	d.SetLength(4)
+	assertValues(t, d, 0, 0, 1, 0, 0)
+
+	d.SetLength(1)
+	assertValues(t, d, 0, 0)
+
+	d.SetLength(0)
+	if d.Len() != 1 {
+		t.Fatalf("Len = %d, want 1", d.Len())
+	}
+
+	if d.Duration() != time.Second {
+		t.Fatalf("Duration = %v, want 1s", d.Duration())
+	}
+}
@@ -0,0 +1,131 @@
+package patternedit
+
+import (
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+)
+
+const (
+	// MinBPM is the slowest tempo of a sequence.
+	MinBPM = 20
+	// MaxBPM is the fastest tempo of a sequence.
+	MaxBPM = 300
+)
+
+// Sequence is a grid of steps that are played one after another at a tempo.
+// It has a row of cells for every channel, each holding the value of the
+// channel during the step.
+type Sequence struct {
+	// BPM is the tempo in beats per minute.
+	BPM float64
+	// StepsPerBeat is the number of steps in a beat.
+	StepsPerBeat int
+	// Features holds the feature of every row.
+	Features []pattern.Feature
+	// Cells holds the values within [0, 1] of every row, then of every step.
+	Cells [][]float64
+}
+
+// NewSequence creates a sequence of empty steps at 120 BPM with 4 steps per
+// beat, with one row for each feature.
+func NewSequence(features []pattern.Feature, steps int) *Sequence {
+	if len(features) == 0 {
+		features = []pattern.Feature{pattern.Vibrate}
+	}
+
+	s := &Sequence{
+		BPM:          120,
+		StepsPerBeat: 4,
+	}
+	for _, f := range features {
+		s.AddRow(f)
+	}
+	s.SetSteps(steps)
+
+	return s
+}
+
+// Rows returns the number of rows.
+func (s *Sequence) Rows() int {
+	return len(s.Cells)
+}
+
+// Steps returns the number of steps.
+func (s *Sequence) Steps() int {
+	if len(s.Cells) == 0 {
+		return 0
+	}
+	return len(s.Cells[0])
+}
+
+// SetSteps sets the number of steps, which is at least 1. Steps are added or
+// removed at the end; added steps are empty.
+func (s *Sequence) SetSteps(steps int) {
+	if steps < 1 {
+		steps = 1
+	}
+
+	for i, row := range s.Cells {
+		if steps <= len(row) {
+			s.Cells[i] = row[:steps]
+		} else {
+			s.Cells[i] = append(row, make([]float64, steps-len(row))...)
+		}
+	}
+}
+
+// AddRow adds a row of empty steps with the given feature.
+func (s *Sequence) AddRow(f pattern.Feature) {
+	s.Features = append(s.Features, f)
+	s.Cells = append(s.Cells, make([]float64, s.Steps()))
+}
+
+// RemoveRow removes a row. The last row cannot be removed.
+func (s *Sequence) RemoveRow(row int) {
+	if row < 0 || row >= s.Rows() || s.Rows() == 1 {
+		return
+	}
+
+	s.Features = append(s.Features[:row], s.Features[row+1:]...)
+	s.Cells = append(s.Cells[:row], s.Cells[row+1:]...)
+}
+
+// StepDuration returns the duration of a step, rounded to milliseconds as
+// patterns are.
+func (s *Sequence) StepDuration() time.Duration {
+	bpm := s.BPM
+	if bpm < MinBPM {
+		bpm = MinBPM
+	}
+	if bpm > MaxBPM {
+		bpm = MaxBPM
+	}
+
+	steps := s.StepsPerBeat
+	if steps < 1 {
+		steps = 1
+	}
+
+	return roundInterval(time.Duration(float64(time.Minute) / (bpm * float64(steps))))
+}
+
+// Pattern returns the sequence as a version 1 pattern with a point for every
+// step.
+func (s *Sequence) Pattern() *pattern.Pattern {
+	points := make(pattern.Points, s.Steps())
+	for step := range points {
+		point := make(pattern.Point, s.Rows())
+		for row := range point {
+			point[row] = playback.Strength(s.Cells[row][step])
+		}
+		points[step] = point
+	}
+
+	p := &pattern.Pattern{Points: points}
+	p.Version = 1
+	p.Interval = s.StepDuration()
+	p.Features = append([]pattern.Feature(nil), s.Features...)
+	return p
+}
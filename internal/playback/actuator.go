@@ -0,0 +1,128 @@
+package playback
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/diamondburned/go-lovense/pattern"
+)
+
+// Actuator is a type of actuator that an output drives.
+type Actuator int
+
+const (
+	// Vibrator is a vibration motor. Values are its speed.
+	Vibrator Actuator = iota
+	// Rotator is a rotating motor. Values are its speed.
+	Rotator
+	// Linear is a linear actuator, which is also how air pumps are exposed.
+	// Values are its position.
+	Linear
+)
+
+// Actuators lists all actuator types in their fallback order.
+var Actuators = []Actuator{Vibrator, Rotator, Linear}
+
+// String returns the name of the actuator type as stored in the settings.
+func (a Actuator) String() string {
+	switch a {
+	case Vibrator:
+		return "vibrator"
+	case Rotator:
+		return "rotator"
+	case Linear:
+		return "linear"
+	default:
+		return fmt.Sprintf("Actuator(%d)", int(a))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (a Actuator) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (a *Actuator) UnmarshalText(text []byte) error {
+	for _, actuator := range Actuators {
+		if actuator.String() == string(text) {
+			*a = actuator
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown actuator %q", text)
+}
+
+// FeatureActuator returns the actuator type that matches a pattern feature.
+// Unknown features are assumed to be vibrators.
+func FeatureActuator(f pattern.Feature) Actuator {
+	switch f {
+	case pattern.Rotate:
+		return Rotator
+	case pattern.AirPump:
+		return Linear
+	default:
+		return Vibrator
+	}
+}
+
+// Motors lists the motor indices of a device by actuator type.
+type Motors map[Actuator][]int
+
+// actuatorFor returns the actuator type that a channel with the given feature
+// drives on the device: the matching type if the device has it, otherwise the
+// first type in Actuators that it has.
+func (m Motors) actuatorFor(f pattern.Feature) (Actuator, bool) {
+	if actuator := FeatureActuator(f); len(m[actuator]) > 0 {
+		return actuator, true
+	}
+
+	for _, actuator := range Actuators {
+		if len(m[actuator]) > 0 {
+			return actuator, true
+		}
+	}
+
+	return 0, false
+}
+
+// Layout describes the channels of a pattern, such as "v1,v2", or just their
+// number if the pattern doesn't declare its features. Patterns with the same
+// layout can share a mapping.
+func Layout(p *pattern.Pattern) string {
+	if len(p.Features) == 0 {
+		return strconv.Itoa(Channels(p))
+	}
+
+	features := make([]string, len(p.Features))
+	for i, feature := range p.Features {
+		features[i] = string(feature)
+	}
+	return strings.Join(features, ",")
+}
+
+// Features returns the features of a pattern that has one channel for every
+// given motor, in the order of Actuators and then of the motors. A single
+// vibrator is "v", while several are "v1", "v2" and so on.
+func Features(motors Motors) []pattern.Feature {
+	var features []pattern.Feature
+
+	for _, actuator := range Actuators {
+		n := len(motors[actuator])
+		for i := 0; i < n; i++ {
+			switch {
+			case actuator == Rotator:
+				features = append(features, pattern.Rotate)
+			case actuator == Linear:
+				features = append(features, pattern.AirPump)
+			case n == 1:
+				features = append(features, pattern.Vibrate)
+			default:
+				features = append(features, pattern.Feature("v"+strconv.Itoa(i+1)))
+			}
+		}
+	}
+
+	return features
+}
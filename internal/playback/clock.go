@@ -0,0 +1,39 @@
+package playback
+
+import "time"
+
+// Clock is the time source of an Engine. Its times must be monotonic.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer creates a timer that fires once after d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by a Clock. It behaves like a time.Timer.
+type Timer interface {
+	// C returns the channel that the time is sent over when the timer fires.
+	C() <-chan time.Time
+	// Stop stops the timer. It returns false if the timer has already fired
+	// or been stopped.
+	Stop() bool
+	// Reset changes the timer to fire after d. It must only be called on a
+	// stopped or fired timer with a drained channel.
+	Reset(d time.Duration)
+}
+
+// SystemClock is the Clock backed by package time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time   { return t.Timer.C }
+func (t systemTimer) Reset(d time.Duration) { t.Timer.Reset(d) }
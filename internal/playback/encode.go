@@ -0,0 +1,120 @@
+package playback
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+)
+
+// Encode writes p in the version 1 pattern format, which Load reads back.
+// Points of a version 0 pattern are converted to the version 1 scale. The
+// interval is rounded to milliseconds.
+func Encode(w io.Writer, p *pattern.Pattern) error {
+	if err := Validate(p); err != nil {
+		return err
+	}
+
+	interval := p.Interval.Round(time.Millisecond) / time.Millisecond
+	if interval < 1 {
+		return fmt.Errorf("pattern error: interval %v is under 1ms", p.Interval)
+	}
+
+	channels := Channels(p)
+	features := p.Features
+	if len(features) != channels {
+		features = make([]pattern.Feature, channels)
+		for i := range features {
+			features[i] = pattern.Vibrate
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString("V:1;")
+	if p.Type != "" {
+		bw.WriteString("T:" + p.Type + ";")
+	}
+	bw.WriteString("F:")
+	for i, feature := range features {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString(string(feature))
+	}
+	bw.WriteString(";S:" + strconv.FormatInt(int64(interval), 10) + ";#\n")
+
+	for _, point := range p.Points {
+		for ch := 0; ch < channels; ch++ {
+			if ch > 0 {
+				bw.WriteByte(',')
+			}
+
+			var s pattern.Strength
+			if ch < len(point) {
+				s = point[ch]
+				if p.Version != pattern.V1 {
+					s = Strength(s.Scale(p.Version))
+				}
+			}
+
+			bw.WriteString(strconv.Itoa(int(s)))
+		}
+		bw.WriteByte(';')
+	}
+
+	return bw.Flush()
+}
+
+// Marshal returns p encoded by Encode.
+func Marshal(p *pattern.Pattern) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MaxStrength is the highest strength of a version 1 pattern.
+const MaxStrength = 20
+
+// Strength converts a value within [0, 1] into the nearest strength of a
+// version 1 pattern.
+func Strength(v float64) pattern.Strength {
+	return pattern.Strength(math.Round(clamp(v) * MaxStrength))
+}
+
+// Trim returns a copy of p with only the points between a and b. Points that
+// start before b are kept.
+func Trim(p *pattern.Pattern, a, b time.Duration) (*pattern.Pattern, error) {
+	if p.Interval <= 0 {
+		return nil, fmt.Errorf("pattern error: invalid interval %v", p.Interval)
+	}
+
+	from := int(a / p.Interval)
+	to := int((b + p.Interval - 1) / p.Interval)
+	if from < 0 {
+		from = 0
+	}
+	if to > len(p.Points) {
+		to = len(p.Points)
+	}
+	if from >= to {
+		return nil, errors.New("nothing is left after trimming")
+	}
+
+	trimmed := &pattern.Pattern{Header: p.Header}
+	trimmed.Features = append([]pattern.Feature(nil), p.Features...)
+	trimmed.Points = make(pattern.Points, to-from)
+	for i, point := range p.Points[from:to] {
+		trimmed.Points[i] = append(pattern.Point(nil), point...)
+	}
+
+	return trimmed, nil
+}
@@ -0,0 +1,692 @@
+// Package playback plays Lovense patterns in the background, independently of
+// any UI.
+package playback
+
+import (
+	"sync"
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+)
+
+const (
+	// MinSpeed is the slowest playback speed.
+	MinSpeed = 0.25
+	// MaxSpeed is the fastest playback speed.
+	MaxSpeed = 4
+	// MaxIntensity is the highest intensity that values can be scaled by.
+	MaxIntensity = 2
+)
+
+// Engine plays a pattern onto a Sink in its own goroutine. The position is
+// computed from the clock instead of counting ticks, so playback doesn't drift
+// when the goroutine wakes up late. Frames that are missed this way are
+// skipped. What happens at the end of the pattern depends on the LoopMode; by
+// default, it loops forever.
+//
+// An Engine is safe to use concurrently.
+type Engine struct {
+	pattern *pattern.Pattern
+	sink    Sink
+	clock   Clock
+
+	mutex   sync.Mutex
+	mapping Mapping
+	interp  Interpolation
+	steps   int
+	speed   float64
+	gain    float64
+	offsets map[int]float64 // by device
+	loop    LoopMode
+	count   int
+	regionA time.Duration
+	regionB time.Duration
+	onEnd   func()
+	fadeIn  time.Duration
+	fadeOut time.Duration
+	onFade  func()
+	fading  bool // fading out
+	level   float64
+	started time.Time
+	offset  time.Duration // timeline at started
+	frame   int
+	substep int
+	outputs map[Output]float64
+	stop    chan struct{}
+	done    chan struct{}
+	wake    chan struct{}
+}
+
+// NewEngine creates a new paused Engine that uses the system clock. The
+// pattern's channels are sent to the sink through the given mapping. p must be
+// valid; see Validate.
+func NewEngine(p *pattern.Pattern, sink Sink, m Mapping) *Engine {
+	return NewEngineWithClock(p, sink, m, SystemClock)
+}
+
+// NewEngineWithClock creates a new paused Engine that uses the given clock.
+func NewEngineWithClock(p *pattern.Pattern, sink Sink, m Mapping, clock Clock) *Engine {
+	return &Engine{
+		pattern: p,
+		sink:    sink,
+		clock:   clock,
+		mapping: m.Fit(Channels(p)),
+		steps:   DefaultSteps,
+		speed:   1,
+		gain:    1,
+		count:   1,
+		frame:   -1,
+	}
+}
+
+// Channels returns the number of channels, or motors, in the pattern.
+func Channels(p *pattern.Pattern) int {
+	if len(p.Features) > 0 {
+		return len(p.Features)
+	}
+
+	var n int
+	for _, point := range p.Points {
+		if len(point) > n {
+			n = len(point)
+		}
+	}
+	return n
+}
+
+// Pattern returns the pattern that the engine plays.
+func (e *Engine) Pattern() *pattern.Pattern {
+	return e.pattern
+}
+
+// Duration returns the total duration of the pattern.
+func (e *Engine) Duration() time.Duration {
+	return e.pattern.Interval * time.Duration(len(e.pattern.Points))
+}
+
+// Mapping returns a copy of the current channel mapping.
+func (e *Engine) Mapping() Mapping {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.mapping.Copy()
+}
+
+// SetMapping sets the channel mapping. It takes effect right away. Outputs
+// that the new mapping doesn't drive anymore are set to 0.
+func (e *Engine) SetMapping(m Mapping) {
+	m = m.Fit(Channels(e.pattern))
+
+	e.mutex.Lock()
+
+	dropped := e.mapping.Zero()
+	for _, output := range m.Outputs() {
+		delete(dropped, output)
+	}
+
+	e.mapping = m
+	e.frame = -1
+	e.wakeUp()
+	e.mutex.Unlock()
+
+	if len(dropped) > 0 {
+		e.sink.Send(dropped)
+	}
+}
+
+// SetInterpolation sets how values between two points are computed. It takes
+// effect right away.
+func (e *Engine) SetInterpolation(i Interpolation) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.interp = i
+	e.frame = -1
+	e.wakeUp()
+}
+
+// SetSteps sets the number of steps that the device's motors have, which
+// limits how many interpolated values are sent between two points.
+func (e *Engine) SetSteps(steps int) {
+	if steps <= 0 {
+		steps = DefaultSteps
+	}
+
+	e.mutex.Lock()
+	e.steps = steps
+	e.mutex.Unlock()
+}
+
+// Speed returns the playback speed.
+func (e *Engine) Speed() float64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.speed
+}
+
+// SetSpeed sets the playback speed, which is clamped to be within [MinSpeed,
+// MaxSpeed]. It takes effect right away without moving the position.
+func (e *Engine) SetSpeed(speed float64) {
+	if speed < MinSpeed {
+		speed = MinSpeed
+	}
+	if speed > MaxSpeed {
+		speed = MaxSpeed
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.stop != nil {
+		now := e.clock.Now()
+		e.offset = e.timeline(now)
+		e.started = now
+	}
+
+	e.speed = speed
+	e.wakeUp()
+}
+
+// Intensity returns the intensity that values are scaled by.
+func (e *Engine) Intensity() float64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.gain
+}
+
+// SetIntensity sets the intensity that values are scaled by. It is clamped to
+// be within [0, MaxIntensity]; scaled values are clamped to be within [0, 1].
+// It takes effect right away.
+func (e *Engine) SetIntensity(intensity float64) {
+	if intensity < 0 {
+		intensity = 0
+	}
+	if intensity > MaxIntensity {
+		intensity = MaxIntensity
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.gain = intensity
+	e.frame = -1
+	e.wakeUp()
+}
+
+// DeviceOffset returns the intensity offset of the device with the given
+// index.
+func (e *Engine) DeviceOffset(device int) float64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.offsets[device]
+}
+
+// SetDeviceOffset sets an offset that's added to the intensity of the device
+// with the given index, so that devices played together can be balanced
+// against each other. The resulting intensity is clamped to be within [0,
+// MaxIntensity]. It takes effect right away.
+func (e *Engine) SetDeviceOffset(device int, offset float64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if offset == 0 {
+		delete(e.offsets, device)
+	} else {
+		if e.offsets == nil {
+			e.offsets = make(map[int]float64, 1)
+		}
+		e.offsets[device] = offset
+	}
+
+	e.frame = -1
+	e.wakeUp()
+}
+
+// intensity returns the intensity of the device with the given index. The
+// mutex must be held.
+func (e *Engine) intensity(device int) float64 {
+	gain := e.gain + e.offsets[device]
+	if gain < 0 {
+		return 0
+	}
+	if gain > MaxIntensity {
+		return MaxIntensity
+	}
+	return gain
+}
+
+// Loop returns the loop mode and the number of times that LoopRepeat plays the
+// region.
+func (e *Engine) Loop() (LoopMode, int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.loop, e.count
+}
+
+// SetLoop sets the loop mode. count is the number of times that LoopRepeat
+// plays the region; it's at least 1. The loop count is restarted from the
+// current position.
+func (e *Engine) SetLoop(mode LoopMode, count int) {
+	if count < 1 {
+		count = 1
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	pos := e.location().pos
+
+	e.loop = mode
+	e.count = count
+	e.rebase(e.timelineAt(pos, 0))
+}
+
+// Region returns the region that's played. If no region is set, then the
+// whole pattern is returned.
+func (e *Engine) Region() (a, b time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.region()
+}
+
+// HasRegion returns true if an A-B loop region is set.
+func (e *Engine) HasRegion() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.regionB > e.regionA
+}
+
+// SetRegion sets the A-B loop region, so that only the part between a and b
+// is played. If b isn't after a, then the region is cleared. The position is
+// moved into the region, and the loop count is restarted.
+func (e *Engine) SetRegion(a, b time.Duration) {
+	if a < 0 {
+		a = 0
+	}
+	if total := e.Duration(); b > total {
+		b = total
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	pos := e.location().pos
+
+	if b > a {
+		e.regionA, e.regionB = a, b
+	} else {
+		e.regionA, e.regionB = 0, 0
+	}
+
+	e.rebase(e.timelineAt(pos, 0))
+}
+
+// Pass returns the number of times that the region has been fully played.
+func (e *Engine) Pass() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.location().pass
+}
+
+// OnEnd sets the function that's called once playback ends by itself. It's
+// called from the engine's goroutine after the motors are set to 0.
+func (e *Engine) OnEnd(f func()) {
+	e.mutex.Lock()
+	e.onEnd = f
+	e.mutex.Unlock()
+}
+
+// IsPlaying returns true if the engine is playing.
+func (e *Engine) IsPlaying() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.stop != nil
+}
+
+// Play starts or resumes playback from the current position.
+func (e *Engine) Play() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.stop != nil || Validate(e.pattern) != nil {
+		return
+	}
+
+	e.started = e.clock.Now()
+	e.frame = -1
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	e.wake = make(chan struct{}, 1)
+
+	go e.run(e.stop, e.done, e.wake)
+}
+
+// Pause pauses playback and sets all mapped motors to 0. Once it returns,
+// nothing else is sent to the sink.
+func (e *Engine) Pause() {
+	e.mutex.Lock()
+	if e.stop == nil {
+		e.mutex.Unlock()
+		return
+	}
+
+	e.offset = e.timeline(e.clock.Now())
+	close(e.stop)
+	done := e.done
+
+	e.stop = nil
+	e.done = nil
+	e.wake = nil
+	e.mutex.Unlock()
+
+	<-done
+
+	e.mutex.Lock()
+	zeroes := e.mapping.Zero()
+	e.mutex.Unlock()
+
+	e.sink.Send(zeroes)
+}
+
+// Seek moves the position to the given duration into the pattern. The
+// position is clamped into the region, and the loop count is kept. If the
+// engine is playing, then the frame at that position is played right away.
+func (e *Engine) Seek(pos time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	loc := e.location()
+	if loc.ended {
+		loc.pass = 0
+	}
+
+	e.rebase(e.timelineAt(pos, loc.pass))
+}
+
+// Position returns the current position into the pattern.
+func (e *Engine) Position() time.Duration {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.location().pos
+}
+
+// Frame returns the index of the last played frame and the output values that
+// it was mapped to. The index is -1 if no frame has been played since the
+// engine was started or seeked. The returned map must not be modified.
+func (e *Engine) Frame() (int, map[Output]float64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.frame, e.outputs
+}
+
+// wakeUp makes the goroutine play the current frame right away. The mutex must
+// be held.
+func (e *Engine) wakeUp() {
+	if e.wake != nil {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// rebase restarts the timeline from t. The mutex must be held.
+func (e *Engine) rebase(t time.Duration) {
+	e.offset = t
+	e.started = e.clock.Now()
+	e.frame = -1
+	e.wakeUp()
+}
+
+// timeline returns the timeline at the given time. The mutex must be held.
+func (e *Engine) timeline(now time.Time) time.Duration {
+	if e.stop == nil {
+		return e.offset
+	}
+	return e.offset + e.scale(now.Sub(e.started))
+}
+
+// location returns the current location. The mutex must be held.
+func (e *Engine) location() location {
+	return e.locate(e.timeline(e.clock.Now()))
+}
+
+// scale converts a duration of real time into pattern time. The mutex must be
+// held.
+func (e *Engine) scale(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * e.speed)
+}
+
+// unscale converts a duration of pattern time into real time. The mutex must
+// be held.
+func (e *Engine) unscale(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / e.speed)
+}
+
+func (e *Engine) run(stop, done, wake chan struct{}) {
+	defer close(done)
+
+	timer := e.clock.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C():
+				default:
+				}
+			}
+		case <-timer.C():
+		}
+
+		next, outputs, ok, ended, fadeOut := e.step()
+		if ok {
+			e.sink.Send(outputs)
+		}
+		if fadeOut != nil {
+			fadeOut()
+		}
+		if ended {
+			e.end(stop)
+			return
+		}
+
+		timer.Reset(next)
+	}
+}
+
+// end stops the engine once playback ends by itself, unless it has been paused
+// in the meantime.
+func (e *Engine) end(stop chan struct{}) {
+	e.mutex.Lock()
+	if e.stop != stop {
+		e.mutex.Unlock()
+		return
+	}
+
+	e.stop = nil
+	e.done = nil
+	e.wake = nil
+	e.offset = 0
+	e.frame = -1
+
+	zeroes := e.mapping.Zero()
+	onEnd := e.onEnd
+	e.mutex.Unlock()
+
+	e.sink.Send(zeroes)
+
+	if onEnd != nil {
+		onEnd()
+	}
+}
+
+// stepEpsilon is added to the time until the next value when playing
+// backwards, so that the boundary is crossed.
+const stepEpsilon = time.Microsecond
+
+// step returns the time until the next value. If the value at the current
+// position hasn't been played yet, then the outputs to send are returned with
+// ok being true. ended is true once playback has finished. fadeOut is the
+// function to call if playback has just started fading out.
+func (e *Engine) step() (next time.Duration, outputs map[Output]float64, ok, ended bool, fadeOut func()) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	t := e.timeline(e.clock.Now())
+	loc := e.locate(t)
+	if loc.ended {
+		return 0, nil, false, true, nil
+	}
+
+	level, until, out := e.envelope(t)
+	if out && !e.fading {
+		fadeOut = e.onFade
+	}
+	e.fading = out
+
+	interval := e.pattern.Interval
+
+	frame := int(loc.pos / interval)
+	if frame >= len(e.pattern.Points) {
+		frame = len(e.pattern.Points) - 1
+	}
+
+	into := loc.pos - time.Duration(frame)*interval
+	curr := e.point(frame)
+
+	var values []float64
+	var substep int
+
+	if e.interp == InterpolateNone {
+		if loc.back {
+			next = into + stepEpsilon
+		} else {
+			next = interval - into
+		}
+
+		values = curr
+	} else {
+		after := e.neighbour(frame, 1, loc)
+
+		n := substeps(curr, after, e.steps, e.unscale(interval))
+		substep = int(int64(into) * int64(n) / int64(interval))
+		if substep >= n {
+			substep = n - 1
+		}
+
+		start := time.Duration(substep) * interval / time.Duration(n)
+		if loc.back {
+			next = into - start + stepEpsilon
+		} else {
+			next = start + interval/time.Duration(n) - into
+		}
+
+		if frame != e.frame || substep != e.substep || level != e.level {
+			t := float64(substep) / float64(n)
+			before := e.neighbour(frame, -1, loc)
+			values = e.interp.interpolate(before, curr, after, e.neighbour(frame, 2, loc), t)
+		}
+	}
+
+	// Don't wait past the end of the pass, where the direction may change or
+	// playback may end.
+	if next > loc.remain {
+		next = loc.remain
+	}
+
+	next = e.unscale(next)
+	if until >= 0 && next > until {
+		next = until
+	}
+	if next <= 0 {
+		next = time.Millisecond
+	}
+
+	if frame == e.frame && substep == e.substep && level == e.level {
+		return next, nil, false, false, fadeOut
+	}
+
+	e.frame = frame
+	e.substep = substep
+	e.level = level
+	e.outputs = e.mapping.Apply(values, func(device int) float64 {
+		return e.intensity(device) * level
+	})
+
+	return next, e.outputs, true, false, fadeOut
+}
+
+// neighbour returns the values of the point d points away from frame, which
+// interpolation moves from or towards. Past the ends of the region, the points
+// of the next or previous pass are used if it plays in the same direction;
+// otherwise, the point at the end is held. The mutex must be held.
+func (e *Engine) neighbour(frame, d int, loc location) []float64 {
+	a, b := e.region()
+
+	first := int(a / e.pattern.Interval)
+	last := int((b - 1) / e.pattern.Interval)
+	if last >= len(e.pattern.Points) {
+		last = len(e.pattern.Points) - 1
+	}
+
+	i := frame + d
+	if i >= first && i <= last {
+		return e.point(i)
+	}
+
+	var wraps bool
+	switch e.loop {
+	case LoopForever:
+		wraps = true
+	case LoopRepeat:
+		if i > last {
+			wraps = loc.pass+1 < e.count
+		} else {
+			wraps = loc.pass > 0
+		}
+	}
+
+	switch {
+	case wraps:
+		n := last - first + 1
+		i = first + ((i-first)%n+n)%n
+	case i > last:
+		i = last
+	default:
+		i = first
+	}
+
+	return e.point(i)
+}
+
+// point returns the values of the point at i, which wraps around the pattern.
+// The intensity is applied later by the mapping. The mutex must be held.
+func (e *Engine) point(i int) []float64 {
+	n := len(e.pattern.Points)
+	i %= n
+	if i < 0 {
+		i += n
+	}
+
+	values := e.pattern.Points[i].Scale(e.pattern.Version)
+	for i, v := range values {
+		values[i] = clamp(v)
+	}
+
+	return values
+}
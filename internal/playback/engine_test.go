@@ -0,0 +1,691 @@
+package playback
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+)
+
+// fakeClock is a Clock that only moves when it's advanced. Every time one of
+// its timers is armed, the delay is sent over armed, so that tests know when
+// the engine is waiting again.
+type fakeClock struct {
+	mutex  sync.Mutex
+	start  time.Time
+	now    time.Time
+	timers []*fakeTimer
+	armed  chan time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	start := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	return &fakeClock{
+		start: start,
+		now:   start,
+		armed: make(chan time.Duration, 64),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// elapsed returns the time since the clock was created.
+func (c *fakeClock) elapsed() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now.Sub(c.start)
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+
+	c.mutex.Lock()
+	c.timers = append(c.timers, t)
+	t.arm(d)
+	c.mutex.Unlock()
+
+	return t
+}
+
+// advance moves the clock forward by d and fires the timers that are due.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		t.fireIfDue()
+	}
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	c      chan time.Time
+	at     time.Time
+	active bool
+}
+
+// arm sets the timer to fire after d. The clock's mutex must be held.
+func (t *fakeTimer) arm(d time.Duration) {
+	t.at = t.clock.now.Add(d)
+	t.active = true
+	t.fireIfDue()
+}
+
+// fireIfDue fires the timer if it's active and due. The clock's mutex must be
+// held.
+func (t *fakeTimer) fireIfDue() {
+	if t.active && !t.clock.now.Before(t.at) {
+		t.active = false
+		t.c <- t.clock.now
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) {
+	t.clock.mutex.Lock()
+	t.arm(d)
+	t.clock.mutex.Unlock()
+
+	t.clock.armed <- d
+}
+
+// sent is what a recordingSink was sent.
+type sent struct {
+	at     time.Duration
+	values map[Output]float64
+}
+
+// recordingSink records everything sent to it. If it has a clock, then the
+// time of every send is recorded too.
+type recordingSink struct {
+	clock *fakeClock
+
+	mutex sync.Mutex
+	sent  []sent
+}
+
+func (s *recordingSink) Send(values map[Output]float64) {
+	cpy := make(map[Output]float64, len(values))
+	for output, v := range values {
+		cpy[output] = v
+	}
+
+	var at time.Duration
+	if s.clock != nil {
+		at = s.clock.elapsed()
+	}
+
+	s.mutex.Lock()
+	s.sent = append(s.sent, sent{at, cpy})
+	s.mutex.Unlock()
+}
+
+// take returns everything sent since the last call.
+func (s *recordingSink) take() []sent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	taken := s.sent
+	s.sent = nil
+	return taken
+}
+
+var (
+	vibrator0 = Output{Device: 0, Actuator: Vibrator, Motor: 0}
+	rotator0  = Output{Device: 0, Actuator: Rotator, Motor: 0}
+	vibrator1 = Output{Device: 1, Actuator: Vibrator, Motor: 0}
+)
+
+// testPattern creates a version 1 pattern with one vibrate channel.
+func testPattern(interval time.Duration, strengths ...pattern.Strength) *pattern.Pattern {
+	points := make(pattern.Points, len(strengths))
+	for i, s := range strengths {
+		points[i] = pattern.Point{s}
+	}
+
+	p := &pattern.Pattern{Points: points}
+	p.Version = pattern.V1
+	p.Interval = interval
+	p.Features = []pattern.Feature{pattern.Vibrate}
+	return p
+}
+
+// engineTest drives an engine through a fakeClock.
+type engineTest struct {
+	t      *testing.T
+	clock  *fakeClock
+	sink   *recordingSink
+	engine *Engine
+	ended  chan struct{}
+	// next is the delay of the engine's timer, or -1 if it isn't playing.
+	next time.Duration
+}
+
+func newEngineTest(t *testing.T, p *pattern.Pattern, m Mapping) *engineTest {
+	clock := newFakeClock()
+	sink := &recordingSink{clock: clock}
+
+	et := &engineTest{
+		t:      t,
+		clock:  clock,
+		sink:   sink,
+		engine: NewEngineWithClock(p, sink, m, clock),
+		ended:  make(chan struct{}),
+		next:   -1,
+	}
+	et.engine.OnEnd(func() { close(et.ended) })
+
+	t.Cleanup(et.engine.Pause)
+	return et
+}
+
+// newVibratorTest creates an engineTest that plays p on vibrator0.
+func newVibratorTest(t *testing.T, p *pattern.Pattern) *engineTest {
+	return newEngineTest(t, p, DefaultMapping(p, 0, Motors{Vibrator: {0}}))
+}
+
+// wait waits until the engine has handled whatever woke it up.
+func (et *engineTest) wait() {
+	et.t.Helper()
+
+	select {
+	case et.next = <-et.clock.armed:
+	case <-et.ended:
+		et.next = -1
+	case <-time.After(5 * time.Second):
+		et.t.Fatal("timed out waiting for the engine")
+	}
+}
+
+func (et *engineTest) play() {
+	et.t.Helper()
+
+	et.engine.Play()
+	et.wait()
+}
+
+func (et *engineTest) seek(pos time.Duration) {
+	et.t.Helper()
+
+	et.engine.Seek(pos)
+	et.wait()
+}
+
+// run advances the clock from timer to timer until d has elapsed since the
+// clock was created or playback has ended.
+func (et *engineTest) run(d time.Duration) {
+	et.t.Helper()
+
+	for et.next >= 0 && et.clock.elapsed()+et.next <= d {
+		et.clock.advance(et.next)
+		et.wait()
+	}
+}
+
+// values returns the values of output in every send since the last call.
+func (et *engineTest) values(output Output) ([]float64, []time.Duration) {
+	var values []float64
+	var times []time.Duration
+
+	for _, s := range et.sink.take() {
+		if v, ok := s.values[output]; ok {
+			values = append(values, v)
+			times = append(times, s.at)
+		}
+	}
+
+	return values, times
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func assertValues(t *testing.T, got, want []float64) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got values %v, want %v", got, want)
+	}
+	for i := range got {
+		if !near(got[i], want[i]) {
+			t.Fatalf("got values %v, want %v", got, want)
+		}
+	}
+}
+
+func assertTimes(t *testing.T, got []time.Duration, want ...time.Duration) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("sent at %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sent at %v, want %v", got, want)
+		}
+	}
+}
+
+const ms = time.Millisecond
+
+func TestEngineStepTiming(t *testing.T) {
+	et := newVibratorTest(t, testPattern(100*ms, 0, 10, 20))
+
+	et.play()
+	if et.next != 100*ms {
+		t.Fatalf("first frame lasts %v, want 100ms", et.next)
+	}
+
+	et.run(350 * ms)
+
+	values, times := et.values(vibrator0)
+	assertValues(t, values, []float64{0, 0.5, 1, 0})
+	assertTimes(t, times, 0, 100*ms, 200*ms, 300*ms)
+
+	// The position follows the clock, not the frames.
+	et.clock.advance(50 * ms)
+	if pos := et.engine.Position(); pos != 50*ms {
+		t.Fatalf("position is %v, want 50ms", pos)
+	}
+	if pass := et.engine.Pass(); pass != 1 {
+		t.Fatalf("pass is %d, want 1", pass)
+	}
+}
+
+func TestEngineSpeed(t *testing.T) {
+	et := newVibratorTest(t, testPattern(100*ms, 0, 10, 20))
+	et.engine.SetSpeed(2)
+
+	et.play()
+	et.run(150 * ms)
+
+	values, times := et.values(vibrator0)
+	assertValues(t, values, []float64{0, 0.5, 1, 0})
+	assertTimes(t, times, 0, 50*ms, 100*ms, 150*ms)
+}
+
+func TestEngineSeek(t *testing.T) {
+	et := newVibratorTest(t, testPattern(100*ms, 0, 10, 20))
+
+	et.engine.Seek(200 * ms)
+	if pos := et.engine.Position(); pos != 200*ms {
+		t.Fatalf("paused position is %v, want 200ms", pos)
+	}
+	et.engine.Seek(0)
+
+	et.play()
+	et.run(100 * ms)
+	et.sink.take()
+
+	// The frame at the new position is played right away, and the next one
+	// follows at its boundary.
+	et.seek(250 * ms)
+	if pos := et.engine.Position(); pos != 250*ms {
+		t.Fatalf("position is %v, want 250ms", pos)
+	}
+	if et.next != 50*ms {
+		t.Fatalf("next frame in %v, want 50ms", et.next)
+	}
+
+	et.run(150 * ms)
+
+	values, times := et.values(vibrator0)
+	assertValues(t, values, []float64{1, 0})
+	assertTimes(t, times, 100*ms, 150*ms)
+}
+
+func TestEngineSeekRegion(t *testing.T) {
+	et := newVibratorTest(t, testPattern(100*ms, 0, 10, 20, 10))
+	et.engine.SetRegion(100*ms, 300*ms)
+
+	et.engine.Seek(0)
+	if pos := et.engine.Position(); pos != 100*ms {
+		t.Fatalf("position is %v, want the region's start", pos)
+	}
+
+	et.play()
+	et.run(400 * ms)
+
+	values, _ := et.values(vibrator0)
+	assertValues(t, values, []float64{0.5, 1, 0.5, 1, 0.5})
+}
+
+func TestEngineLoopModes(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   LoopMode
+		count  int
+		values []float64
+		ended  time.Duration // -1 if playback doesn't end
+	}{
+		{
+			name:   "forever",
+			mode:   LoopForever,
+			values: []float64{0, 0.5, 1, 0, 0.5, 1, 0},
+			ended:  -1,
+		},
+		{
+			name:   "once",
+			mode:   LoopOnce,
+			values: []float64{0, 0.5, 1, 0},
+			ended:  300 * ms,
+		},
+		{
+			name:   "repeat",
+			mode:   LoopRepeat,
+			count:  2,
+			values: []float64{0, 0.5, 1, 0, 0.5, 1, 0},
+			ended:  600 * ms,
+		},
+		{
+			name:   "ping-pong",
+			mode:   LoopPingPong,
+			values: []float64{0, 0.5, 1, 0.5, 0, 0.5, 1},
+			ended:  -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			et := newVibratorTest(t, testPattern(100*ms, 0, 10, 20))
+			et.engine.SetLoop(test.mode, test.count)
+
+			et.play()
+			et.run(900 * ms)
+
+			values, times := et.values(vibrator0)
+
+			if test.ended < 0 {
+				if !et.engine.IsPlaying() {
+					t.Fatal("playback ended")
+				}
+				if len(values) > len(test.values) {
+					values = values[:len(test.values)]
+				}
+				assertValues(t, values, test.values)
+				return
+			}
+
+			assertValues(t, values, test.values)
+
+			select {
+			case <-et.ended:
+			default:
+				t.Fatal("playback didn't end")
+			}
+			if et.engine.IsPlaying() {
+				t.Fatal("still playing after the end")
+			}
+			if end := times[len(times)-1]; end != test.ended {
+				t.Fatalf("ended at %v, want %v", end, test.ended)
+			}
+		})
+	}
+}
+
+func TestEngineInterpolation(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   LoopMode
+		count  int
+		values []float64
+		// turn is the time of the first value after 1, which shows whether
+		// the last point moves on or is held.
+		turn time.Duration
+	}{
+		{
+			// The last point moves on towards the first one.
+			name:   "forever",
+			mode:   LoopForever,
+			values: []float64{0, 0.2, 0.4, 0.6, 0.8, 1, 0.8, 0.6, 0.4, 0.2, 0},
+			turn:   120 * ms,
+		},
+		{
+			// The last point is held until the end.
+			name:   "once",
+			mode:   LoopOnce,
+			values: []float64{0, 0.2, 0.4, 0.6, 0.8, 1, 0},
+			turn:   200 * ms,
+		},
+		{
+			// Only the last pass holds the last point.
+			name:  "repeat",
+			mode:  LoopRepeat,
+			count: 2,
+			values: []float64{
+				0, 0.2, 0.4, 0.6, 0.8, 1, 0.8, 0.6, 0.4, 0.2,
+				0, 0.2, 0.4, 0.6, 0.8, 1, 0,
+			},
+			turn: 120 * ms,
+		},
+		{
+			// The last point is held until playback turns around.
+			name:   "ping-pong",
+			mode:   LoopPingPong,
+			values: []float64{0, 0.2, 0.4, 0.6, 0.8, 1, 0.8, 0.6, 0.4, 0.2, 0},
+			turn:   300 * ms,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			et := newVibratorTest(t, testPattern(100*ms, 0, 20))
+			et.engine.SetInterpolation(InterpolateLinear)
+			et.engine.SetLoop(test.mode, test.count)
+
+			et.play()
+			et.run(500 * ms)
+
+			values, times := et.values(vibrator0)
+			if len(values) > len(test.values) {
+				values = values[:len(test.values)]
+			}
+			assertValues(t, values, test.values)
+
+			for i := range values {
+				if values[i] == 1 {
+					if turn := times[i+1]; turn < test.turn || turn > test.turn+ms {
+						t.Fatalf("moved on from the last point at %v, want %v", turn, test.turn)
+					}
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestEngineMapping(t *testing.T) {
+	p := &pattern.Pattern{Points: pattern.Points{{20, 10}}}
+	p.Version = pattern.V1
+	p.Interval = 100 * ms
+	p.Features = []pattern.Feature{pattern.Vibrate, pattern.Rotate}
+
+	m := Mapping{
+		{
+			{Output: vibrator0},
+			{Output: vibrator1, Invert: true},
+		},
+		{
+			{Output: rotator0},
+		},
+	}
+
+	et := newEngineTest(t, p, m)
+	et.engine.SetDeviceOffset(1, -0.5)
+
+	assertFrame := func(want map[Output]float64) {
+		t.Helper()
+
+		sent := et.sink.take()
+		if len(sent) == 0 {
+			t.Fatal("nothing was sent")
+		}
+
+		got := sent[len(sent)-1].values
+		if len(got) != len(want) {
+			t.Fatalf("sent %v, want %v", got, want)
+		}
+		for output, v := range want {
+			if !near(got[output], v) {
+				t.Fatalf("sent %v, want %v", got, want)
+			}
+		}
+	}
+
+	et.play()
+	assertFrame(map[Output]float64{
+		vibrator0: 1,
+		vibrator1: 0.5, // 1 - 1*0.5
+		rotator0:  0.5,
+	})
+
+	et.engine.SetIntensity(0.5)
+	et.wait()
+	assertFrame(map[Output]float64{
+		vibrator0: 0.5,
+		vibrator1: 1, // 1 - 1*0
+		rotator0:  0.25,
+	})
+
+	if frame, outputs := et.engine.Frame(); frame != 0 || !near(outputs[vibrator1], 1) {
+		t.Fatalf("frame %d has outputs %v", frame, outputs)
+	}
+
+	// Outputs that aren't driven anymore are set to 0.
+	et.engine.SetMapping(m[:1])
+	et.wait()
+
+	var dropped bool
+	for _, s := range et.sink.take() {
+		if len(s.values) == 1 && s.values[rotator0] == 0 {
+			dropped = true
+			continue
+		}
+		if _, ok := s.values[rotator0]; ok {
+			t.Fatal("dropped output is still played")
+		}
+	}
+	if !dropped {
+		t.Fatal("dropped output wasn't set to 0")
+	}
+
+	// Pausing sets all outputs to 0, even inverted ones.
+	et.engine.Pause()
+	assertFrame(map[Output]float64{
+		vibrator0: 0,
+		vibrator1: 0,
+	})
+}
+
+func TestMappingOnDevice(t *testing.T) {
+	p := testPattern(100*ms, 0)
+	m := DefaultMapping(p, 3, Motors{Vibrator: {0, 1}})
+
+	if devices := m.Devices(); len(devices) != 1 || devices[0] != 3 {
+		t.Fatalf("mapping drives %v, want [3]", devices)
+	}
+
+	moved := m.OnDevice(5).Merge(m)
+	if devices := moved.Devices(); len(devices) != 2 || devices[0] != 5 || devices[1] != 3 {
+		t.Fatalf("merged mapping drives %v, want [5 3]", devices)
+	}
+
+	if only := moved.Only(3); len(only[0]) != 2 || only[0][0].Device != 3 {
+		t.Fatalf("Only(3) kept %v", only)
+	}
+	if without := moved.Without(3); len(without[0]) != 2 || without[0][0].Device != 5 {
+		t.Fatalf("Without(3) kept %v", without)
+	}
+}
+
+func TestMixer(t *testing.T) {
+	sink := &recordingSink{}
+	mixer := NewMixer(sink)
+
+	a := mixer.Source()
+	b := mixer.Source()
+
+	assertSent := func(want map[Output]float64) {
+		t.Helper()
+
+		sent := sink.take()
+		if len(sent) != 1 {
+			t.Fatalf("sent %d times, want once", len(sent))
+		}
+
+		got := sent[0].values
+		if len(got) != len(want) {
+			t.Fatalf("sent %v, want %v", got, want)
+		}
+		for output, v := range want {
+			if !near(got[output], v) {
+				t.Fatalf("sent %v, want %v", got, want)
+			}
+		}
+	}
+
+	a.Send(map[Output]float64{vibrator0: 0.5, rotator0: 0.25})
+	assertSent(map[Output]float64{vibrator0: 0.5, rotator0: 0.25})
+
+	// Only the outputs that were sent are summed, and sums are clamped.
+	b.Send(map[Output]float64{vibrator0: 0.75})
+	assertSent(map[Output]float64{vibrator0: 1})
+
+	// Closing a source sends the sums of the others.
+	a.Close()
+	assertSent(map[Output]float64{vibrator0: 0.75, rotator0: 0})
+
+	a.Send(map[Output]float64{vibrator0: 1})
+	if sent := sink.take(); len(sent) != 0 {
+		t.Fatalf("closed source sent %v", sent)
+	}
+}
+
+func TestMixerEngines(t *testing.T) {
+	clock := newFakeClock()
+	sink := &recordingSink{clock: clock}
+	mixer := NewMixer(sink)
+
+	p := testPattern(100*ms, 10)
+	m := DefaultMapping(p, 0, Motors{Vibrator: {0}})
+
+	for i := 0; i < 2; i++ {
+		e := NewEngineWithClock(p, mixer.Source(), m, clock)
+		e.Play()
+		t.Cleanup(e.Pause)
+
+		select {
+		case <-clock.armed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the engine")
+		}
+	}
+
+	sent := sink.take()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d times, want 2", len(sent))
+	}
+	if v := sent[1].values[vibrator0]; !near(v, 1) {
+		t.Fatalf("mixed value is %v, want 1", v)
+	}
+}
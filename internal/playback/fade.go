@@ -0,0 +1,97 @@
+package playback
+
+import (
+	"math"
+	"time"
+)
+
+// fadeSteps is the number of levels that a fade goes through.
+const fadeSteps = 20
+
+// fadeTick is the longest time between two values sent during a fade, so
+// that the fade stays smooth even when the pattern's points are far apart.
+const fadeTick = 50 * time.Millisecond
+
+// Fade returns the durations of the fade-in and fade-out.
+func (e *Engine) Fade() (in, out time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.fadeIn, e.fadeOut
+}
+
+// SetFade makes playback fade in from 0 over the given duration once started
+// from the beginning, and fade out to 0 over the given duration before it
+// ends. Durations are in real time. Fading out only happens in the loop modes
+// that end by themselves. A duration of 0 disables its fade.
+func (e *Engine) SetFade(in, out time.Duration) {
+	if in < 0 {
+		in = 0
+	}
+	if out < 0 {
+		out = 0
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.fadeIn = in
+	e.fadeOut = out
+	e.frame = -1
+	e.wakeUp()
+}
+
+// OnFadeOut sets the function that's called once playback starts fading out.
+// It's called from the engine's goroutine. Nothing is called if no fade-out
+// is set.
+func (e *Engine) OnFadeOut(f func()) {
+	e.mutex.Lock()
+	e.onFade = f
+	e.mutex.Unlock()
+}
+
+// envelope returns the level that values are multiplied by at the timeline
+// t, and the real time until it may change. out is true if t is within the
+// fade-out. The mutex must be held.
+func (e *Engine) envelope(t time.Duration) (level float64, until time.Duration, out bool) {
+	level = 1
+	until = -1
+
+	if e.fadeIn > 0 {
+		if played := e.unscale(t); played < e.fadeIn {
+			level = float64(played) / float64(e.fadeIn)
+			until = fadeTick
+		}
+	}
+
+	if end := e.endTimeline(); end >= 0 && e.fadeOut > 0 {
+		remain := e.unscale(end - t)
+		if remain <= e.fadeOut {
+			out = true
+			until = fadeTick
+			if l := float64(remain) / float64(e.fadeOut); l < level {
+				level = l
+			}
+		} else if until < 0 || remain-e.fadeOut < until {
+			until = remain - e.fadeOut
+		}
+	}
+
+	level = math.Round(clamp(level)*fadeSteps) / fadeSteps
+	return level, until, out
+}
+
+// endTimeline returns the timeline at which playback ends by itself, or -1
+// if it never does. The mutex must be held.
+func (e *Engine) endTimeline() time.Duration {
+	a, b := e.region()
+
+	switch e.loop {
+	case LoopOnce:
+		return b - a
+	case LoopRepeat:
+		return time.Duration(e.count) * (b - a)
+	default:
+		return -1
+	}
+}
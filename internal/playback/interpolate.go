@@ -0,0 +1,147 @@
+package playback
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Interpolation is the way that values between two pattern points are
+// computed.
+type Interpolation int
+
+const (
+	// InterpolateNone holds each point's value until the next point.
+	InterpolateNone Interpolation = iota
+	// InterpolateLinear moves from one point to the next in a straight line.
+	InterpolateLinear
+	// InterpolateCubic moves through the points along a Catmull-Rom spline.
+	InterpolateCubic
+)
+
+// Interpolations lists all interpolation modes in order.
+var Interpolations = []Interpolation{
+	InterpolateNone,
+	InterpolateLinear,
+	InterpolateCubic,
+}
+
+// String returns the name of the interpolation as stored in the settings.
+func (i Interpolation) String() string {
+	switch i {
+	case InterpolateNone:
+		return "none"
+	case InterpolateLinear:
+		return "linear"
+	case InterpolateCubic:
+		return "cubic"
+	default:
+		return fmt.Sprintf("Interpolation(%d)", int(i))
+	}
+}
+
+// Label returns the human-readable name of the interpolation.
+func (i Interpolation) Label() string {
+	switch i {
+	case InterpolateNone:
+		return "Steps"
+	case InterpolateLinear:
+		return "Linear"
+	case InterpolateCubic:
+		return "Smooth"
+	default:
+		return i.String()
+	}
+}
+
+// ParseInterpolation parses the name returned by String. Unknown names are
+// parsed as InterpolateNone.
+func ParseInterpolation(name string) Interpolation {
+	for _, i := range Interpolations {
+		if i.String() == name {
+			return i
+		}
+	}
+	return InterpolateNone
+}
+
+// MinStepInterval is the shortest time between two interpolated values. It
+// caps the command rate of interpolated playback.
+const MinStepInterval = 20 * time.Millisecond
+
+// DefaultSteps is the number of steps assumed for devices that don't report
+// it.
+const DefaultSteps = 20
+
+// substeps returns the number of values to send between the two points. A
+// device with the given number of steps cannot tell apart values closer than
+// 1/steps, so sending more of them is pointless.
+func substeps(from, to []float64, steps int, interval time.Duration) int {
+	var delta float64
+	for i := range from {
+		if i < len(to) {
+			delta = math.Max(delta, math.Abs(to[i]-from[i]))
+		}
+	}
+
+	n := int(math.Ceil(delta * float64(steps)))
+	if max := int(interval / MinStepInterval); n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// interpolate computes the values at t within [0, 1] between the points p1
+// and p2. p0 and p3 are the points before and after them, which are only used
+// by InterpolateCubic.
+func (i Interpolation) interpolate(p0, p1, p2, p3 []float64, t float64) []float64 {
+	values := make([]float64, len(p1))
+
+	for ch := range values {
+		v1 := p1[ch]
+		v2 := at(p2, ch, v1)
+
+		switch i {
+		case InterpolateLinear:
+			values[ch] = v1 + (v2-v1)*t
+		case InterpolateCubic:
+			v0 := at(p0, ch, v1)
+			v3 := at(p3, ch, v2)
+			values[ch] = clamp(catmullRom(v0, v1, v2, v3, t))
+		default:
+			values[ch] = v1
+		}
+	}
+
+	return values
+}
+
+func at(values []float64, i int, def float64) float64 {
+	if i < len(values) {
+		return values[i]
+	}
+	return def
+}
+
+func catmullRom(v0, v1, v2, v3, t float64) float64 {
+	t2 := t * t
+	t3 := t2 * t
+
+	return 0.5 * (2*v1 +
+		(-v0+v2)*t +
+		(2*v0-5*v1+4*v2-v3)*t2 +
+		(-v0+3*v1-3*v2+v3)*t3)
+}
+
+func clamp(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
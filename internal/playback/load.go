@@ -0,0 +1,46 @@
+package playback
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/diamondburned/go-lovense/pattern"
+)
+
+// Load parses a pattern and makes sure that it can be played.
+func Load(r io.Reader) (*pattern.Pattern, error) {
+	p, err := pattern.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("pattern error: %w", err)
+	}
+
+	if err := Validate(p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Open loads the pattern file at the given path.
+func Open(path string) (*pattern.Pattern, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Load(f)
+}
+
+// Validate returns an error if the pattern cannot be played.
+func Validate(p *pattern.Pattern) error {
+	if p.Interval <= 0 {
+		return fmt.Errorf("pattern error: invalid interval %v", p.Interval)
+	}
+	if len(p.Points) == 0 {
+		return errors.New("pattern error: pattern has no points")
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package playback
+
+import (
+	"fmt"
+	"time"
+)
+
+// LoopMode is what an Engine does once it reaches the end of the pattern or
+// of its loop region.
+type LoopMode int
+
+const (
+	// LoopForever plays the region again from its start, forever.
+	LoopForever LoopMode = iota
+	// LoopOnce plays the region once, then stops.
+	LoopOnce
+	// LoopRepeat plays the region a set number of times, then stops.
+	LoopRepeat
+	// LoopPingPong plays the region forwards, then backwards, forever.
+	LoopPingPong
+)
+
+// LoopModes lists all loop modes in order.
+var LoopModes = []LoopMode{
+	LoopForever,
+	LoopOnce,
+	LoopRepeat,
+	LoopPingPong,
+}
+
+// String returns the name of the loop mode.
+func (m LoopMode) String() string {
+	switch m {
+	case LoopForever:
+		return "forever"
+	case LoopOnce:
+		return "once"
+	case LoopRepeat:
+		return "repeat"
+	case LoopPingPong:
+		return "ping-pong"
+	default:
+		return fmt.Sprintf("LoopMode(%d)", int(m))
+	}
+}
+
+// Label returns the human-readable name of the loop mode.
+func (m LoopMode) Label() string {
+	switch m {
+	case LoopForever:
+		return "Repeat forever"
+	case LoopOnce:
+		return "Play once"
+	case LoopRepeat:
+		return "Repeat"
+	case LoopPingPong:
+		return "Ping-pong"
+	default:
+		return m.String()
+	}
+}
+
+// location is a point on an engine's timeline, which is the pattern time
+// played since the start of the region, ignoring loops.
+type location struct {
+	// pos is the position into the pattern.
+	pos time.Duration
+	// pass is the number of times that the region has been fully played.
+	pass int
+	// remain is the pattern time left until the current pass ends.
+	remain time.Duration
+	// back is true if the region is played backwards.
+	back bool
+	// ended is true if playback has finished.
+	ended bool
+}
+
+// locate finds where on the pattern the timeline t is. The mutex must be held.
+func (e *Engine) locate(t time.Duration) location {
+	a, b := e.region()
+
+	length := b - a
+	if length <= 0 {
+		return location{pos: a, ended: true}
+	}
+
+	if t < 0 {
+		t = 0
+	}
+
+	pass := int(t / length)
+	within := t % length
+
+	loc := location{
+		pass:   pass,
+		remain: length - within,
+	}
+
+	switch e.loop {
+	case LoopOnce:
+		loc.ended = pass >= 1
+	case LoopRepeat:
+		loc.ended = pass >= e.count
+	case LoopPingPong:
+		loc.back = pass%2 == 1
+	}
+
+	switch {
+	case loc.ended:
+		loc.pos = b
+	case loc.back:
+		loc.pos = b - within
+		if loc.pos >= b {
+			loc.pos = b - 1
+		}
+	default:
+		loc.pos = a + within
+	}
+
+	return loc
+}
+
+// timelineAt returns the timeline that puts the position at pos in the given
+// pass. pos is clamped into the region. The mutex must be held.
+func (e *Engine) timelineAt(pos time.Duration, pass int) time.Duration {
+	a, b := e.region()
+	if pos < a {
+		pos = a
+	}
+	if pos >= b {
+		pos = b - 1
+	}
+
+	within := pos - a
+	if e.loop == LoopPingPong && pass%2 == 1 {
+		within = b - pos
+	}
+
+	return time.Duration(pass)*(b-a) + within
+}
+
+// region returns the region that's played. The mutex must be held.
+func (e *Engine) region() (a, b time.Duration) {
+	if e.regionB > e.regionA {
+		return e.regionA, e.regionB
+	}
+	return 0, e.Duration()
+}
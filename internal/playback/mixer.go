@@ -0,0 +1,95 @@
+package playback
+
+import "sync"
+
+// Mixer adds up the values of several sources, such as two engines that are
+// crossfaded into each other, and sends the sums to a sink. Sums are clamped
+// to be within [0, 1].
+//
+// A Mixer is safe to use concurrently.
+type Mixer struct {
+	sink Sink
+
+	mutex   sync.Mutex
+	sources map[*MixerSource]map[Output]float64
+}
+
+// NewMixer creates a new Mixer that sends to the given sink.
+func NewMixer(sink Sink) *Mixer {
+	return &Mixer{
+		sink:    sink,
+		sources: make(map[*MixerSource]map[Output]float64, 2),
+	}
+}
+
+// Source adds a new source to the mixer.
+func (m *Mixer) Source() *MixerSource {
+	s := &MixerSource{mixer: m}
+
+	m.mutex.Lock()
+	m.sources[s] = make(map[Output]float64)
+	m.mutex.Unlock()
+
+	return s
+}
+
+// MixerSource is a Sink that sends into a Mixer.
+type MixerSource struct {
+	mixer *Mixer
+}
+
+// Send sets the source's values of the given outputs, then sends their sums.
+func (s *MixerSource) Send(values map[Output]float64) {
+	m := s.mixer
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, ok := m.sources[s]
+	if !ok {
+		return
+	}
+
+	for output, v := range values {
+		stored[output] = v
+	}
+
+	m.sendSums(values)
+}
+
+// Close removes the source from the mixer. The outputs that it drove are set
+// to the sums of the other sources.
+func (s *MixerSource) Close() {
+	m := s.mixer
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, ok := m.sources[s]
+	if !ok {
+		return
+	}
+
+	delete(m.sources, s)
+	m.sendSums(stored)
+}
+
+// sendSums sends the sums of the given outputs. The sink is called with the
+// mutex held, so that sums computed one after another are also sent in order.
+// The mutex must be held.
+func (m *Mixer) sendSums(outputs map[Output]float64) {
+	if len(outputs) == 0 {
+		return
+	}
+
+	sums := make(map[Output]float64, len(outputs))
+	for output := range outputs {
+		var sum float64
+		for _, values := range m.sources {
+			sum += values[output]
+		}
+		sums[output] = clamp(sum)
+	}
+
+	m.sink.Send(sums)
+}
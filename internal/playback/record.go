@@ -0,0 +1,79 @@
+package playback
+
+import (
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+)
+
+// Recorder samples values into a version 1 pattern with a fixed interval.
+// A Recorder isn't safe to use concurrently.
+type Recorder struct {
+	features []pattern.Feature
+	interval time.Duration
+	points   pattern.Points
+}
+
+// NewRecorder creates a new Recorder of a pattern with the given features,
+// which has one channel for each of them. See Features.
+func NewRecorder(features []pattern.Feature, interval time.Duration) *Recorder {
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	return &Recorder{
+		features: append([]pattern.Feature(nil), features...),
+		interval: interval.Round(time.Millisecond),
+	}
+}
+
+// Sample records the values within [0, 1] of every channel at the given time
+// since the recording has started. The points between the last sample and
+// this one repeat the last sample, so the recording keeps its pace even if
+// samples are late.
+func (r *Recorder) Sample(at time.Duration, values []float64) {
+	point := make(pattern.Point, len(r.features))
+	for ch := range point {
+		if ch < len(values) {
+			point[ch] = Strength(values[ch])
+		}
+	}
+
+	n := int(at/r.interval) + 1
+	for len(r.points) < n-1 {
+		last := point
+		if len(r.points) > 0 {
+			last = r.points[len(r.points)-1]
+		}
+		r.points = append(r.points, last)
+	}
+
+	if len(r.points) < n {
+		r.points = append(r.points, point)
+	}
+}
+
+// Len returns the number of points recorded.
+func (r *Recorder) Len() int {
+	return len(r.points)
+}
+
+// Duration returns the duration of the recording.
+func (r *Recorder) Duration() time.Duration {
+	return r.interval * time.Duration(len(r.points))
+}
+
+// Pattern returns the recording as a pattern.
+func (r *Recorder) Pattern() *pattern.Pattern {
+	p := &pattern.Pattern{
+		Header: pattern.Header{
+			Version:  pattern.V1,
+			Interval: r.interval,
+		},
+		Points: make(pattern.Points, len(r.points)),
+	}
+	p.Features = append([]pattern.Feature(nil), r.features...)
+	copy(p.Points, r.points)
+
+	return p
+}
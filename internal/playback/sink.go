@@ -0,0 +1,240 @@
+package playback
+
+import "github.com/diamondburned/go-lovense/pattern"
+
+// Sink receives the values that an Engine plays. Its methods are called from
+// the engine's goroutine; they must not block or modify the given maps.
+type Sink interface {
+	// Send sets the given outputs. Values are within [0, 1].
+	Send(values map[Output]float64)
+}
+
+// Output is an actuator of a device.
+type Output struct {
+	// Device is the index of the device on the server. It isn't saved, since
+	// indices change between connections; see Mapping.OnDevice.
+	Device int `json:"-"`
+	// Actuator is the type of the actuator.
+	Actuator Actuator `json:"actuator,omitempty"`
+	// Motor is the index of the actuator among those of its type.
+	Motor int `json:"motor"`
+}
+
+// Target is an output that a channel drives.
+type Target struct {
+	Output
+	// Invert sends 1-v instead of v.
+	Invert bool `json:"invert,omitempty"`
+}
+
+// Mapping maps the channels of a pattern to outputs. Mapping[i] contains the
+// targets that channel i drives. A channel without targets is ignored.
+type Mapping [][]Target
+
+// DefaultMapping returns the mapping of p onto the motors of a device. Each
+// channel drives the actuators that match its feature: vibrators for the
+// vibrate features, rotators for rotate and linear actuators for the air pump.
+// Channels without a known feature are treated as vibrate.
+//
+// If the device lacks the matching actuators, then the channel falls back to
+// its vibrators, or to the first of its rotators and linear actuators if it
+// has no vibrators either. A device without any actuator ignores every
+// channel.
+//
+// Channels that end up on the same type of actuator share its motors: if there
+// are as many channels as motors, then each channel drives its own motor.
+// Otherwise, the first channel drives all of them and the others are ignored.
+func DefaultMapping(p *pattern.Pattern, device int, motors Motors) Mapping {
+	m := make(Mapping, Channels(p))
+
+	groups := make(map[Actuator][]int, len(Actuators))
+	for channel := range m {
+		var feature pattern.Feature
+		if channel < len(p.Features) {
+			feature = p.Features[channel]
+		}
+
+		if actuator, ok := motors.actuatorFor(feature); ok {
+			groups[actuator] = append(groups[actuator], channel)
+		}
+	}
+
+	for actuator, channels := range groups {
+		target := func(motor int) Target {
+			return Target{Output: Output{Device: device, Actuator: actuator, Motor: motor}}
+		}
+
+		indices := motors[actuator]
+		if len(channels) == len(indices) {
+			for i, channel := range channels {
+				m[channel] = []Target{target(indices[i])}
+			}
+			continue
+		}
+
+		for _, motor := range indices {
+			m[channels[0]] = append(m[channels[0]], target(motor))
+		}
+	}
+
+	return m
+}
+
+// Copy returns a deep copy of m.
+func (m Mapping) Copy() Mapping {
+	cpy := make(Mapping, len(m))
+	for i, targets := range m {
+		cpy[i] = append([]Target(nil), targets...)
+	}
+	return cpy
+}
+
+// Fit returns a copy of m with exactly the given number of channels. Missing
+// channels are ignored.
+func (m Mapping) Fit(channels int) Mapping {
+	cpy := m.Copy()
+	for len(cpy) < channels {
+		cpy = append(cpy, nil)
+	}
+	return cpy[:channels]
+}
+
+// Swap swaps the targets of channels i and j.
+func (m Mapping) Swap(i, j int) {
+	m[i], m[j] = m[j], m[i]
+}
+
+// Outputs returns all the outputs that the mapping drives.
+func (m Mapping) Outputs() []Output {
+	var outputs []Output
+	seen := make(map[Output]bool)
+
+	for _, targets := range m {
+		for _, target := range targets {
+			if !seen[target.Output] {
+				seen[target.Output] = true
+				outputs = append(outputs, target.Output)
+			}
+		}
+	}
+
+	return outputs
+}
+
+// Apply maps the values of a frame onto the outputs. Each value is multiplied
+// by the intensity of its output's device and clamped to be within [0, 1]
+// before it's inverted. Outputs that aren't driven by any value in the frame
+// are set to 0; inverted ones are set to 1. If an output is driven by more
+// than one channel, then it's set to the highest value.
+func (m Mapping) Apply(values []float64, intensity func(device int) float64) map[Output]float64 {
+	outputs := make(map[Output]float64, len(m))
+
+	for channel, targets := range m {
+		var v float64
+		if channel < len(values) {
+			v = values[channel]
+		}
+
+		for _, target := range targets {
+			tv := clamp(v * intensity(target.Device))
+			if target.Invert {
+				tv = 1 - tv
+			}
+
+			if old, ok := outputs[target.Output]; !ok || tv > old {
+				outputs[target.Output] = tv
+			}
+		}
+	}
+
+	return outputs
+}
+
+// Devices returns the indices of all devices that the mapping drives.
+func (m Mapping) Devices() []int {
+	var devices []int
+	seen := make(map[int]bool)
+
+	for _, output := range m.Outputs() {
+		if !seen[output.Device] {
+			seen[output.Device] = true
+			devices = append(devices, output.Device)
+		}
+	}
+
+	return devices
+}
+
+// Only returns a copy of m with only the targets on the given device.
+func (m Mapping) Only(device int) Mapping {
+	return m.filter(func(t Target) bool { return t.Device == device })
+}
+
+// Without returns a copy of m without the targets on the given device.
+func (m Mapping) Without(device int) Mapping {
+	return m.filter(func(t Target) bool { return t.Device != device })
+}
+
+// OnDevice returns a copy of m with every target on the given device. It puts
+// a saved mapping, which doesn't know its device, back onto one.
+func (m Mapping) OnDevice(device int) Mapping {
+	cpy := m.Copy()
+	for _, targets := range cpy {
+		for i := range targets {
+			targets[i].Device = device
+		}
+	}
+	return cpy
+}
+
+func (m Mapping) filter(keep func(Target) bool) Mapping {
+	cpy := make(Mapping, len(m))
+	for i, targets := range m {
+		for _, target := range targets {
+			if keep(target) {
+				cpy[i] = append(cpy[i], target)
+			}
+		}
+	}
+	return cpy
+}
+
+// Merge returns a copy of m with the targets of other added to the same
+// channels. Targets that m already has aren't added again.
+func (m Mapping) Merge(other Mapping) Mapping {
+	channels := len(m)
+	if len(other) > channels {
+		channels = len(other)
+	}
+
+	cpy := m.Fit(channels)
+
+	for i, targets := range other {
+		for _, target := range targets {
+			if !containsTarget(cpy[i], target) {
+				cpy[i] = append(cpy[i], target)
+			}
+		}
+	}
+
+	return cpy
+}
+
+func containsTarget(targets []Target, target Target) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Zero returns all the outputs that the mapping drives set to 0. Unlike Apply,
+// inverted outputs are also set to 0, which is what stopping should do.
+func (m Mapping) Zero() map[Output]float64 {
+	outputs := make(map[Output]float64, len(m))
+	for _, output := range m.Outputs() {
+		outputs[output] = 0
+	}
+	return outputs
+}
@@ -0,0 +1,190 @@
+// Package playlist provides ordered lists of pattern files, the queues that
+// play them, and their storage.
+package playlist
+
+import (
+	"fmt"
+	"time"
+)
+
+// Entry is a pattern file in a playlist.
+type Entry struct {
+	// Path is the path to the pattern file.
+	Path string `json:"path"`
+	// Name is the display name of the pattern.
+	Name string `json:"name"`
+}
+
+// Repeat is what a queue does once it has played its last entry.
+type Repeat int
+
+const (
+	// RepeatOff stops after the last entry.
+	RepeatOff Repeat = iota
+	// RepeatAll plays the playlist again from its first entry.
+	RepeatAll
+	// RepeatOne plays the current entry again, forever.
+	RepeatOne
+)
+
+// Repeats lists all repeat modes in order.
+var Repeats = []Repeat{
+	RepeatOff,
+	RepeatAll,
+	RepeatOne,
+}
+
+// String returns the name of the repeat mode.
+func (r Repeat) String() string {
+	switch r {
+	case RepeatOff:
+		return "off"
+	case RepeatAll:
+		return "all"
+	case RepeatOne:
+		return "one"
+	default:
+		return fmt.Sprintf("Repeat(%d)", int(r))
+	}
+}
+
+// Label returns the human-readable name of the repeat mode.
+func (r Repeat) Label() string {
+	switch r {
+	case RepeatOff:
+		return "Don't repeat"
+	case RepeatAll:
+		return "Repeat playlist"
+	case RepeatOne:
+		return "Repeat pattern"
+	default:
+		return r.String()
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (r Repeat) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (r *Repeat) UnmarshalText(text []byte) error {
+	for _, repeat := range Repeats {
+		if repeat.String() == string(text) {
+			*r = repeat
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown repeat mode %q", text)
+}
+
+// Transition is what happens between two entries.
+type Transition int
+
+const (
+	// TransitionNone starts the next entry as soon as the last one ends.
+	TransitionNone Transition = iota
+	// TransitionGap waits in silence before starting the next entry.
+	TransitionGap
+	// TransitionCrossfade fades the last entry out while the next one fades
+	// in.
+	TransitionCrossfade
+)
+
+// Transitions lists all transitions in order.
+var Transitions = []Transition{
+	TransitionNone,
+	TransitionGap,
+	TransitionCrossfade,
+}
+
+// String returns the name of the transition.
+func (t Transition) String() string {
+	switch t {
+	case TransitionNone:
+		return "none"
+	case TransitionGap:
+		return "gap"
+	case TransitionCrossfade:
+		return "crossfade"
+	default:
+		return fmt.Sprintf("Transition(%d)", int(t))
+	}
+}
+
+// Label returns the human-readable name of the transition.
+func (t Transition) Label() string {
+	switch t {
+	case TransitionNone:
+		return "None"
+	case TransitionGap:
+		return "Gap"
+	case TransitionCrossfade:
+		return "Crossfade"
+	default:
+		return t.String()
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t Transition) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *Transition) UnmarshalText(text []byte) error {
+	for _, transition := range Transitions {
+		if transition.String() == string(text) {
+			*t = transition
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown transition %q", text)
+}
+
+// Playlist is a named, ordered list of patterns along with how it's played.
+type Playlist struct {
+	// Name identifies the playlist.
+	Name string `json:"name"`
+	// Entries are the patterns in their order.
+	Entries []Entry `json:"entries"`
+	// Shuffle plays the entries in a random order.
+	Shuffle bool `json:"shuffle,omitempty"`
+	// Repeat is what happens after the last entry.
+	Repeat Repeat `json:"repeat,omitempty"`
+	// Transition is what happens between two entries.
+	Transition Transition `json:"transition,omitempty"`
+	// TransitionMillis is the duration of the gap or crossfade.
+	TransitionMillis int64 `json:"transition_ms,omitempty"`
+}
+
+// TransitionDuration returns TransitionMillis as a duration.
+func (p Playlist) TransitionDuration() time.Duration {
+	return time.Duration(p.TransitionMillis) * time.Millisecond
+}
+
+// Copy returns a deep copy of p.
+func (p Playlist) Copy() Playlist {
+	cpy := p
+	cpy.Entries = append([]Entry(nil), p.Entries...)
+	return cpy
+}
+
+// Move moves the entry at i to j.
+func (p *Playlist) Move(i, j int) {
+	if i == j || i < 0 || j < 0 || i >= len(p.Entries) || j >= len(p.Entries) {
+		return
+	}
+
+	entry := p.Entries[i]
+	p.Entries = append(p.Entries[:i], p.Entries[i+1:]...)
+	p.Entries = append(p.Entries[:j], append([]Entry{entry}, p.Entries[j:]...)...)
+}
+
+// Remove removes the entry at i.
+func (p *Playlist) Remove(i int) {
+	if i < 0 || i >= len(p.Entries) {
+		return
+	}
+	p.Entries = append(p.Entries[:i], p.Entries[i+1:]...)
+}
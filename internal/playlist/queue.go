@@ -0,0 +1,172 @@
+package playlist
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Queue keeps the order that the entries of a playlist are played in and the
+// one that's playing. A Queue isn't safe to use concurrently.
+type Queue struct {
+	playlist Playlist
+	order    []int // indices of entries in play order
+	pos      int   // into order
+	rand     *rand.Rand
+}
+
+// NewQueue creates a queue of the given playlist, starting at the entry at
+// index. If the playlist is shuffled, then the rest is shuffled after it. A
+// negative index starts at a random entry if the playlist is shuffled, or at
+// the first one otherwise.
+func NewQueue(p Playlist, index int) *Queue {
+	q := &Queue{
+		playlist: p.Copy(),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if index >= len(p.Entries) {
+		index = 0
+	}
+
+	q.reorder(index)
+	return q
+}
+
+// Playlist returns a copy of the playlist that's queued.
+func (q *Queue) Playlist() Playlist {
+	return q.playlist.Copy()
+}
+
+// Len returns the number of entries.
+func (q *Queue) Len() int {
+	return len(q.order)
+}
+
+// Position returns the position of the current entry in play order.
+func (q *Queue) Position() int {
+	return q.pos
+}
+
+// Index returns the index of the current entry in the playlist, or -1 if the
+// playlist is empty.
+func (q *Queue) Index() int {
+	if q.pos >= len(q.order) {
+		return -1
+	}
+	return q.order[q.pos]
+}
+
+// Current returns the current entry.
+func (q *Queue) Current() (Entry, bool) {
+	i := q.Index()
+	if i < 0 {
+		return Entry{}, false
+	}
+	return q.playlist.Entries[i], true
+}
+
+// Next moves to the entry that plays once the current one has ended, which
+// depends on the repeat mode. False is returned if there is none.
+func (q *Queue) Next() (Entry, bool) {
+	if q.playlist.Repeat == RepeatOne {
+		return q.Current()
+	}
+	return q.advance()
+}
+
+// Skip moves to the next entry in play order. Unlike Next, it leaves the
+// current entry even if it's repeated. False is returned if there is none.
+func (q *Queue) Skip() (Entry, bool) {
+	return q.advance()
+}
+
+// Previous moves to the previous entry in play order. The first entry wraps
+// around to the last if the queue repeats; otherwise, it stays.
+func (q *Queue) Previous() (Entry, bool) {
+	switch {
+	case q.pos > 0:
+		q.pos--
+	case q.playlist.Repeat != RepeatOff && len(q.order) > 0:
+		q.pos = len(q.order) - 1
+	}
+	return q.Current()
+}
+
+func (q *Queue) advance() (Entry, bool) {
+	if q.pos+1 < len(q.order) {
+		q.pos++
+		return q.Current()
+	}
+
+	if q.playlist.Repeat == RepeatOff || len(q.order) == 0 {
+		return Entry{}, false
+	}
+
+	if q.playlist.Shuffle {
+		// Play the whole playlist in a new order, but not the entry that
+		// has just been played right again if it can be helped.
+		last := q.order[q.pos]
+		q.reorder(-1)
+		if len(q.order) > 1 && q.order[0] == last {
+			q.order[0], q.order[1] = q.order[1], q.order[0]
+		}
+	}
+
+	q.pos = 0
+	return q.Current()
+}
+
+// SetShuffle turns shuffling on or off. The current entry is kept, and the
+// ones after it are reordered.
+func (q *Queue) SetShuffle(shuffle bool) {
+	q.playlist.Shuffle = shuffle
+	q.reorder(q.Index())
+}
+
+// SetRepeat sets the repeat mode.
+func (q *Queue) SetRepeat(repeat Repeat) {
+	q.playlist.Repeat = repeat
+}
+
+// reorder computes the play order, which starts at the entry at index, or at
+// a random one if index is negative and the playlist is shuffled.
+func (q *Queue) reorder(index int) {
+	n := len(q.playlist.Entries)
+	q.pos = 0
+
+	if !q.playlist.Shuffle {
+		q.order = make([]int, n)
+		for i := range q.order {
+			q.order[i] = i
+		}
+		if index > 0 {
+			q.pos = index
+		}
+		return
+	}
+
+	q.order = q.rand.Perm(n)
+	if index < 0 {
+		return
+	}
+
+	for i, entry := range q.order {
+		if entry == index {
+			q.order[0], q.order[i] = q.order[i], q.order[0]
+			break
+		}
+	}
+}
+
+// Session returns the state of the queue that can be restored later.
+func (q *Queue) Session() Session {
+	index := q.Index()
+	if index < 0 {
+		index = 0
+	}
+
+	return Session{
+		Playlist: q.Playlist(),
+		Index:    index,
+	}
+}
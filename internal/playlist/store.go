@@ -0,0 +1,206 @@
+package playlist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/diamondburned/intiface-gtk/internal/atomicfile"
+)
+
+// Session is the queue of a device, which is saved so that it can be
+// restored in a later session.
+type Session struct {
+	// Playlist is the playlist that's queued.
+	Playlist Playlist `json:"playlist"`
+	// Index is the index of the entry that was playing.
+	Index int `json:"index"`
+}
+
+// FileName is the usual name of the file that playlists are stored in.
+const FileName = "playlists.json"
+
+// storeFile is the content of the file that a Store is saved to.
+type storeFile struct {
+	Playlists []Playlist         `json:"playlists"`
+	Sessions  map[string]Session `json:"sessions,omitempty"`
+}
+
+// Store holds the saved playlists and the sessions of the devices, which are
+// written to a file every time they're changed. A Store is safe to use
+// concurrently.
+type Store struct {
+	path string
+
+	mutex     sync.Mutex
+	playlists map[string]Playlist
+	sessions  map[string]Session
+}
+
+// Open loads the store in the file at path. If the file doesn't exist, then
+// the store is empty.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:      path,
+		playlists: make(map[string]Playlist),
+		sessions:  make(map[string]Session),
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("cannot read playlists: %w", err)
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("cannot decode playlists: %w", err)
+	}
+
+	for _, p := range file.Playlists {
+		s.playlists[p.Name] = p
+	}
+	for device, session := range file.Sessions {
+		s.sessions[device] = session
+	}
+
+	return s, nil
+}
+
+// Playlists returns all playlists sorted by their names.
+func (s *Store) Playlists() []Playlist {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	playlists := make([]Playlist, 0, len(s.playlists))
+	for _, p := range s.playlists {
+		playlists = append(playlists, p.Copy())
+	}
+
+	sort.Slice(playlists, func(i, j int) bool {
+		return strings.ToLower(playlists[i].Name) < strings.ToLower(playlists[j].Name)
+	})
+
+	return playlists
+}
+
+// Get returns the playlist with the given name.
+func (s *Store) Get(name string) (Playlist, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.playlists[name]
+	return p.Copy(), ok
+}
+
+// NewName returns a name that no playlist has, based on the given one.
+func (s *Store) NewName(name string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	newName := name
+	for i := 2; ; i++ {
+		if _, ok := s.playlists[newName]; !ok {
+			return newName
+		}
+		newName = fmt.Sprintf("%s %d", name, i)
+	}
+}
+
+// Put replaces the playlist with the given name by p, or adds p if there's no
+// such playlist. p may be renamed, but not to the name of another playlist.
+func (s *Store) Put(name string, p Playlist) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("playlist has no name")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, taken := s.playlists[p.Name]; taken && p.Name != name {
+		return fmt.Errorf("there is already a playlist named %q", p.Name)
+	}
+
+	delete(s.playlists, name)
+	s.playlists[p.Name] = p.Copy()
+
+	return s.save()
+}
+
+// Delete deletes the playlist with the given name.
+func (s *Store) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.playlists, name)
+	return s.save()
+}
+
+// Session returns the session of the device with the given name.
+func (s *Store) Session(device string) (Session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[device]
+	if !ok {
+		return Session{}, false
+	}
+
+	session.Playlist = session.Playlist.Copy()
+	return session, true
+}
+
+// SetSession sets the session of the device with the given name.
+func (s *Store) SetSession(device string, session Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session.Playlist = session.Playlist.Copy()
+	s.sessions[device] = session
+
+	return s.save()
+}
+
+// ClearSession forgets the session of the device with the given name.
+func (s *Store) ClearSession(device string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.sessions[device]; !ok {
+		return nil
+	}
+
+	delete(s.sessions, device)
+	return s.save()
+}
+
+// save writes the store. The mutex must be held.
+func (s *Store) save() error {
+	file := storeFile{
+		Playlists: make([]Playlist, 0, len(s.playlists)),
+		Sessions:  s.sessions,
+	}
+
+	for _, p := range s.playlists {
+		file.Playlists = append(file.Playlists, p)
+	}
+
+	sort.Slice(file.Playlists, func(i, j int) bool {
+		return file.Playlists[i].Name < file.Playlists[j].Name
+	})
+
+	b, err := json.MarshalIndent(file, "", "\t")
+	if err != nil {
+		return fmt.Errorf("cannot encode playlists: %w", err)
+	}
+
+	return atomicfile.WriteFile(s.path, b)
+}
@@ -0,0 +1,264 @@
+// Package scheduler merges and rate-limits the actuator commands sent to a
+// single device.
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/diamondburned/go-buttplug"
+	"github.com/diamondburned/go-buttplug/device"
+)
+
+// Batch is a set of actuator values that are sent together. Each non-empty
+// map is sent as one command.
+type Batch struct {
+	Vibrate map[int]float64
+	Rotate  map[int]device.Rotation
+	Linear  map[int]device.Vector
+}
+
+// Commands returns the number of commands that the batch is sent as.
+func (b Batch) Commands() int {
+	var n int
+	if len(b.Vibrate) > 0 {
+		n++
+	}
+	if len(b.Rotate) > 0 {
+		n++
+	}
+	if len(b.Linear) > 0 {
+		n++
+	}
+	return n
+}
+
+// Stats counts what a Scheduler has done so far.
+type Stats struct {
+	// Sent is the number of commands sent.
+	Sent uint64
+	// Dropped is the number of actuator values that were never sent, either
+	// because they didn't change or because a newer value replaced them
+	// before they could be sent.
+	Dropped uint64
+	// Failed is the number of batches that couldn't be sent in full.
+	Failed uint64
+}
+
+type actuator struct {
+	kind  buttplug.MessageType
+	index int
+}
+
+// Scheduler merges actuator values set within an interval and sends them in
+// one batch. A Scheduler is safe to use concurrently.
+type Scheduler struct {
+	send func(Batch) error
+	// sendMutex is held while a batch is sent, so that batches are sent one
+	// at a time and in order.
+	sendMutex sync.Mutex
+
+	mutex    sync.Mutex
+	interval time.Duration
+	pending  map[actuator]interface{}
+	sending  map[actuator]interface{} // while a batch is sent
+	last     map[actuator]interface{}
+	lastSend time.Time
+	timer    *time.Timer
+	stats    Stats
+}
+
+// New creates a new Scheduler that calls send at most once every interval.
+// send may be called from another goroutine, but never concurrently.
+func New(interval time.Duration, send func(Batch) error) *Scheduler {
+	return &Scheduler{
+		send:     send,
+		interval: interval,
+		pending:  make(map[actuator]interface{}),
+		last:     make(map[actuator]interface{}),
+	}
+}
+
+// SetInterval sets the shortest time between two batches.
+func (s *Scheduler) SetInterval(interval time.Duration) {
+	s.mutex.Lock()
+	s.interval = interval
+	s.mutex.Unlock()
+}
+
+// Stats returns the current statistics.
+func (s *Scheduler) Stats() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.stats
+}
+
+// Vibrate schedules the given motor speeds.
+func (s *Scheduler) Vibrate(speeds map[int]float64) {
+	s.mutex.Lock()
+	for motor, speed := range speeds {
+		s.set(actuator{buttplug.VibrateCmdMessage, motor}, speed)
+	}
+	s.schedule()
+}
+
+// Rotate schedules the given rotations.
+func (s *Scheduler) Rotate(rotations map[int]device.Rotation) {
+	s.mutex.Lock()
+	for motor, rotation := range rotations {
+		s.set(actuator{buttplug.RotateCmdMessage, motor}, rotation)
+	}
+	s.schedule()
+}
+
+// Linear schedules the given linear vectors.
+func (s *Scheduler) Linear(vectors map[int]device.Vector) {
+	s.mutex.Lock()
+	for motor, vector := range vectors {
+		s.set(actuator{buttplug.LinearCmdMessage, motor}, vector)
+	}
+	s.schedule()
+}
+
+// Resend schedules every value that was last sent again, even though they
+// haven't changed. It is useful for keeping devices awake.
+func (s *Scheduler) Resend() {
+	s.mutex.Lock()
+	for act, v := range s.last {
+		if _, ok := s.pending[act]; !ok {
+			s.pending[act] = v
+		}
+	}
+	s.schedule()
+}
+
+// Stop discards all pending values without sending them.
+func (s *Scheduler) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	s.stats.Dropped += uint64(len(s.pending))
+	s.pending = make(map[actuator]interface{})
+}
+
+// Last returns the values that were last sent.
+func (s *Scheduler) Last() Batch {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return batchOf(s.last)
+}
+
+// set sets the pending value of an actuator. The mutex must be held.
+func (s *Scheduler) set(act actuator, v interface{}) {
+	if _, ok := s.pending[act]; ok {
+		// The pending value is replaced before it was ever sent.
+		s.stats.Dropped++
+		delete(s.pending, act)
+	}
+
+	// The values being sent are newer than the last ones, even though they
+	// may still fail.
+	last, ok := s.sending[act]
+	if !ok {
+		last, ok = s.last[act]
+	}
+	if ok && last == v {
+		s.stats.Dropped++
+		return
+	}
+
+	s.pending[act] = v
+}
+
+// schedule sends the pending values now or once the interval has passed. It
+// releases the mutex.
+func (s *Scheduler) schedule() {
+	if len(s.pending) == 0 || s.timer != nil {
+		s.mutex.Unlock()
+		return
+	}
+
+	wait := s.interval - time.Since(s.lastSend)
+	if wait > 0 {
+		s.timer = time.AfterFunc(wait, s.flush)
+		s.mutex.Unlock()
+		return
+	}
+
+	s.mutex.Unlock()
+	s.flush()
+}
+
+func (s *Scheduler) flush() {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	s.mutex.Lock()
+
+	s.timer = nil
+	if len(s.pending) == 0 {
+		s.mutex.Unlock()
+		return
+	}
+
+	batch := batchOf(s.pending)
+
+	s.sending = s.pending
+	s.pending = make(map[actuator]interface{})
+	s.lastSend = time.Now()
+
+	s.mutex.Unlock()
+
+	err := s.send(batch)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sending := s.sending
+	s.sending = nil
+
+	if err != nil {
+		s.stats.Failed++
+		return
+	}
+
+	// Only values that were sent can be skipped as unchanged later.
+	for act, v := range sending {
+		s.last[act] = v
+	}
+	s.stats.Sent += uint64(batch.Commands())
+}
+
+// batchOf groups the values of actuators into a batch.
+func batchOf(values map[actuator]interface{}) Batch {
+	var batch Batch
+
+	for act, v := range values {
+		switch v := v.(type) {
+		case float64:
+			if batch.Vibrate == nil {
+				batch.Vibrate = make(map[int]float64)
+			}
+			batch.Vibrate[act.index] = v
+		case device.Rotation:
+			if batch.Rotate == nil {
+				batch.Rotate = make(map[int]device.Rotation)
+			}
+			batch.Rotate[act.index] = v
+		case device.Vector:
+			if batch.Linear == nil {
+				batch.Linear = make(map[int]device.Vector)
+			}
+			batch.Linear[act.index] = v
+		}
+	}
+
+	return batch
+}
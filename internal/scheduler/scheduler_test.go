@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingSend is a send function that hands every batch over to the test,
+// then blocks until the test returns its result.
+type blockingSend struct {
+	batches chan Batch
+	results chan error
+}
+
+func newBlockingSend() *blockingSend {
+	return &blockingSend{
+		batches: make(chan Batch),
+		results: make(chan error),
+	}
+}
+
+func (b *blockingSend) send(batch Batch) error {
+	b.batches <- batch
+	return <-b.results
+}
+
+// next returns the batch that is being sent.
+func (b *blockingSend) next(t *testing.T) Batch {
+	t.Helper()
+
+	select {
+	case batch := <-b.batches:
+		return batch
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a batch")
+		return Batch{}
+	}
+}
+
+// none asserts that nothing is being sent.
+func (b *blockingSend) none(t *testing.T) {
+	t.Helper()
+
+	select {
+	case batch := <-b.batches:
+		t.Fatalf("unexpected batch %v", batch)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// waitStats waits until the stats of s are want, since they're only updated
+// once send has returned.
+func waitStats(t *testing.T, s *Scheduler, want Stats) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for s.Stats() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %+v, want %+v", s.Stats(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitPending waits until s has n pending values.
+func waitPending(t *testing.T, s *Scheduler, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mutex.Lock()
+		pending := len(s.pending)
+		s.mutex.Unlock()
+
+		if pending == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d values pending, want %d", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func assertVibrate(t *testing.T, batch Batch, want float64) {
+	t.Helper()
+
+	if got, ok := batch.Vibrate[0]; !ok || got != want || batch.Commands() != 1 {
+		t.Fatalf("sent %v, want motor 0 at %v", batch, want)
+	}
+}
+
+func TestSetDuringSend(t *testing.T) {
+	b := newBlockingSend()
+	s := New(0, b.send)
+
+	go s.Vibrate(map[int]float64{0: 0})
+	assertVibrate(t, b.next(t), 0)
+	b.results <- nil
+
+	go s.Vibrate(map[int]float64{0: 0.8})
+	assertVibrate(t, b.next(t), 0.8)
+
+	// 0 is what was last sent, but 0.8 is on its way, so 0 must follow it.
+	go s.Vibrate(map[int]float64{0: 0})
+	waitPending(t, s, 1)
+	b.results <- nil
+
+	assertVibrate(t, b.next(t), 0)
+	b.results <- nil
+
+	waitStats(t, s, Stats{Sent: 3})
+}
+
+func TestSetSameDuringSend(t *testing.T) {
+	b := newBlockingSend()
+	s := New(0, b.send)
+
+	go s.Vibrate(map[int]float64{0: 0.8})
+	assertVibrate(t, b.next(t), 0.8)
+
+	// The value that's being sent doesn't need to be sent again, so nothing
+	// waits for the send to finish.
+	done := make(chan struct{})
+	go func() {
+		s.Vibrate(map[int]float64{0: 0.8})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the value being sent was scheduled again")
+	}
+	b.results <- nil
+	b.none(t)
+
+	waitStats(t, s, Stats{Sent: 1, Dropped: 1})
+}
+
+func TestFailedSend(t *testing.T) {
+	b := newBlockingSend()
+	s := New(0, b.send)
+
+	go s.Vibrate(map[int]float64{0: 0.5})
+	assertVibrate(t, b.next(t), 0.5)
+	b.results <- errors.New("failed")
+	waitStats(t, s, Stats{Failed: 1})
+
+	// The value wasn't sent, so it isn't skipped as unchanged.
+	go s.Vibrate(map[int]float64{0: 0.5})
+	assertVibrate(t, b.next(t), 0.5)
+	b.results <- nil
+
+	waitStats(t, s, Stats{Sent: 1, Failed: 1})
+}
+
+func TestMerge(t *testing.T) {
+	b := newBlockingSend()
+	s := New(time.Hour, b.send)
+
+	go s.Vibrate(map[int]float64{0: 0.1})
+	assertVibrate(t, b.next(t), 0.1)
+	b.results <- nil
+	waitStats(t, s, Stats{Sent: 1})
+
+	// Within the interval, values are only kept for later.
+
+	// Within the interval, values are only kept for later.
+	s.Vibrate(map[int]float64{0: 0.2})
+	s.Vibrate(map[int]float64{0: 0.3})
+	b.none(t)
+
+	// The replaced value is dropped, then the pending one by Stop.
+	waitStats(t, s, Stats{Sent: 1, Dropped: 1})
+	s.Stop()
+	waitStats(t, s, Stats{Sent: 1, Dropped: 2})
+}
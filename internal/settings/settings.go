@@ -0,0 +1,366 @@
+// Package settings provides the application's persistent settings, which are
+// stored as a JSON file inside the user's configuration directory.
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/intiface-gtk/internal/atomicfile"
+	"github.com/diamondburned/intiface-gtk/internal/httpcache"
+	"github.com/diamondburned/intiface-gtk/internal/limits"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+)
+
+// Settings describes all persistent settings.
+type Settings struct {
+	// CLIPath is the name or path of the Intiface CLI executable.
+	CLIPath string `json:"cli_path"`
+	// CLIArgs are extra arguments given to the Intiface CLI.
+	CLIArgs []string `json:"cli_args,omitempty"`
+	// Port is the websocket port that the Intiface CLI is started on. If it's
+	// taken, the next free port is used.
+	Port int `json:"port"`
+	// UseServer is true if ServerURL should be connected to instead of
+	// spawning the CLI.
+	UseServer bool `json:"use_server"`
+	// ServerURL is the websocket URL of an already-running Intiface server.
+	ServerURL string `json:"server_url"`
+	// SparklineSeconds is the time window of the device sparklines.
+	SparklineSeconds float64 `json:"sparkline_seconds"`
+	// BatteryIntervalSeconds is how often the battery and RSSI levels are
+	// polled.
+	BatteryIntervalSeconds int `json:"battery_interval_seconds"`
+	// CommandIntervalMillis is the shortest time between two commands sent
+	// to the same device. Values set in between are merged.
+	CommandIntervalMillis int `json:"command_interval_ms"`
+	// ScanOnConnect is true if the server should start scanning for devices
+	// as soon as it's connected.
+	ScanOnConnect bool `json:"scan_on_connect"`
+	// ScanTimeoutSeconds stops scanning after this many seconds. If it's 0,
+	// then scanning goes on until it's stopped or the server finishes.
+	ScanTimeoutSeconds int `json:"scan_timeout_seconds"`
+	// CachePath is the directory that downloaded patterns are cached in.
+	CachePath string `json:"cache_path"`
+	// PatternInterpolation is how values between two pattern points are
+	// computed. It's one of the names of playback.Interpolation.
+	PatternInterpolation string `json:"pattern_interpolation"`
+	// LibraryPath is the directory of the pattern library.
+	LibraryPath string `json:"library_path"`
+	// PatternThumbnails is true if the pattern browser should draw small
+	// previews of patterns that are already cached.
+	PatternThumbnails bool `json:"pattern_thumbnails"`
+	// RecordIntervalMillis is the interval of the patterns recorded from the
+	// motor controls.
+	RecordIntervalMillis int `json:"record_interval_ms"`
+	// DeviceLimits caps the output of devices. It is keyed by the device
+	// name.
+	DeviceLimits map[string]limits.Limits `json:"device_limits,omitempty"`
+	// ChannelMappings maps the channels of patterns to device motors. It is
+	// keyed by the device name, then by the pattern layout; see
+	// playback.Layout. The targets don't keep their device, since they're all
+	// on the device that the mapping is saved for.
+	ChannelMappings map[string]map[string]playback.Mapping `json:"channel_mappings,omitempty"`
+}
+
+// Default returns the default settings.
+func Default() Settings {
+	return Settings{
+		CLIPath:                "intiface-cli",
+		Port:                   20000,
+		ServerURL:              "ws://localhost:12345",
+		SparklineSeconds:       3,
+		BatteryIntervalSeconds: 10,
+		CommandIntervalMillis:  50,
+		ScanOnConnect:          true,
+		CachePath:              httpcache.DefaultPath,
+		LibraryPath:            filepath.Join(ConfigDir(), "library"),
+		PatternInterpolation:   "none",
+		PatternThumbnails:      true,
+		RecordIntervalMillis:   100,
+	}
+}
+
+// SparklineDuration returns SparklineSeconds as a duration.
+func (s Settings) SparklineDuration() time.Duration {
+	return time.Duration(s.SparklineSeconds * float64(time.Second))
+}
+
+// BatteryInterval returns BatteryIntervalSeconds as a duration.
+func (s Settings) BatteryInterval() time.Duration {
+	return time.Duration(s.BatteryIntervalSeconds) * time.Second
+}
+
+// CommandInterval returns CommandIntervalMillis as a duration.
+func (s Settings) CommandInterval() time.Duration {
+	return time.Duration(s.CommandIntervalMillis) * time.Millisecond
+}
+
+// RecordInterval returns RecordIntervalMillis as a duration.
+func (s Settings) RecordInterval() time.Duration {
+	return time.Duration(s.RecordIntervalMillis) * time.Millisecond
+}
+
+// ValidateServerURL ensures that the given server address is a valid websocket
+// URL.
+func ValidateServerURL(server string) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("invalid server address: %w", err)
+	}
+
+	switch u.Scheme {
+	case "ws", "wss":
+	default:
+		return fmt.Errorf("invalid server address %q: scheme must be ws or wss", server)
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("invalid server address %q: missing host", server)
+	}
+
+	return nil
+}
+
+// fill replaces invalid or missing values with the defaults.
+func (s *Settings) fill() {
+	def := Default()
+
+	if s.CLIPath == "" {
+		s.CLIPath = def.CLIPath
+	}
+	if s.Port <= 0 || s.Port > 65535 {
+		s.Port = def.Port
+	}
+	if s.ServerURL == "" {
+		s.ServerURL = def.ServerURL
+	}
+	if s.SparklineSeconds <= 0 {
+		s.SparklineSeconds = def.SparklineSeconds
+	}
+	if s.BatteryIntervalSeconds <= 0 {
+		s.BatteryIntervalSeconds = def.BatteryIntervalSeconds
+	}
+	if s.CommandIntervalMillis <= 0 {
+		s.CommandIntervalMillis = def.CommandIntervalMillis
+	}
+	if s.ScanTimeoutSeconds < 0 {
+		s.ScanTimeoutSeconds = 0
+	}
+	if s.CachePath == "" {
+		s.CachePath = def.CachePath
+	}
+	if playback.ParseInterpolation(s.PatternInterpolation).String() != s.PatternInterpolation {
+		s.PatternInterpolation = def.PatternInterpolation
+	}
+	if s.LibraryPath == "" {
+		s.LibraryPath = def.LibraryPath
+	}
+	if s.RecordIntervalMillis <= 0 {
+		s.RecordIntervalMillis = def.RecordIntervalMillis
+	}
+}
+
+var (
+	mutex     sync.RWMutex
+	current   = Default()
+	observers []func(Settings)
+
+	// notifyMutex is taken before mutex is released and held while the
+	// observers are notified, so that they see the settings in the order
+	// they were changed.
+	notifyMutex sync.Mutex
+)
+
+// Path returns the path to the settings file.
+func Path() string {
+	return filepath.Join(ConfigDir(), "settings.json")
+}
+
+// ConfigDir returns the directory that the settings file and other persistent
+// files are stored in.
+func ConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "intiface-gtk")
+}
+
+// Load loads the settings from disk. If the file doesn't exist, then the
+// defaults are kept and no error is returned.
+func Load() error {
+	b, err := os.ReadFile(Path())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("cannot read settings: %w", err)
+	}
+
+	s := Default()
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("cannot decode settings: %w", err)
+	}
+	s.fill()
+
+	mutex.Lock()
+	current = s
+	notifyMutex.Lock()
+	mutex.Unlock()
+
+	notify(s)
+	notifyMutex.Unlock()
+	return nil
+}
+
+// copy returns a deep copy of s.
+func (s Settings) copy() Settings {
+	cpy := s
+	cpy.CLIArgs = append([]string(nil), s.CLIArgs...)
+
+	if s.DeviceLimits != nil {
+		cpy.DeviceLimits = make(map[string]limits.Limits, len(s.DeviceLimits))
+		for name, l := range s.DeviceLimits {
+			cpy.DeviceLimits[name] = l.Copy()
+		}
+	}
+
+	if s.ChannelMappings != nil {
+		cpy.ChannelMappings = make(map[string]map[string]playback.Mapping, len(s.ChannelMappings))
+		for name, mappings := range s.ChannelMappings {
+			cpyMappings := make(map[string]playback.Mapping, len(mappings))
+			for layout, m := range mappings {
+				cpyMappings[layout] = m.Copy()
+			}
+			cpy.ChannelMappings[name] = cpyMappings
+		}
+	}
+
+	return cpy
+}
+
+// Get returns a copy of the current settings.
+func Get() Settings {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return current.copy()
+}
+
+// DeviceLimits returns the limits of the device with the given name. It is
+// cheaper than Get, so it can be called before every command.
+func DeviceLimits(name string) limits.Limits {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	l, ok := current.DeviceLimits[name]
+	if !ok {
+		return limits.None()
+	}
+	return l.Copy()
+}
+
+// SetDeviceLimits sets and saves the limits of the device with the given name.
+func SetDeviceLimits(name string, l limits.Limits) error {
+	return Update(func(s *Settings) {
+		if l.IsNone() {
+			delete(s.DeviceLimits, name)
+			return
+		}
+		if s.DeviceLimits == nil {
+			s.DeviceLimits = make(map[string]limits.Limits, 1)
+		}
+		s.DeviceLimits[name] = l.Copy()
+	})
+}
+
+// ChannelMapping returns the saved mapping of patterns with the given layout
+// onto the device with the given name. It must be put onto the device with
+// playback.Mapping.OnDevice.
+func ChannelMapping(name, layout string) (playback.Mapping, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	m, ok := current.ChannelMappings[name][layout]
+	if !ok {
+		return nil, false
+	}
+	return m.Copy(), true
+}
+
+// SetChannelMapping sets and saves the mapping of patterns with the given
+// layout onto the device with the given name. m should only have targets on
+// that device. A nil mapping removes it.
+func SetChannelMapping(name, layout string, m playback.Mapping) error {
+	return Update(func(s *Settings) {
+		if m == nil {
+			delete(s.ChannelMappings[name], layout)
+			if len(s.ChannelMappings[name]) == 0 {
+				delete(s.ChannelMappings, name)
+			}
+			return
+		}
+
+		if s.ChannelMappings == nil {
+			s.ChannelMappings = make(map[string]map[string]playback.Mapping, 1)
+		}
+		if s.ChannelMappings[name] == nil {
+			s.ChannelMappings[name] = make(map[string]playback.Mapping, 1)
+		}
+		s.ChannelMappings[name][layout] = m.Copy()
+	})
+}
+
+// Update calls f with the current settings, then saves whatever f changed and
+// notifies the observers. Updates are saved in the order they're made.
+func Update(f func(s *Settings)) error {
+	mutex.Lock()
+	s := current.copy()
+	f(&s)
+	s.fill()
+	current = s
+	err := save(s)
+	notifyMutex.Lock()
+	mutex.Unlock()
+
+	notify(s)
+	notifyMutex.Unlock()
+	return err
+}
+
+// Observe adds f to be called everytime the settings are changed. f is called
+// in whichever goroutine Load or Update is called in, and must not update the
+// settings itself.
+func Observe(f func(Settings)) {
+	mutex.Lock()
+	observers = append(observers, f)
+	mutex.Unlock()
+}
+
+func notify(s Settings) {
+	mutex.RLock()
+	fns := make([]func(Settings), len(observers))
+	copy(fns, observers)
+	mutex.RUnlock()
+
+	for _, fn := range fns {
+		fn(s)
+	}
+}
+
+func save(s Settings) error {
+	b, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return fmt.Errorf("cannot encode settings: %w", err)
+	}
+
+	return atomicfile.WriteFile(Path(), b)
+}
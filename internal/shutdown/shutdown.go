@@ -0,0 +1,151 @@
+// Package shutdown makes sure that all devices are stopped before the
+// connection to the server goes away, whether the application exits normally,
+// gets a signal or panics.
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/go-buttplug"
+)
+
+// Timeout is the default duration to wait for the server to acknowledge
+// StopAllDevices.
+const Timeout = 2 * time.Second
+
+// Conn is a connection that can send commands to the server. It is satisfied
+// by *buttplug.Websocket.
+type Conn interface {
+	Command(ctx context.Context, msg buttplug.Message) (buttplug.Message, error)
+}
+
+var _ Conn = (*buttplug.Websocket)(nil)
+
+// StopAll sends StopAllDevices over conn and waits until the server
+// acknowledges it or until timeout.
+func StopAll(conn Conn, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	reply, err := conn.Command(ctx, &buttplug.StopAllDevices{})
+	if err != nil {
+		return fmt.Errorf("cannot stop all devices: %w", err)
+	}
+
+	if _, ok := reply.(*buttplug.OK); !ok {
+		return fmt.Errorf("cannot stop all devices: unexpected reply %s", reply.MessageType())
+	}
+
+	return nil
+}
+
+var (
+	mutex   sync.Mutex
+	current Conn
+	hooks   []*hook
+)
+
+type hook struct{ halt func() }
+
+// OnStop adds halt to be called by Stop before StopAllDevices is sent. halt
+// should stop whatever keeps sending commands to devices, and it may be
+// called from any goroutine. The returned function removes it again.
+func OnStop(halt func()) (remove func()) {
+	h := &hook{halt}
+
+	mutex.Lock()
+	hooks = append(hooks, h)
+	mutex.Unlock()
+
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		for i, other := range hooks {
+			if other == h {
+				hooks = append(hooks[:i], hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// SetConn sets the connection that Stop sends its command over. A nil conn
+// clears it.
+func SetConn(conn Conn) {
+	mutex.Lock()
+	current = conn
+	mutex.Unlock()
+}
+
+// ClearConn clears the current connection if it's still conn.
+func ClearConn(conn Conn) {
+	mutex.Lock()
+	if current == conn {
+		current = nil
+	}
+	mutex.Unlock()
+}
+
+// Stop calls the functions added with OnStop in the order they were added,
+// then stops all devices on the current connection, if any, with the default
+// Timeout.
+func Stop() error {
+	mutex.Lock()
+	conn := current
+	halts := make([]func(), len(hooks))
+	for i, h := range hooks {
+		halts[i] = h.halt
+	}
+	mutex.Unlock()
+
+	halt(halts, Timeout)
+
+	if conn == nil {
+		return nil
+	}
+
+	return StopAll(conn, Timeout)
+}
+
+// halt calls every function in halts, but gives up waiting on them after
+// timeout. A panicking goroutine might be what a function waits on, so the
+// devices are stopped regardless.
+func halt(halts []func(), timeout time.Duration) {
+	if len(halts) == 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, halt := range halts {
+			halt()
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Println("timed out halting before stopping all devices")
+	}
+}
+
+// Recover should be deferred at the top of goroutines. If the goroutine
+// panics, then it stops all devices before panicking again.
+func Recover() {
+	if v := recover(); v != nil {
+		log.Println("panic caught, stopping all devices:", v)
+		if err := Stop(); err != nil {
+			log.Println(err)
+		}
+		panic(v)
+	}
+}
@@ -0,0 +1,291 @@
+package shutdown
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/go-buttplug"
+	"github.com/gorilla/websocket"
+)
+
+// fakeConn records the commands sent to it. If block is true, then it waits
+// for the context to be done instead of replying.
+type fakeConn struct {
+	mutex sync.Mutex
+	sent  []buttplug.Message
+	reply buttplug.Message
+	block bool
+}
+
+func (c *fakeConn) Command(ctx context.Context, msg buttplug.Message) (buttplug.Message, error) {
+	c.mutex.Lock()
+	c.sent = append(c.sent, msg)
+	c.mutex.Unlock()
+
+	if c.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	return c.reply, nil
+}
+
+func (c *fakeConn) commands() []buttplug.Message {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]buttplug.Message(nil), c.sent...)
+}
+
+func assertStopAllSent(t *testing.T, conn *fakeConn) {
+	t.Helper()
+
+	sent := conn.commands()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d commands, want 1", len(sent))
+	}
+	if _, ok := sent[0].(*buttplug.StopAllDevices); !ok {
+		t.Fatalf("sent %s, want StopAllDevices", sent[0].MessageType())
+	}
+}
+
+func TestStopAll(t *testing.T) {
+	conn := &fakeConn{reply: &buttplug.OK{}}
+
+	if err := StopAll(conn, time.Second); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	assertStopAllSent(t, conn)
+}
+
+func TestStopAllUnexpectedReply(t *testing.T) {
+	conn := &fakeConn{reply: &buttplug.Error{}}
+
+	if err := StopAll(conn, time.Second); err == nil {
+		t.Fatal("expected error for a reply that isn't OK")
+	}
+
+	assertStopAllSent(t, conn)
+}
+
+func TestStopAllTimeout(t *testing.T) {
+	conn := &fakeConn{block: true}
+
+	const timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	err := StopAll(conn, timeout)
+	took := time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want a deadline error", err)
+	}
+	if took < timeout || took > 10*timeout {
+		t.Fatalf("returned after %v, want about %v", took, timeout)
+	}
+
+	assertStopAllSent(t, conn)
+}
+
+func TestStop(t *testing.T) {
+	conn := &fakeConn{reply: &buttplug.OK{}}
+
+	SetConn(conn)
+	defer ClearConn(conn)
+
+	var order []string
+	var sentBefore []int
+
+	removeA := OnStop(func() {
+		order = append(order, "a")
+		sentBefore = append(sentBefore, len(conn.commands()))
+	})
+	defer removeA()
+	removeB := OnStop(func() { order = append(order, "b") })
+	removeB()
+	removeC := OnStop(func() {
+		order = append(order, "c")
+		sentBefore = append(sentBefore, len(conn.commands()))
+	})
+	defer removeC()
+
+	if err := Stop(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if len(order) != 2 || order[0] != "a" || order[1] != "c" {
+		t.Fatalf("halted %v, want [a c]", order)
+	}
+	for i, n := range sentBefore {
+		if n != 0 {
+			t.Errorf("hook %s ran after %d commands were sent, want before", order[i], n)
+		}
+	}
+
+	assertStopAllSent(t, conn)
+}
+
+func TestStopCleared(t *testing.T) {
+	conn := &fakeConn{reply: &buttplug.OK{}}
+
+	SetConn(conn)
+	ClearConn(conn)
+
+	if err := Stop(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if sent := conn.commands(); len(sent) != 0 {
+		t.Fatalf("sent %d commands after the connection was cleared", len(sent))
+	}
+}
+
+func TestHaltTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	const timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	halt([]func(){func() { <-block }}, timeout)
+
+	if took := time.Since(start); took < timeout || took > 10*timeout {
+		t.Fatalf("returned after %v, want about %v", took, timeout)
+	}
+}
+
+// fakeServer is a Buttplug server that replies to the handshake and to
+// StopAllDevices. It records the type of every message it receives, and
+// "close" once the client has closed the connection.
+type fakeServer struct {
+	*httptest.Server
+	events chan string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{events: make(chan string, 16)}
+
+	var upgrader websocket.Upgrader
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error("cannot upgrade:", err)
+			return
+		}
+		defer conn.Close()
+
+		for {
+			var msgs []map[buttplug.MessageType]json.RawMessage
+			if err := conn.ReadJSON(&msgs); err != nil {
+				s.events <- "close"
+				return
+			}
+
+			for _, msg := range msgs {
+				for t, raw := range msg {
+					s.events <- string(t)
+					s.reply(conn, t, raw)
+				}
+			}
+		}
+	}))
+
+	return s
+}
+
+func (s *fakeServer) reply(conn *websocket.Conn, t buttplug.MessageType, raw json.RawMessage) {
+	var msg struct {
+		ID buttplug.ID `json:"Id"`
+	}
+	json.Unmarshal(raw, &msg)
+
+	var reply buttplug.Message
+	switch t {
+	case buttplug.RequestServerInfoMessage:
+		reply = &buttplug.ServerInfo{ID: msg.ID, MessageVersion: buttplug.Version}
+	default:
+		reply = &buttplug.OK{ID: msg.ID}
+	}
+
+	conn.WriteJSON([]buttplug.Messages{{reply.MessageType(): reply}})
+}
+
+// next returns the next event of the server.
+func (s *fakeServer) next(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case ev := <-s.events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the server")
+		return ""
+	}
+}
+
+func TestStopWebsocket(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ws := buttplug.NewWebsocket()
+	evs := ws.Open(ctx, "ws"+strings.TrimPrefix(server.URL, "http"))
+
+	connected := make(chan struct{})
+	go func() {
+		for ev := range evs {
+			if _, ok := ev.(*buttplug.ServerInfo); ok {
+				close(connected)
+			}
+		}
+	}()
+
+	if ev := server.next(t); ev != string(buttplug.RequestServerInfoMessage) {
+		t.Fatalf("server got %s, want RequestServerInfo", ev)
+	}
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the handshake")
+	}
+
+	SetConn(ws)
+	defer ClearConn(ws)
+
+	var halted bool
+	remove := OnStop(func() {
+		halted = true
+		select {
+		case ev := <-server.events:
+			t.Errorf("server got %s before the hooks were done", ev)
+		default:
+		}
+	})
+	defer remove()
+
+	if err := Stop(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	cancel()
+
+	if !halted {
+		t.Error("hook wasn't called")
+	}
+	if ev := server.next(t); ev != string(buttplug.StopAllDevicesMessage) {
+		t.Fatalf("server got %s, want StopAllDevices", ev)
+	}
+	if ev := server.next(t); ev != "close" {
+		t.Fatalf("server got %s, want the connection closed", ev)
+	}
+}
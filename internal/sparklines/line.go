@@ -99,6 +99,24 @@ func (l *Line) SetPoints(lineName string, pts map[time.Time]float64) {
 	l.plot.QueueDraw()
 }
 
+// SetValues overrides a static plot's line with the given values, which are
+// spread evenly along the X axis.
+func (l *Line) SetValues(values []float64) {
+	l.XYs = l.XYs[:0]
+
+	if len(values) > 0 {
+		dx := (l.plot.X.Max - l.plot.X.Min) / float64(len(values))
+		for i, v := range values {
+			l.XYs = append(l.XYs, plotter.XY{
+				X: l.plot.X.Min + float64(i)*dx,
+				Y: l.plot.Y.Max - v,
+			})
+		}
+	}
+
+	l.plot.QueueDraw()
+}
+
 // SetColor sets the line's color.
 func (l *Line) SetColor(clr color.Color) {
 	l.LineStyle.Color = l.plot.mustColor(clr)
@@ -27,39 +27,15 @@ type Plot struct {
 	padding [2]float64 // x, y
 	tneedle time.Duration
 	trange  time.Duration
+	// static is true if the X axis isn't tied to the current time.
+	static bool
 
 	clockHandle glib.SourceHandle
 }
 
 // NewPlot creates a new plot.
 func NewPlot() *Plot {
-	p := &Plot{
-		Plot: plot.New(),
-	}
-	p.SetPadding(0, 0)
-	p.HideAxes()
-	p.BackgroundColor = color.Transparent
-
-	p.DrawingArea = gtk.NewDrawingArea()
-	p.DrawingArea.AddCSSClass("sparklines")
-	p.DrawingArea.SetDrawFunc(func(_ *gtk.DrawingArea, t *cairo.Context, x, y int) {
-		xf := float64(x)
-		yf := float64(y)
-
-		// canvas := draw.NewCanvas(vgcairo.NewCanvas(t), 0, 0)
-		// // X is left-right.
-		// canvas.Min.X = vg.Length(p.padding[0])
-		// canvas.Max.X = vg.Length(xf - p.padding[1])
-		// // Y is top-bottom
-		// canvas.Min.Y = vg.Length(p.padding[2])
-		// canvas.Max.Y = vg.Length(yf - p.padding[3])
-
-		p.Plot.Draw(draw.NewCanvas(
-			vgcairo.NewCanvas(t),
-			vg.Length(xf-p.padding[0]),
-			vg.Length(yf-p.padding[1]),
-		))
-	})
+	p := newPlot()
 
 	currentFPS := 0.0
 
@@ -95,6 +71,49 @@ func NewPlot() *Plot {
 	return p
 }
 
+// NewStaticPlot creates a new plot whose X axis spans [0, maxX] instead of
+// following the current time. Its lines are set with SetValues, and its needle
+// is moved with SetNeedleX.
+func NewStaticPlot(maxX float64) *Plot {
+	p := newPlot()
+	p.static = true
+	p.Plot.X.Min = 0
+	p.Plot.X.Max = maxX
+	return p
+}
+
+func newPlot() *Plot {
+	p := &Plot{
+		Plot: plot.New(),
+	}
+	p.SetPadding(0, 0)
+	p.HideAxes()
+	p.BackgroundColor = color.Transparent
+
+	p.DrawingArea = gtk.NewDrawingArea()
+	p.DrawingArea.AddCSSClass("sparklines")
+	p.DrawingArea.SetDrawFunc(func(_ *gtk.DrawingArea, t *cairo.Context, x, y int) {
+		xf := float64(x)
+		yf := float64(y)
+
+		// canvas := draw.NewCanvas(vgcairo.NewCanvas(t), 0, 0)
+		// // X is left-right.
+		// canvas.Min.X = vg.Length(p.padding[0])
+		// canvas.Max.X = vg.Length(xf - p.padding[1])
+		// // Y is top-bottom
+		// canvas.Min.Y = vg.Length(p.padding[2])
+		// canvas.Max.Y = vg.Length(yf - p.padding[3])
+
+		p.Plot.Draw(draw.NewCanvas(
+			vgcairo.NewCanvas(t),
+			vg.Length(xf-p.padding[0]),
+			vg.Length(yf-p.padding[1]),
+		))
+	})
+
+	return p
+}
+
 // SetPadding sets the Plot's padding.
 func (p *Plot) SetPadding(xPad, yPad float64) {
 	p.padding = [2]float64{xPad, yPad}
@@ -147,6 +166,7 @@ func (p *Plot) SetNeedle(d time.Duration, clr color.Color, width float64) {
 			},
 			StepStyle: plotter.PreStep,
 		}
+		p.Plot.Add(p.needle)
 	}
 
 	p.tneedle = d
@@ -163,7 +183,23 @@ func (p *Plot) SetDuration(d time.Duration) {
 	p.InvalidateTime()
 }
 
+// SetNeedleX moves the needle of a static plot to x. SetNeedle must be called
+// first.
+func (p *Plot) SetNeedleX(x float64) {
+	if p.needle == nil {
+		return
+	}
+
+	p.needle.XYs[0].X = x
+	p.needle.XYs[1].X = x
+	p.QueueDraw()
+}
+
 func (p *Plot) invalidateTime() {
+	if p.static {
+		return
+	}
+
 	now := time.Now()
 
 	p.Plot.X.Min = timeX(now.Add(-p.trange))
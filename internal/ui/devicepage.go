@@ -3,31 +3,52 @@ package ui
 import (
 	"errors"
 	"fmt"
-	"time"
+	"image/color"
 
 	"github.com/diamondburned/go-buttplug"
 	"github.com/diamondburned/go-buttplug/device"
 	"github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
 	"github.com/diamondburned/intiface-gtk/internal/sparklines"
 )
 
 type valueRange struct {
 	SetValue func(float64)
-	Changed  func()
+	// Value returns the value that the range shows, which is within [0, 100].
+	Value   func() float64
+	Changed func()
+	// Kind is the type of the message that the range sends.
+	Kind buttplug.MessageType
+	// Motor is the index of the actuator that the range controls.
+	Motor int
+	// SetCap shows the actuator's maximum value, which is within [0, 1].
+	SetCap func(float64)
 }
 
-func setRanges(ranges []valueRange, v float64) {
-	for _, vrange := range ranges {
-		vrange.SetValue(v)
+// rangesOf returns the ranges that send the given message type.
+func (p *DevicePage) rangesOf(kind buttplug.MessageType) []valueRange {
+	var ranges []valueRange
+	for _, vrange := range p.ranges {
+		if vrange.Kind == kind {
+			ranges = append(ranges, vrange)
+		}
 	}
+	return ranges
 }
 
 // DevicePage is a page for a single device.
 type DevicePage struct {
 	*gtk.Box
 	*device.Controller
-	ranges []valueRange
+	stack   *DeviceStack
+	output  *deviceOutput
+	ranges  []valueRange
+	players map[*patternPlayer]struct{}
+	// patterns is the pattern box below the controls.
+	patterns *patternBox
+	// onStop is called by Stop before the ranges are zeroed.
+	onStop []func()
 
 	sparklines *sparklines.Plot
 
@@ -41,6 +62,9 @@ type DevicePage struct {
 	canBattery bool
 	loaded     bool
 	paused     bool
+	// observing is true while the ranges only show values that have already
+	// been sent elsewhere.
+	observing bool
 }
 
 // NewDevicePage creates a new device page.
@@ -48,14 +72,30 @@ func NewDevicePage(ctrl *device.Controller) *DevicePage {
 	box := gtk.NewBox(gtk.OrientationVertical, 0)
 	box.AddCSSClass("device-page")
 
+	ctrl = ctrl.WithAsync()
+
 	return &DevicePage{
 		Box:        box,
-		Controller: ctrl.WithAsync(),
+		Controller: ctrl,
+		output:     newDeviceOutput(ctrl),
+		players:    map[*patternPlayer]struct{}{},
 		canRSSI:    true,
 		canBattery: true,
 	}
 }
 
+// deviceName returns the name of the page's device, which is usually its
+// model.
+func (p *DevicePage) deviceName() string {
+	return string(p.Controller.Name)
+}
+
+// deviceIndex returns the index of the page's device on the server, which
+// tells it apart from other devices of the same model.
+func (p *DevicePage) deviceIndex() int {
+	return int(p.Controller.Index)
+}
+
 func (p *DevicePage) Load() {
 	if !p.loaded {
 		p.load()
@@ -72,12 +112,16 @@ func (p *DevicePage) load() {
 func (p *DevicePage) loadGraph() {
 	p.sparklines = sparklines.NewPlot()
 	p.sparklines.AddCSSClass("vibrator-sparkline")
-	p.sparklines.SetDuration(3 * time.Second)
+	p.sparklines.SetDuration(settings.Get().SparklineDuration())
 	p.sparklines.SetRange(0, 100)
 	p.sparklines.SetPadding(4, 2)
 	p.sparklines.SetMinHeight(80)
 	// p.sparklines.SetNeedle(0, color.RGBA{255, 0, 0, 255}, 2)
 
+	p.sparklines.ConnectMap(func() {
+		p.sparklines.SetDuration(settings.Get().SparklineDuration())
+	})
+
 	p.Box.Append(p.sparklines)
 }
 
@@ -88,75 +132,230 @@ func (p *DevicePage) loadBody() {
 	box.SetVExpand(true)
 	box.AddCSSClass("device-controls")
 
-	if motorSteps := p.VibrationSteps(); len(motorSteps) > 0 {
-		child := gtk.NewBox(gtk.OrientationHorizontal, 0)
-
-		for motor, steps := range p.VibrationSteps() {
-			motor := motor
-			steps := float64(steps)
-			color := sparklines.HashColor("v", 2<<((motor+1)*8)) // make int variance larger
-
-			line := p.sparklines.AddLine()
-			line.Smooth = true
-			line.SetWidth(2)
-			line.SetColor(color)
-
-			scale := gtk.NewScaleWithRange(gtk.OrientationVertical, 0, 100, 100/steps)
-			scale.SetDigits(2)
-			scale.SetInverted(true)
-			scale.SetVExpand(true)
-			scale.SetValue(0)
-			scale.SetDrawValue(true)
-			scale.SetFormatValueFunc(func(scale *gtk.Scale, value float64) string {
-				return fmt.Sprintf("%.0f%%", value)
-			})
+	p.loadVibrators(box)
+	p.loadRotators(box)
+	p.loadLinears(box)
 
-			changed := func() {
-				value := scale.Value()
-				line.AddPoint(value)
+	p.scroll = gtk.NewScrolledWindow()
+	p.scroll.SetHExpand(true)
+	p.scroll.SetVExpand(true)
+	p.scroll.SetPolicy(gtk.PolicyAutomatic, gtk.PolicyNever)
+	p.scroll.SetChild(box)
 
-				if p.paused {
-					value = 0
-				} else {
-					value /= 100
-				}
-				p.Controller.Vibrate(map[int]float64{motor: value})
+	p.Box.Append(p.scroll)
+}
+
+func (p *DevicePage) loadVibrators(box *gtk.Box) {
+	motorSteps := p.VibrationSteps()
+	if len(motorSteps) == 0 {
+		return
+	}
+
+	child := gtk.NewBox(gtk.OrientationHorizontal, 0)
+
+	for motor, steps := range motorSteps {
+		motor := motor
+		color := sparklines.HashColor("v", 2<<((motor+1)*8)) // make int variance larger
+
+		line := p.sparklines.AddLine()
+		line.Smooth = true
+		line.SetWidth(2)
+		line.SetColor(color)
+
+		scale := newMotorScale(steps)
+
+		changed := func() {
+			value := scale.Value()
+			line.AddPoint(value)
+
+			if p.observing {
+				return
+			}
+
+			if p.paused {
+				value = 0
+			} else {
+				value /= 100
 			}
+			p.output.Vibrate(map[int]float64{motor: value})
+		}
+
+		scale.ConnectValueChanged(changed)
+		p.ranges = append(p.ranges, valueRange{
+			SetValue: scale.SetValue,
+			Value:    scale.Value,
+			Changed:  changed,
+			Kind:     buttplug.VibrateCmdMessage,
+			Motor:    motor,
+			SetCap:   func(cap float64) { setScaleCap(scale, cap) },
+		})
+
+		box := gtk.NewBox(gtk.OrientationVertical, 2)
+		box.Append(scale)
+		box.Append(newMotorLabel(buttplug.VibrateCmdMessage, motor, color))
+
+		child.Append(box)
+	}
+
+	frame := gtk.NewFrame("Vibrator")
+	frame.AddCSSClass("vibrators")
+	frame.SetLabelAlign(0)
+	frame.SetChild(child)
+
+	box.Append(frame)
+}
+
+func (p *DevicePage) loadRotators(box *gtk.Box) {
+	motorSteps := p.actuatorSteps(buttplug.RotateCmdMessage)
+	if len(motorSteps) == 0 {
+		return
+	}
+
+	child := gtk.NewBox(gtk.OrientationHorizontal, 0)
+
+	for motor, steps := range motorSteps {
+		motor := motor
+		color := sparklines.HashColor("r", 2<<((motor+1)*8))
+
+		line := p.sparklines.AddLine()
+		line.Smooth = true
+		line.SetWidth(2)
+		line.SetColor(color)
 
-			scale.ConnectValueChanged(changed)
-			p.ranges = append(p.ranges, valueRange{
-				SetValue: scale.SetValue,
-				Changed:  changed,
+		scale := newMotorScale(steps)
+
+		clockwise := gtk.NewToggleButton()
+		clockwise.SetActive(true)
+		clockwise.SetHAlign(gtk.AlignCenter)
+		clockwise.AddCSSClass("rotator-direction")
+
+		updateDirection := func() {
+			if clockwise.Active() {
+				clockwise.SetIconName("object-rotate-right-symbolic")
+				clockwise.SetTooltipText("Clockwise")
+			} else {
+				clockwise.SetIconName("object-rotate-left-symbolic")
+				clockwise.SetTooltipText("Counter-clockwise")
+			}
+		}
+		updateDirection()
+
+		changed := func() {
+			value := scale.Value()
+			line.AddPoint(value)
+
+			if p.observing {
+				return
+			}
+
+			if p.paused {
+				value = 0
+			} else {
+				value /= 100
+			}
+			p.output.Rotate(map[int]device.Rotation{
+				motor: {Speed: value, Clockwise: clockwise.Active()},
 			})
+		}
 
-			name := gtk.NewLabel("")
-			name.SetMarkup(fmt.Sprintf(
-				`<span color="%s">Motor %d</span>`,
-				sparklines.HexColor(color), motor,
-			))
+		scale.ConnectValueChanged(changed)
+		clockwise.ConnectToggled(func() {
+			updateDirection()
+			changed()
+		})
+
+		p.ranges = append(p.ranges, valueRange{
+			SetValue: scale.SetValue,
+			Value:    scale.Value,
+			Changed:  changed,
+			Kind:     buttplug.RotateCmdMessage,
+			Motor:    motor,
+			SetCap:   func(cap float64) { setScaleCap(scale, cap) },
+		})
 
-			box := gtk.NewBox(gtk.OrientationVertical, 2)
-			box.Append(scale)
-			box.Append(name)
+		box := gtk.NewBox(gtk.OrientationVertical, 2)
+		box.Append(scale)
+		box.Append(clockwise)
+		box.Append(newMotorLabel(buttplug.RotateCmdMessage, motor, color))
 
-			child.Append(box)
+		child.Append(box)
+	}
+
+	frame := gtk.NewFrame("Rotator")
+	frame.AddCSSClass("rotators")
+	frame.SetLabelAlign(0)
+	frame.SetChild(child)
+
+	box.Append(frame)
+}
+
+// actuatorSteps returns the step count of each actuator that accepts the given
+// message. If the server only gives the actuator count, then each actuator is
+// assumed to have 100 steps.
+func (p *DevicePage) actuatorSteps(msg buttplug.MessageType) []int {
+	attrs, ok := p.Messages[msg]
+	if !ok {
+		return nil
+	}
+
+	if attrs.StepCount != nil {
+		return *attrs.StepCount
+	}
+
+	if attrs.FeatureCount != nil {
+		steps := make([]int, *attrs.FeatureCount)
+		for i := range steps {
+			steps[i] = 100
 		}
+		return steps
+	}
 
-		frame := gtk.NewFrame("Vibrator")
-		frame.AddCSSClass("vibrators")
-		frame.SetLabelAlign(0)
-		frame.SetChild(child)
+	return nil
+}
+
+func newMotorScale(steps int) *gtk.Scale {
+	scale := gtk.NewScaleWithRange(gtk.OrientationVertical, 0, 100, 100/float64(steps))
+	scale.SetDigits(2)
+	scale.SetInverted(true)
+	scale.SetVExpand(true)
+	scale.SetValue(0)
+	scale.SetDrawValue(true)
+	scale.SetFormatValueFunc(func(scale *gtk.Scale, value float64) string {
+		return fmt.Sprintf("%.0f%%", value)
+	})
+	return scale
+}
 
-		box.Append(frame)
+// setScaleCap marks the given cap within [0, 1] on a [0, 100] scale.
+func setScaleCap(scale *gtk.Scale, cap float64) {
+	scale.ClearMarks()
+	if cap < 1 {
+		scale.AddMark(cap*100, gtk.PosRight, "")
 	}
+}
 
-	p.scroll = gtk.NewScrolledWindow()
-	p.scroll.SetHExpand(true)
-	p.scroll.SetVExpand(true)
-	p.scroll.SetPolicy(gtk.PolicyAutomatic, gtk.PolicyNever)
-	p.scroll.SetChild(box)
+// actuatorName returns the name of the actuator that accepts the given
+// message.
+func actuatorName(kind buttplug.MessageType) string {
+	switch kind {
+	case buttplug.VibrateCmdMessage:
+		return "Motor"
+	case buttplug.RotateCmdMessage:
+		return "Rotator"
+	case buttplug.LinearCmdMessage:
+		return "Linear"
+	default:
+		return string(kind)
+	}
+}
 
-	p.Box.Append(p.scroll)
+func newMotorLabel(kind buttplug.MessageType, motor int, clr color.Color) *gtk.Label {
+	name := gtk.NewLabel("")
+	name.SetMarkup(fmt.Sprintf(
+		`<span color="%s">%s %d</span>`,
+		sparklines.HexColor(clr), actuatorName(kind), motor,
+	))
+	return name
 }
 
 func (p *DevicePage) loadBelow() {
@@ -192,7 +391,11 @@ func (p *DevicePage) loadBelow() {
 
 	more := gtk.NewBox(gtk.OrientationVertical, 0)
 	more.AddCSSClass("more")
-	more.Append(newPatternBox(p))
+	p.patterns = newPatternBox(p)
+
+	more.Append(p.patterns)
+	more.Append(newLimitsBox(p))
+	more.Append(p.newStatsLabel())
 
 	moreScroll := gtk.NewScrolledWindow()
 	moreScroll.SetChild(more)
@@ -216,6 +419,7 @@ func (p *DevicePage) loadBelow() {
 	p.actions.SetCenterWidget(revealButton)
 	p.actions.PackStart(indicators)
 	p.actions.PackEnd(pause)
+	p.actions.PackEnd(newPatternRecorder(p))
 
 	p.Box.Append(p.actions)
 	p.Box.Append(reveal)
@@ -253,31 +457,99 @@ func (p *DevicePage) updateIndicators() {
 
 func (p *DevicePage) keepAlive() {
 	if !p.canBattery && !p.canRSSI {
-		p.setSameValues()
+		p.output.Resend()
 	}
 }
 
+// newStatsLabel creates a label that shows how many commands were sent to the
+// device. It is updated every second while it's visible.
+func (p *DevicePage) newStatsLabel() *gtk.Label {
+	label := gtk.NewLabel("")
+	label.SetXAlign(0)
+	label.AddCSSClass("dim-label")
+	label.AddCSSClass("more-stats")
+
+	update := func() {
+		stats := p.output.Stats()
+		label.SetText(fmt.Sprintf(
+			"%d commands sent, %d values dropped",
+			stats.Sent, stats.Dropped,
+		))
+	}
+
+	var t glib.SourceHandle
+
+	label.ConnectMap(func() {
+		update()
+		t = glib.TimeoutSecondsAdd(1, func() bool {
+			update()
+			return true
+		})
+	})
+
+	label.ConnectUnmap(func() {
+		if t > 0 {
+			glib.SourceRemove(t)
+			t = 0
+		}
+	})
+
+	return label
+}
+
 func (p *DevicePage) setSameValues() {
 	for _, rangeValue := range p.ranges {
 		rangeValue.Changed()
 	}
 }
 
+// Stop halts every pattern player on the page and zeroes all motors.
+func (p *DevicePage) Stop() {
+	for player := range p.players {
+		player.halt()
+	}
+	for _, stop := range p.onStop {
+		stop()
+	}
+	p.setZeroValues()
+}
+
+// updateCaps shows the device's current limits on its ranges and resends
+// their values, so that a lowered cap applies right away.
+func (p *DevicePage) updateCaps() {
+	limits := settings.DeviceLimits(p.deviceName())
+	for _, vrange := range p.ranges {
+		if vrange.SetCap != nil {
+			vrange.SetCap(limits.Cap(vrange.Kind, vrange.Motor))
+		}
+	}
+	p.setSameValues()
+}
+
+// showValue sets the value of a range without sending it.
+func (p *DevicePage) showValue(vrange valueRange, v float64) {
+	p.observing = true
+	vrange.SetValue(v)
+	p.observing = false
+}
+
 func (p *DevicePage) setZeroValues() {
 	for _, rangeValue := range p.ranges {
 		rangeValue.SetValue(0)
 	}
 }
 
-const batteryUpdateFreq = 10 // seconds
-
 func (p *DevicePage) mapUpdate(widget gtk.Widgetter) {
 	var t glib.SourceHandle
 
 	w := gtk.BaseWidget(widget)
 
 	w.ConnectMap(func() {
-		t = glib.TimeoutSecondsAdd(batteryUpdateFreq, func() bool {
+		s := settings.Get()
+		p.output.SetInterval(s.CommandInterval())
+
+		freq := s.BatteryIntervalSeconds
+		t = glib.TimeoutSecondsAdd(uint(freq), func() bool {
 			p.updateIndicators()
 			p.keepAlive()
 			return true
@@ -294,5 +566,6 @@ func (p *DevicePage) mapUpdate(widget gtk.Widgetter) {
 
 func (p *DevicePage) setPaused(paused bool) {
 	p.paused = paused
+	p.output.SetPaused(paused)
 	p.setSameValues()
 }
@@ -3,11 +3,14 @@ package ui
 import (
 	"fmt"
 	"log"
+	"sort"
+	"sync"
 
 	"github.com/diamondburned/go-buttplug"
 	"github.com/diamondburned/go-buttplug/device"
 	"github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/intiface-gtk/internal/shutdown"
 )
 
 // DeviceStack is a stasck containing devices.
@@ -16,7 +19,13 @@ type DeviceStack struct {
 	Manager *Manager
 	devices map[string]*DevicePage
 
+	// outputs holds the output of each device by its device index, so that
+	// pattern engines can send to other devices from their goroutines.
+	outputs     map[int]*deviceOutput
+	outputMutex sync.RWMutex
+
 	onDevice func()
+	unhook   func()
 }
 
 // NewDeviceStack creates a new devices stack.
@@ -25,6 +34,7 @@ func NewDeviceStack(Manager *Manager) *DeviceStack {
 		Stack:   gtk.NewStack(),
 		Manager: Manager,
 		devices: map[string]*DevicePage{},
+		outputs: map[int]*deviceOutput{},
 	}
 	s.AddCSSClass("devices-stack")
 	s.SetTransitionType(gtk.StackTransitionTypeCrossfade)
@@ -35,10 +45,14 @@ func NewDeviceStack(Manager *Manager) *DeviceStack {
 	greet := gtk.NewLabel("Select a device on the left panel.")
 	s.AddNamed(greet, "_greet_")
 
+	s.unhook = shutdown.OnStop(s.halt)
+
 	ch := Manager.Broadcaster.Listen()
 	s.updateDevices()
 
 	go func() {
+		defer shutdown.Recover()
+
 		for ev := range ch {
 			switch ev := ev.(type) {
 			case *buttplug.DeviceAdded:
@@ -84,11 +98,102 @@ func (s *DeviceStack) TriggerOnDevice() {
 	}
 }
 
+// Devices returns all device pages sorted by their device indices.
+func (s *DeviceStack) Devices() []*DevicePage {
+	pages := make([]*DevicePage, 0, len(s.devices))
+	for _, page := range s.devices {
+		pages = append(pages, page)
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Index < pages[j].Index
+	})
+
+	return pages
+}
+
+// device returns the page of the device with the given index, or nil if no
+// such device is connected.
+func (s *DeviceStack) device(index int) *DevicePage {
+	return s.devices[fmt.Sprintf("%d", index)]
+}
+
+// deviceLabel returns the name of the device with the given index. Devices
+// that share their name with another one are told apart by their index.
+func (s *DeviceStack) deviceLabel(index int) string {
+	page := s.device(index)
+	if page == nil {
+		return fmt.Sprintf("Device %d (disconnected)", index)
+	}
+
+	name := page.deviceName()
+	for _, other := range s.devices {
+		if other != page && other.deviceName() == name {
+			return fmt.Sprintf("%s (%d)", name, index)
+		}
+	}
+
+	return name
+}
+
+// output returns the output of the device with the given index, or nil if no
+// such device is connected. It is safe to call from any goroutine.
+func (s *DeviceStack) output(index int) *deviceOutput {
+	s.outputMutex.RLock()
+	defer s.outputMutex.RUnlock()
+
+	return s.outputs[index]
+}
+
+// updateOutputs updates the outputs after a device has been added or removed.
+func (s *DeviceStack) updateOutputs() {
+	outputs := make(map[int]*deviceOutput, len(s.devices))
+	for _, page := range s.devices {
+		outputs[page.deviceIndex()] = page.output
+	}
+
+	s.outputMutex.Lock()
+	s.outputs = outputs
+	s.outputMutex.Unlock()
+}
+
+// halt halts every output, so that nothing is sent anymore before all devices
+// are stopped on shutdown. It is safe to call from any goroutine.
+func (s *DeviceStack) halt() {
+	s.outputMutex.RLock()
+	defer s.outputMutex.RUnlock()
+
+	for _, output := range s.outputs {
+		output.halt()
+	}
+}
+
+// Close detaches the stack from the shutdown once it's no longer used.
+func (s *DeviceStack) Close() {
+	s.unhook()
+}
+
+// StopAll stops every device: it halts all pattern players, zeroes every
+// motor and sends StopAllDevices to the server.
+func (s *DeviceStack) StopAll() {
+	for _, page := range s.devices {
+		page.Stop()
+	}
+
+	go func() {
+		if err := shutdown.StopAll(s.Manager, shutdown.Timeout); err != nil {
+			log.Println(err)
+		}
+	}()
+}
+
 func (s *DeviceStack) updateDevices() {
 	for n, device := range s.devices {
+		device.Stop()
 		s.Stack.Remove(device)
 		delete(s.devices, n)
 	}
+	s.updateOutputs()
 
 	for _, device := range s.Manager.Devices() {
 		ctrl := s.Manager.Controller(s.Manager, device.Index)
@@ -106,9 +211,11 @@ func (s *DeviceStack) addController(ctrl *device.Controller) {
 
 	page := NewDevicePage(ctrl)
 	page.SetName(name)
+	page.stack = s
 
 	s.devices[name] = page
 	s.AddTitled(page, name, string(ctrl.Device.Name))
+	s.updateOutputs()
 	s.TriggerOnDevice()
 }
 
@@ -121,7 +228,11 @@ func (s *DeviceStack) removeDevice(ix buttplug.DeviceIndex) {
 		return
 	}
 
+	// Nothing is left to stop the page's players and timers once it's gone,
+	// and they may drive other devices too.
+	device.Stop()
 	s.Stack.Remove(device)
 	delete(s.devices, name)
+	s.updateOutputs()
 	s.TriggerOnDevice()
 }
@@ -0,0 +1,451 @@
+package ui
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/diamondburned/adaptive"
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/intiface-gtk/internal/app"
+	"github.com/diamondburned/intiface-gtk/internal/library"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+)
+
+var (
+	libraryMutex sync.Mutex
+	libraryOpen  *library.Library
+)
+
+// patternLibrary returns the pattern library at the path in the settings. It
+// is opened again if the path has changed. It reads the disk, so it should be
+// called outside the UI thread.
+func patternLibrary() (*library.Library, error) {
+	libraryMutex.Lock()
+	defer libraryMutex.Unlock()
+
+	path := settings.Get().LibraryPath
+	if libraryOpen != nil && libraryOpen.Dir() == path {
+		return libraryOpen, nil
+	}
+
+	lib, err := library.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	libraryOpen = lib
+	return lib, nil
+}
+
+// libraryBrowser is a dialog that lists and searches the pattern library.
+// Each entry has a button that does the browser's action with it.
+type libraryBrowser struct {
+	*gtk.Dialog
+	lib *library.Library
+
+	// action is the label of the action button.
+	action string
+	// choose is called when the action button of an entry is clicked. The
+	// browser is closed if it returns true.
+	choose func(lib *library.Library, entry library.Entry) bool
+
+	page   *adaptive.LoadablePage
+	scroll *gtk.ScrolledWindow
+	list   *gtk.ListBox
+	rows   []*libraryRow
+	search *gtk.SearchEntry
+	empty  *gtk.Label
+}
+
+func newLibraryBrowser(action string, choose func(*library.Library, library.Entry) bool) *libraryBrowser {
+	b := &libraryBrowser{
+		action: action,
+		choose: choose,
+	}
+
+	b.Dialog = gtk.NewDialogWithFlags(
+		"Library ⁠— Intiface",
+		app.Require().ActiveWindow(),
+		gtk.DialogDestroyWithParent|gtk.DialogUseHeaderBar,
+	)
+
+	b.search = gtk.NewSearchEntry()
+	b.search.SetHExpand(true)
+	b.search.SetObjectProperty("placeholder-text", "Search names, authors, tags and notes...")
+	b.search.ConnectSearchChanged(b.update)
+
+	b.list = gtk.NewListBox()
+	b.list.AddCSSClass("pattern-browser-body")
+	b.list.SetSelectionMode(gtk.SelectionBrowse)
+	b.list.SetActivateOnSingleClick(true)
+	var lastRow *libraryRow
+	b.list.Connect("row-activated", func(row *gtk.ListBoxRow) {
+		if lastRow != nil {
+			lastRow.reveal.SetRevealChild(false)
+		}
+		lastRow = b.rows[row.Index()]
+		lastRow.reveal.SetRevealChild(true)
+	})
+
+	b.empty = gtk.NewLabel("")
+	b.empty.AddCSSClass("dim-label")
+	b.empty.SetWrap(true)
+	b.empty.SetVExpand(true)
+	b.list.SetPlaceholder(b.empty)
+
+	b.scroll = gtk.NewScrolledWindow()
+	b.scroll.SetHExpand(true)
+	b.scroll.SetVExpand(true)
+	b.scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	b.scroll.SetChild(b.list)
+
+	b.page = adaptive.NewLoadablePage()
+	b.page.SetVExpand(true)
+
+	content := gtk.NewBox(gtk.OrientationVertical, 0)
+	content.Append(b.search)
+	content.Append(b.page)
+
+	importBtn := gtk.NewButtonFromIconName("list-add-symbolic")
+	importBtn.SetTooltipText("Import pattern files")
+	importBtn.ConnectClicked(b.importFiles)
+
+	b.Dialog.SetApplication(app.Require())
+	b.Dialog.AddCSSClass("pattern-library-dialog")
+	b.Dialog.SetDefaultSize(350, 500)
+	b.Dialog.SetChild(content)
+	b.Dialog.HeaderBar().PackStart(importBtn)
+
+	b.load()
+	return b
+}
+
+// load opens the library, then lists its entries.
+func (b *libraryBrowser) load() {
+	b.page.SetLoading()
+
+	go func() {
+		lib, err := patternLibrary()
+		if err == nil {
+			err = lib.Scan()
+		}
+
+		glib.IdleAdd(func() {
+			if err != nil {
+				b.page.SetError(err)
+				return
+			}
+
+			b.lib = lib
+			b.page.SetChild(b.scroll)
+			b.update()
+		})
+	}()
+}
+
+// update lists the entries that match the search.
+func (b *libraryBrowser) update() {
+	if b.lib == nil {
+		return
+	}
+
+	for _, row := range b.rows {
+		b.list.Remove(row)
+	}
+	b.rows = nil
+
+	query := b.search.Text()
+	if query == "" {
+		b.empty.SetText("The library is empty. Add patterns by importing files or from Discover.")
+	} else {
+		b.empty.SetText("No patterns found.")
+	}
+
+	for _, entry := range b.lib.Search(query) {
+		row := newLibraryRow(b, entry)
+		b.rows = append(b.rows, row)
+		b.list.Append(row)
+	}
+}
+
+func (b *libraryBrowser) importFiles() {
+	chooser := gtk.NewFileChooserNative(
+		"Import Patterns",
+		&b.Dialog.Window,
+		gtk.FileChooserActionOpen,
+		"Import", "Cancel",
+	)
+	chooser.SetModal(true)
+	chooser.SetSelectMultiple(true)
+
+	chooser.ConnectResponse(func(respID int) {
+		if respID != int(gtk.ResponseAccept) || b.lib == nil {
+			return
+		}
+
+		var paths []string
+		files := chooser.Files()
+		for i := uint(0); i < files.NItems(); i++ {
+			file := &gio.File{Object: files.Item(i)}
+			if path := file.Path(); path != "" {
+				paths = append(paths, path)
+			}
+		}
+
+		b.page.SetLoading()
+
+		go func() {
+			var errs []string
+			for _, path := range paths {
+				if _, err := b.lib.Import(path); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+
+			glib.IdleAdd(func() {
+				b.page.SetChild(b.scroll)
+				b.update()
+
+				if len(errs) > 0 {
+					b.page.SetError(fmt.Errorf("cannot import: %s", strings.Join(errs, "; ")))
+				}
+			})
+		}()
+	})
+
+	chooser.Show()
+}
+
+// libraryRow shows a library entry. Its details can be edited once it's
+// revealed.
+type libraryRow struct {
+	*gtk.ListBoxRow
+	browser *libraryBrowser
+	entry   library.Entry
+
+	reveal  *gtk.Revealer
+	tags    *gtk.Entry
+	notes   *gtk.Entry
+	rating  *gtk.SpinButton
+	error   *gtk.Label
+	actions *gtk.Box
+	delete  *gtk.Button
+	// deleting is true once Remove has been clicked, and clicking it again
+	// deletes the pattern file.
+	deleting bool
+}
+
+func newLibraryRow(b *libraryBrowser, entry library.Entry) *libraryRow {
+	r := &libraryRow{
+		browser: b,
+		entry:   entry,
+	}
+
+	name := gtk.NewLabel(entry.Name)
+	name.SetXAlign(0)
+	name.SetEllipsize(pango.EllipsizeEnd)
+	name.SetTooltipText(entry.Name)
+
+	meta := fmtDuration(entry.Duration())
+	if entry.Author != "" {
+		meta = entry.Author + " · " + meta
+	}
+	if len(entry.Features) > 0 {
+		meta += " · " + stringifyFeatures(entry.Features)
+	}
+
+	metaLabel := gtk.NewLabel(meta)
+	metaLabel.SetXAlign(0)
+	metaLabel.SetEllipsize(pango.EllipsizeEnd)
+	metaLabel.SetAttributes(authorAttrs)
+
+	left := gtk.NewBox(gtk.OrientationVertical, 2)
+	left.SetHExpand(true)
+	left.Append(name)
+	left.Append(metaLabel)
+
+	if len(entry.Tags) > 0 {
+		tags := gtk.NewLabel(strings.Join(entry.Tags, ", "))
+		tags.SetXAlign(0)
+		tags.SetEllipsize(pango.EllipsizeEnd)
+		tags.SetAttributes(authorAttrs)
+		tags.AddCSSClass("pattern-library-tags")
+		left.Append(tags)
+	}
+
+	stars := gtk.NewLabel(fmtRating(entry.Rating))
+	stars.SetVAlign(gtk.AlignStart)
+	stars.SetTooltipText(fmt.Sprintf("Rated %d of %d", entry.Rating, library.MaxRating))
+	stars.SetAttributes(authorAttrs)
+
+	info := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	info.AddCSSClass("pattern-browse-item")
+	info.Append(left)
+	info.Append(stars)
+
+	r.reveal = gtk.NewRevealer()
+	r.reveal.SetRevealChild(false)
+	r.reveal.SetTransitionType(gtk.RevealerTransitionTypeSlideDown)
+	r.reveal.SetChild(r.newDetails())
+
+	main := gtk.NewBox(gtk.OrientationVertical, 0)
+	main.Append(info)
+	main.Append(r.reveal)
+
+	r.ListBoxRow = gtk.NewListBoxRow()
+	r.ListBoxRow.SetOverflow(gtk.OverflowHidden)
+	r.ListBoxRow.AddCSSClass("pattern-browse-row")
+	r.ListBoxRow.SetChild(main)
+
+	return r
+}
+
+func (r *libraryRow) newDetails() *gtk.Box {
+	grid := gtk.NewGrid()
+	grid.SetRowSpacing(4)
+	grid.SetColumnSpacing(8)
+
+	r.tags = gtk.NewEntry()
+	r.tags.SetHExpand(true)
+	r.tags.SetText(strings.Join(r.entry.Tags, ", "))
+	r.tags.SetPlaceholderText("Comma-separated")
+	attachOption(grid, 0, "Tags", r.tags)
+
+	r.notes = gtk.NewEntry()
+	r.notes.SetHExpand(true)
+	r.notes.SetText(r.entry.Notes)
+	attachOption(grid, 1, "Notes", r.notes)
+
+	r.rating = gtk.NewSpinButtonWithRange(0, library.MaxRating, 1)
+	r.rating.SetValue(float64(r.entry.Rating))
+	r.rating.SetTooltipText("0 is unrated")
+	attachOption(grid, 2, "Rating", r.rating)
+
+	if r.entry.Source != "" {
+		source := gtk.NewLabel("")
+		source.SetXAlign(0)
+		source.SetEllipsize(pango.EllipsizeMiddle)
+		source.SetMarkup(fmt.Sprintf(
+			`<a href="%s">%s</a>`,
+			html.EscapeString(r.entry.Source), html.EscapeString(r.entry.Source),
+		))
+		attachOption(grid, 3, "Source", source)
+	}
+
+	r.error = gtk.NewLabel("")
+	r.error.SetXAlign(0)
+	r.error.SetWrap(true)
+	r.error.SetWrapMode(pango.WrapWordChar)
+	r.error.SetVisible(false)
+
+	choose := gtk.NewButtonWithLabel(r.browser.action)
+	choose.AddCSSClass("suggested-action")
+	choose.ConnectClicked(r.choose)
+
+	save := gtk.NewButtonWithLabel("Save")
+	save.ConnectClicked(r.save)
+
+	r.delete = gtk.NewButtonWithLabel("Remove")
+	r.delete.AddCSSClass("destructive-action")
+	r.delete.ConnectClicked(r.remove)
+
+	r.actions = gtk.NewBox(gtk.OrientationHorizontal, 4)
+	r.actions.SetHAlign(gtk.AlignEnd)
+	r.actions.Append(r.delete)
+	r.actions.Append(save)
+	r.actions.Append(choose)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 6)
+	box.AddCSSClass("pattern-tryout-body")
+	box.Append(grid)
+	box.Append(r.error)
+	box.Append(r.actions)
+
+	return box
+}
+
+func (r *libraryRow) setError(err error) {
+	r.error.SetMarkup(fmt.Sprintf(
+		`<span color="red"><b>Error:</b></span> %s`,
+		html.EscapeString(err.Error()),
+	))
+	r.error.SetVisible(true)
+}
+
+func (r *libraryRow) choose() {
+	if r.browser.choose(r.browser.lib, r.entry) {
+		r.browser.Dialog.Destroy()
+	}
+}
+
+func (r *libraryRow) save() {
+	var tags []string
+	for _, tag := range strings.Split(r.tags.Text(), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	notes := strings.TrimSpace(r.notes.Text())
+	rating := r.rating.ValueAsInt()
+
+	r.write(func(lib *library.Library, file string) error {
+		return lib.Update(file, func(e *library.Entry) {
+			e.Tags = tags
+			e.Notes = notes
+			e.Rating = rating
+		})
+	})
+}
+
+// remove deletes the pattern file and its entry. The first click only asks
+// for a second one, since the file cannot be brought back.
+func (r *libraryRow) remove() {
+	if !r.deleting {
+		r.deleting = true
+		r.delete.SetLabel("Delete File")
+		r.error.SetText("This deletes the pattern file for good. Click again to confirm.")
+		r.error.SetVisible(true)
+		return
+	}
+
+	r.write((*library.Library).Remove)
+}
+
+// write calls f with the library and the row's file outside the UI thread,
+// since it writes the index. The entries are listed again once it's done.
+func (r *libraryRow) write(f func(lib *library.Library, file string) error) {
+	lib := r.browser.lib
+	file := r.entry.File
+
+	r.actions.SetSensitive(false)
+
+	go func() {
+		err := f(lib, file)
+
+		glib.IdleAdd(func() {
+			if err != nil {
+				log.Println("cannot update library entry:", err)
+				r.actions.SetSensitive(true)
+				r.setError(err)
+				return
+			}
+
+			r.browser.update()
+		})
+	}()
+}
+
+// fmtRating formats a rating as stars.
+func fmtRating(rating int) string {
+	if rating <= 0 {
+		return ""
+	}
+	return strings.Repeat("★", rating) + strings.Repeat("☆", library.MaxRating-rating)
+}
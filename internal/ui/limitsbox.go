@@ -0,0 +1,133 @@
+package ui
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/intiface-gtk/internal/limits"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+)
+
+// limitsSaveDelay is how long the limits box waits after the last change
+// before saving the limits.
+const limitsSaveDelay = 500 * time.Millisecond
+
+// limitsBox edits the output limits of a device.
+type limitsBox struct {
+	*gtk.Frame
+	page   *DevicePage
+	limits limits.Limits
+	scales []*gtk.Scale
+	save   glib.SourceHandle
+	reset  bool
+}
+
+func newLimitsBox(page *DevicePage) *limitsBox {
+	b := &limitsBox{
+		page:   page,
+		limits: settings.DeviceLimits(page.deviceName()),
+	}
+
+	grid := gtk.NewGrid()
+	grid.SetRowSpacing(2)
+	grid.SetColumnSpacing(6)
+
+	all := b.addRow(grid, 0, "All", &b.limits.Max)
+	all.SetTooltipText("Maximum output of every actuator")
+
+	for i, vrange := range page.ranges {
+		key := limits.Key(vrange.Kind, vrange.Motor)
+		name := fmt.Sprintf("%s %d", actuatorName(vrange.Kind), vrange.Motor)
+
+		v, ok := b.limits.Actuators[key]
+		if !ok {
+			v = 1
+		}
+
+		scale := b.addRow(grid, i+1, name, &v)
+		scale.ConnectValueChanged(func() {
+			if b.limits.Actuators == nil {
+				b.limits.Actuators = make(map[string]float64, len(page.ranges))
+			}
+			b.limits.Actuators[key] = scale.Value() / 100
+		})
+	}
+
+	reset := gtk.NewButtonWithLabel("Reset")
+	reset.SetHAlign(gtk.AlignEnd)
+	reset.SetTooltipText("Remove all limits")
+	reset.ConnectClicked(b.resetLimits)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 4)
+	box.Append(grid)
+	box.Append(reset)
+
+	b.Frame = gtk.NewFrame("Limits")
+	b.Frame.AddCSSClass("more-limits")
+	b.Frame.SetLabelAlign(0)
+	b.Frame.SetChild(box)
+
+	page.updateCaps()
+	return b
+}
+
+// addRow adds a percentage scale that edits v, which is within [0, 1].
+func (b *limitsBox) addRow(grid *gtk.Grid, row int, name string, v *float64) *gtk.Scale {
+	label := gtk.NewLabel(name)
+	label.SetXAlign(0)
+
+	scale := gtk.NewScaleWithRange(gtk.OrientationHorizontal, 0, 100, 1)
+	scale.SetHExpand(true)
+	scale.SetDrawValue(true)
+	scale.SetValuePos(gtk.PosRight)
+	scale.SetFormatValueFunc(func(_ *gtk.Scale, value float64) string {
+		return fmt.Sprintf("%.0f%%", value)
+	})
+	scale.SetValue(*v * 100)
+	scale.ConnectValueChanged(func() {
+		*v = scale.Value() / 100
+		b.changed()
+	})
+
+	grid.Attach(label, 0, row, 1, 1)
+	grid.Attach(scale, 1, row, 1, 1)
+
+	b.scales = append(b.scales, scale)
+	return scale
+}
+
+func (b *limitsBox) resetLimits() {
+	b.reset = true
+	for _, scale := range b.scales {
+		scale.SetValue(100)
+	}
+	b.reset = false
+
+	b.limits = limits.None()
+	b.changed()
+}
+
+// changed saves the limits after a short delay and shows them on the page.
+func (b *limitsBox) changed() {
+	if b.reset {
+		return
+	}
+
+	if b.save != 0 {
+		glib.SourceRemove(b.save)
+	}
+
+	b.save = glib.TimeoutAdd(uint(limitsSaveDelay.Milliseconds()), func() bool {
+		b.save = 0
+
+		if err := settings.SetDeviceLimits(b.page.deviceName(), b.limits); err != nil {
+			log.Println("cannot save device limits:", err)
+		}
+
+		b.page.updateCaps()
+		return false
+	})
+}
@@ -0,0 +1,265 @@
+package ui
+
+import (
+	"time"
+
+	"github.com/diamondburned/go-buttplug"
+	"github.com/diamondburned/go-buttplug/device"
+	"github.com/diamondburned/gotk4/pkg/gdk/v4"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/intiface-gtk/internal/sparklines"
+)
+
+const (
+	defaultStrokeDuration = 500 * time.Millisecond
+	minStrokeDuration     = 100 * time.Millisecond
+	maxStrokeDuration     = 5 * time.Second
+)
+
+// linearMotion estimates the position of a linear actuator while it moves
+// from one position to another.
+type linearMotion struct {
+	from     float64
+	to       float64
+	start    time.Time
+	duration time.Duration
+}
+
+// at returns the estimated position at the given time.
+func (m linearMotion) at(t time.Time) float64 {
+	if m.duration <= 0 {
+		return m.to
+	}
+
+	progress := float64(t.Sub(m.start)) / float64(m.duration)
+	switch {
+	case progress <= 0:
+		return m.from
+	case progress >= 1:
+		return m.to
+	default:
+		return m.from + (m.to-m.from)*progress
+	}
+}
+
+// done returns true if the motion has finished at the given time.
+func (m linearMotion) done(t time.Time) bool {
+	return t.Sub(m.start) >= m.duration
+}
+
+// linearControl controls a single linear actuator. The position is in
+// percents.
+type linearControl struct {
+	*gtk.Box
+	page  *DevicePage
+	motor int
+	line  *sparklines.Line
+
+	position *gtk.Scale
+	visual   *gtk.LevelBar
+	duration *gtk.SpinButton
+	lower    *gtk.SpinButton
+	upper    *gtk.SpinButton
+	auto     *gtk.ToggleButton
+
+	motion linearMotion
+	stroke glib.SourceHandle
+	up     bool
+}
+
+func (p *DevicePage) loadLinears(box *gtk.Box) {
+	motorSteps := p.actuatorSteps(buttplug.LinearCmdMessage)
+	if len(motorSteps) == 0 {
+		return
+	}
+
+	child := gtk.NewBox(gtk.OrientationHorizontal, 0)
+
+	for motor, steps := range motorSteps {
+		control := newLinearControl(p, motor, steps)
+		p.ranges = append(p.ranges, valueRange{
+			SetValue: control.position.SetValue,
+			Value:    control.position.Value,
+			Changed:  control.changed,
+			Kind:     buttplug.LinearCmdMessage,
+			Motor:    motor,
+			SetCap:   func(cap float64) { setScaleCap(control.position, cap) },
+		})
+		p.onStop = append(p.onStop, control.stopStroking)
+
+		child.Append(control)
+	}
+
+	frame := gtk.NewFrame("Linear")
+	frame.AddCSSClass("linears")
+	frame.SetLabelAlign(0)
+	frame.SetChild(child)
+
+	box.Append(frame)
+}
+
+func newLinearControl(page *DevicePage, motor, steps int) *linearControl {
+	c := &linearControl{
+		page:  page,
+		motor: motor,
+	}
+
+	color := sparklines.HashColor("l", 2<<((motor+1)*8))
+
+	c.line = page.sparklines.AddLine()
+	c.line.Smooth = true
+	c.line.SetWidth(2)
+	c.line.SetColor(color)
+
+	c.position = newMotorScale(steps)
+	c.position.ConnectValueChanged(c.changed)
+
+	c.visual = gtk.NewLevelBarForInterval(0, 100)
+	c.visual.SetOrientation(gtk.OrientationVertical)
+	c.visual.SetInverted(true)
+	c.visual.SetVExpand(true)
+	c.visual.AddCSSClass("linear-position")
+	c.visual.SetTooltipText("Estimated position")
+	c.visual.AddTickCallback(func(gtk.Widgetter, gdk.FrameClocker) bool {
+		c.updateVisual()
+		return true
+	})
+
+	c.duration = gtk.NewSpinButtonWithRange(
+		float64(minStrokeDuration.Milliseconds()),
+		float64(maxStrokeDuration.Milliseconds()),
+		50,
+	)
+	c.duration.SetValue(float64(defaultStrokeDuration.Milliseconds()))
+	c.duration.SetTooltipText("Stroke duration in milliseconds")
+
+	c.lower = gtk.NewSpinButtonWithRange(0, 100, 5)
+	c.lower.SetValue(0)
+	c.lower.SetTooltipText("Lower stroke limit in percents")
+
+	c.upper = gtk.NewSpinButtonWithRange(0, 100, 5)
+	c.upper.SetValue(100)
+	c.upper.SetTooltipText("Upper stroke limit in percents")
+
+	c.auto = gtk.NewToggleButtonWithLabel("Auto")
+	c.auto.SetTooltipText("Stroke between the limits automatically")
+	c.auto.ConnectToggled(func() {
+		if c.auto.Active() {
+			c.startStroking()
+		} else {
+			c.stopStroking()
+		}
+	})
+
+	options := gtk.NewGrid()
+	options.AddCSSClass("linear-options")
+	options.SetRowSpacing(2)
+	options.SetColumnSpacing(4)
+	options.SetVAlign(gtk.AlignCenter)
+	attachOption(options, 0, "Stroke", c.duration)
+	attachOption(options, 1, "Upper", c.upper)
+	attachOption(options, 2, "Lower", c.lower)
+	options.Attach(c.auto, 0, 3, 2, 1)
+
+	controls := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	controls.SetVExpand(true)
+	controls.Append(c.position)
+	controls.Append(c.visual)
+	controls.Append(options)
+
+	c.Box = gtk.NewBox(gtk.OrientationVertical, 2)
+	c.Box.Append(controls)
+	c.Box.Append(newMotorLabel(buttplug.LinearCmdMessage, motor, color))
+
+	return c
+}
+
+func attachOption(grid *gtk.Grid, row int, name string, widget gtk.Widgetter) {
+	label := gtk.NewLabel(name)
+	label.SetXAlign(0)
+
+	grid.Attach(label, 0, row, 1, 1)
+	grid.Attach(widget, 1, row, 1, 1)
+}
+
+func (c *linearControl) strokeDuration() time.Duration {
+	return time.Duration(c.duration.ValueAsInt()) * time.Millisecond
+}
+
+// changed sends the position slider's value to the device.
+func (c *linearControl) changed() {
+	if c.page.paused || c.page.observing {
+		return
+	}
+	c.moveTo(c.position.Value(), c.strokeDuration())
+}
+
+func (c *linearControl) moveTo(position float64, duration time.Duration) {
+	now := time.Now()
+	c.motion = linearMotion{
+		from:     c.motion.at(now),
+		to:       position,
+		start:    now,
+		duration: duration,
+	}
+
+	c.page.output.Linear(map[int]device.Vector{
+		c.motor: {Duration: duration, Position: position / 100},
+	})
+}
+
+func (c *linearControl) updateVisual() {
+	now := time.Now()
+	if c.motion.done(now) && c.visual.Value() == c.motion.to {
+		return
+	}
+
+	position := c.motion.at(now)
+	c.visual.SetValue(position)
+	c.line.AddPoint(position)
+}
+
+func (c *linearControl) startStroking() {
+	if c.stroke != 0 {
+		return
+	}
+
+	c.position.SetSensitive(false)
+	c.strokeOnce()
+}
+
+func (c *linearControl) strokeOnce() {
+	lower, upper := c.lower.Value(), c.upper.Value()
+	if lower > upper {
+		lower, upper = upper, lower
+	}
+
+	target := lower
+	if c.up = !c.up; c.up {
+		target = upper
+	}
+
+	duration := c.strokeDuration()
+	if !c.page.paused {
+		c.moveTo(target, duration)
+	}
+
+	c.stroke = glib.TimeoutAdd(uint(duration.Milliseconds()), func() bool {
+		c.stroke = 0
+		c.strokeOnce()
+		return false
+	})
+}
+
+func (c *linearControl) stopStroking() {
+	if c.stroke != 0 {
+		glib.SourceRemove(c.stroke)
+		c.stroke = 0
+	}
+
+	c.position.SetSensitive(true)
+	if c.auto.Active() {
+		c.auto.SetActive(false)
+	}
+}
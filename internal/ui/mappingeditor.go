@@ -0,0 +1,376 @@
+package ui
+
+import (
+	"fmt"
+	"html"
+	"log"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/intiface-gtk/internal/app"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+)
+
+// mappingEditor is a window that edits which devices a pattern player plays
+// on and which motors the channels of the pattern drive. Changes take effect
+// right away. The mapping is remembered for the player's device, but the
+// intensity offsets aren't.
+type mappingEditor struct {
+	*gtk.Window
+	player   *patternPlayer
+	layout   string
+	channels int
+	mapping  playback.Mapping
+
+	list *gtk.Box
+}
+
+func newMappingEditor(player *patternPlayer) *mappingEditor {
+	e := &mappingEditor{
+		player:   player,
+		layout:   playback.Layout(player.Pattern()),
+		channels: playback.Channels(player.Pattern()),
+		mapping:  player.Mapping(),
+	}
+
+	e.list = gtk.NewBox(gtk.OrientationVertical, 8)
+	e.list.AddCSSClass("mapping-editor")
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetPropagateNaturalHeight(true)
+	scroll.SetChild(e.list)
+
+	reset := gtk.NewButtonWithLabel("Reset")
+	reset.SetTooltipText("Go back to the default mapping")
+	reset.ConnectClicked(e.reset)
+
+	done := gtk.NewButtonWithLabel("Done")
+	done.AddCSSClass("suggested-action")
+	done.ConnectClicked(func() { e.Window.Destroy() })
+
+	header := gtk.NewHeaderBar()
+	header.SetShowTitleButtons(false)
+	header.PackStart(reset)
+	header.PackEnd(done)
+
+	e.Window = gtk.NewWindow()
+	e.Window.SetTitle("Devices ⁠— " + player.page.deviceName())
+	e.Window.SetApplication(app.Require())
+	e.Window.SetTransientFor(app.Require().ActiveWindow())
+	e.Window.SetDefaultSize(400, -1)
+	e.Window.SetTitlebar(header)
+	e.Window.SetChild(scroll)
+
+	e.update()
+	return e
+}
+
+// update rebuilds the lists of devices and channels from the mapping.
+func (e *mappingEditor) update() {
+	for child := e.list.FirstChild(); child != nil; child = e.list.FirstChild() {
+		e.list.Remove(child)
+	}
+
+	e.list.Append(e.newDevices())
+	for i := 0; i < e.channels; i++ {
+		e.list.Append(e.newChannel(i))
+	}
+}
+
+// newDevices lists the connected devices. The pattern is played on the
+// checked ones, which all follow the same player.
+func (e *mappingEditor) newDevices() *gtk.Box {
+	title := gtk.NewLabel("")
+	title.SetXAlign(0)
+	title.SetMarkup("<b>Devices</b>")
+
+	grid := gtk.NewGrid()
+	grid.SetRowSpacing(4)
+	grid.SetColumnSpacing(8)
+
+	stack := e.player.page.stack
+	own := e.player.page.deviceIndex()
+	driven := e.mapping.Devices()
+
+	for row, page := range stack.Devices() {
+		page := page
+		index := page.deviceIndex()
+
+		check := gtk.NewCheckButtonWithLabel(stack.deviceLabel(index))
+		check.SetHExpand(true)
+		check.SetActive(index == own || containsInt(driven, index))
+		check.SetSensitive(index != own)
+		check.ConnectToggled(func() {
+			if check.Active() {
+				e.addDevice(page)
+			} else {
+				e.removeDevice(index)
+			}
+		})
+
+		offset := gtk.NewSpinButtonWithRange(-100, 100, 5)
+		offset.SetValue(e.player.DeviceOffset(index) * 100)
+		offset.SetTooltipText("Intensity offset in percents")
+		offset.ConnectValueChanged(func() {
+			e.player.SetDeviceOffset(index, offset.Value()/100)
+		})
+
+		grid.Attach(check, 0, row, 1, 1)
+		grid.Attach(offset, 1, row, 1, 1)
+	}
+
+	box := gtk.NewBox(gtk.OrientationVertical, 4)
+	box.AddCSSClass("mapping-devices")
+	box.Append(title)
+	box.Append(grid)
+
+	return box
+}
+
+// addDevice plays the pattern on the device of the given page as well, using
+// the mapping that's remembered for that device.
+func (e *mappingEditor) addDevice(page *DevicePage) {
+	m, ok := settings.ChannelMapping(page.deviceName(), e.layout)
+	if ok {
+		m = m.OnDevice(page.deviceIndex())
+	} else {
+		m = defaultMapping(page, e.player.Pattern())
+	}
+
+	e.mapping = e.mapping.Merge(m)
+	e.changed(true)
+}
+
+func (e *mappingEditor) removeDevice(index int) {
+	e.mapping = e.mapping.Without(index)
+	e.changed(true)
+}
+
+func (e *mappingEditor) newChannel(channel int) *gtk.Box {
+	name := fmt.Sprintf("Channel %d", channel+1)
+	if features := e.player.Pattern().Features; channel < len(features) {
+		name += " (" + features[channel].String() + ")"
+	}
+
+	title := gtk.NewLabel("")
+	title.SetXAlign(0)
+	title.SetMarkup("<b>" + html.EscapeString(name) + "</b>")
+
+	box := gtk.NewBox(gtk.OrientationVertical, 4)
+	box.AddCSSClass("mapping-channel")
+	box.Append(title)
+
+	if len(e.mapping[channel]) == 0 {
+		ignored := gtk.NewLabel("Ignored")
+		ignored.SetXAlign(0)
+		ignored.AddCSSClass("dim-label")
+		box.Append(ignored)
+	}
+
+	for i := range e.mapping[channel] {
+		box.Append(e.newTarget(channel, i))
+	}
+
+	add := gtk.NewButtonWithLabel("Add motor")
+	add.SetTooltipText("Also drive another motor with this channel")
+	add.ConnectClicked(func() {
+		output := playback.Output{Device: e.player.page.deviceIndex()}
+		if outputs := e.outputs(output.Device); len(outputs) > 0 {
+			output = outputs[0]
+		}
+		e.mapping[channel] = append(e.mapping[channel], playback.Target{Output: output})
+		e.changed(true)
+	})
+
+	swap := gtk.NewButtonWithLabel("Swap with next")
+	swap.SetTooltipText("Swap the motors of this channel and the next one")
+	swap.SetSensitive(channel+1 < e.channels)
+	swap.ConnectClicked(func() {
+		e.mapping.Swap(channel, channel+1)
+		e.changed(true)
+	})
+
+	buttons := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	buttons.SetHAlign(gtk.AlignEnd)
+	buttons.Append(add)
+	buttons.Append(swap)
+	box.Append(buttons)
+
+	return box
+}
+
+func (e *mappingEditor) newTarget(channel, i int) *gtk.Box {
+	target := &e.mapping[channel][i]
+
+	// List the connected devices, and also the target's device if it's not
+	// connected, so that it's not lost just by opening the editor.
+	stack := e.player.page.stack
+
+	var indices []int
+	var labels []string
+	selected := -1
+
+	for _, page := range stack.Devices() {
+		index := page.deviceIndex()
+		if index == target.Device {
+			selected = len(indices)
+		}
+		indices = append(indices, index)
+		labels = append(labels, stack.deviceLabel(index))
+	}
+
+	if selected == -1 {
+		selected = len(indices)
+		indices = append(indices, target.Device)
+		labels = append(labels, stack.deviceLabel(target.Device))
+	}
+
+	device := gtk.NewDropDownFromStrings(labels)
+	device.SetHExpand(true)
+	device.SetSelected(uint(selected))
+	device.Connect("notify::selected", func() {
+		selected := int(device.Selected())
+		if selected < 0 || selected >= len(indices) || indices[selected] == target.Device {
+			return
+		}
+
+		// Keep the same actuator if the other device has it.
+		outputs := e.outputs(indices[selected])
+		output := playback.Output{
+			Device:   indices[selected],
+			Actuator: target.Actuator,
+			Motor:    target.Motor,
+		}
+		if !containsOutput(outputs, output) && len(outputs) > 0 {
+			output = outputs[0]
+		}
+
+		target.Output = output
+		e.changed(true)
+	})
+
+	outputs := e.outputs(target.Device)
+	if !containsOutput(outputs, target.Output) {
+		outputs = append(outputs, target.Output)
+	}
+
+	outputLabels := make([]string, len(outputs))
+	for i, output := range outputs {
+		outputLabels[i] = outputName(output)
+		if output == target.Output {
+			selected = i
+		}
+	}
+
+	actuator := gtk.NewDropDownFromStrings(outputLabels)
+	actuator.SetSelected(uint(selected))
+	actuator.Connect("notify::selected", func() {
+		selected := int(actuator.Selected())
+		if selected < 0 || selected >= len(outputs) {
+			return
+		}
+
+		target.Output = outputs[selected]
+		e.changed(false)
+	})
+
+	invert := gtk.NewCheckButtonWithLabel("Invert")
+	invert.SetActive(target.Invert)
+	invert.SetTooltipText("Send the opposite of the channel's value")
+	invert.ConnectToggled(func() {
+		target.Invert = invert.Active()
+		e.changed(false)
+	})
+
+	remove := gtk.NewButtonFromIconName("list-remove-symbolic")
+	remove.AddCSSClass("flat")
+	remove.SetTooltipText("Stop driving this motor")
+	remove.ConnectClicked(func() {
+		targets := e.mapping[channel]
+		e.mapping[channel] = append(targets[:i:i], targets[i+1:]...)
+		e.changed(true)
+	})
+
+	row := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	row.Append(device)
+	row.Append(actuator)
+	row.Append(invert)
+	row.Append(remove)
+
+	return row
+}
+
+// outputs returns all actuators of the device with the given index, or nil if
+// it's not connected.
+func (e *mappingEditor) outputs(index int) []playback.Output {
+	page := e.player.page.stack.device(index)
+	if page == nil {
+		return nil
+	}
+
+	var outputs []playback.Output
+	motors := page.motors()
+	for _, actuator := range playback.Actuators {
+		for _, motor := range motors[actuator] {
+			outputs = append(outputs, playback.Output{
+				Device:   index,
+				Actuator: actuator,
+				Motor:    motor,
+			})
+		}
+	}
+	return outputs
+}
+
+// outputName returns the name of an output's actuator, such as "Motor 0".
+func outputName(output playback.Output) string {
+	return fmt.Sprintf("%s %d", actuatorName(actuatorMessage(output.Actuator)), output.Motor)
+}
+
+// changed applies and saves the mapping. Only the targets on the player's own
+// device are saved, since the others might be other devices next time. If
+// rebuild is true, then the list is also rebuilt.
+func (e *mappingEditor) changed(rebuild bool) {
+	e.player.SetMapping(e.mapping)
+
+	page := e.player.page
+	m := e.mapping.Only(page.deviceIndex())
+	if err := settings.SetChannelMapping(page.deviceName(), e.layout, m); err != nil {
+		log.Println("cannot save channel mapping:", err)
+	}
+
+	if rebuild {
+		e.update()
+	}
+}
+
+func (e *mappingEditor) reset() {
+	e.mapping = defaultMapping(e.player.page, e.player.Pattern())
+	e.player.SetMapping(e.mapping)
+
+	name := e.player.page.deviceName()
+	if err := settings.SetChannelMapping(name, e.layout, nil); err != nil {
+		log.Println("cannot reset channel mapping:", err)
+	}
+
+	e.update()
+}
+
+func containsInt(ints []int, i int) bool {
+	for _, v := range ints {
+		if v == i {
+			return true
+		}
+	}
+	return false
+}
+
+func containsOutput(outputs []playback.Output, output playback.Output) bool {
+	for _, o := range outputs {
+		if o == output {
+			return true
+		}
+	}
+	return false
+}
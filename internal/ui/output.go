@@ -0,0 +1,174 @@
+package ui
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/diamondburned/go-buttplug"
+	"github.com/diamondburned/go-buttplug/device"
+	"github.com/diamondburned/intiface-gtk/internal/scheduler"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+	"github.com/diamondburned/intiface-gtk/internal/shutdown"
+)
+
+// commandInterval caches the command interval in the settings, since getting
+// the settings copies all of them. It is in nanoseconds.
+var commandInterval int64 // atomic
+
+func init() {
+	setCommandInterval(settings.Get())
+	settings.Observe(setCommandInterval)
+}
+
+func setCommandInterval(s settings.Settings) {
+	atomic.StoreInt64(&commandInterval, int64(s.CommandInterval()))
+}
+
+// currentCommandInterval returns the command interval in the settings. It is
+// cheap enough to be called for every command.
+func currentCommandInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&commandInterval))
+}
+
+// deviceOutput is the last step before commands are sent to a device. All
+// actuator commands must go through it, so that the device's limits are
+// applied no matter where the values come from. The capped values are then
+// merged and rate-limited by a scheduler.
+type deviceOutput struct {
+	ctrl   *device.Controller
+	sched  *scheduler.Scheduler
+	paused uint32 // atomic
+	halted uint32 // atomic
+}
+
+func newDeviceOutput(ctrl *device.Controller) *deviceOutput {
+	o := &deviceOutput{ctrl: ctrl}
+	o.sched = scheduler.New(currentCommandInterval(), o.send)
+	return o
+}
+
+// send sends a batch. The controller is asynchronous, so the commands are
+// only queued and errors aren't reported.
+func (o *deviceOutput) send(batch scheduler.Batch) error {
+	defer shutdown.Recover()
+
+	var firstErr error
+	setErr := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if len(batch.Vibrate) > 0 {
+		setErr(o.ctrl.Vibrate(batch.Vibrate))
+	}
+	if len(batch.Rotate) > 0 {
+		setErr(o.ctrl.Rotate(batch.Rotate))
+	}
+	if len(batch.Linear) > 0 {
+		setErr(o.ctrl.Linear(batch.Linear))
+	}
+
+	return firstErr
+}
+
+// SetInterval sets the shortest time between two commands.
+func (o *deviceOutput) SetInterval(interval time.Duration) {
+	o.sched.SetInterval(interval)
+}
+
+// SetPaused sets whether the device page is paused. Sources that don't run on
+// the UI thread check this to send zeroes instead.
+func (o *deviceOutput) SetPaused(paused bool) {
+	var v uint32
+	if paused {
+		v = 1
+	}
+	atomic.StoreUint32(&o.paused, v)
+}
+
+// Paused returns true if the device page is paused.
+func (o *deviceOutput) Paused() bool {
+	return atomic.LoadUint32(&o.paused) == 1
+}
+
+// halt discards the pending values and drops everything set afterwards. It is
+// called right before all devices are stopped for good.
+func (o *deviceOutput) halt() {
+	atomic.StoreUint32(&o.halted, 1)
+	o.sched.Stop()
+}
+
+func (o *deviceOutput) isHalted() bool {
+	return atomic.LoadUint32(&o.halted) == 1
+}
+
+// Resend sends the last values again, which keeps the device awake.
+func (o *deviceOutput) Resend() {
+	if o.isHalted() {
+		return
+	}
+	o.sched.Resend()
+}
+
+// Stats returns the number of commands that were sent and dropped.
+func (o *deviceOutput) Stats() scheduler.Stats {
+	return o.sched.Stats()
+}
+
+// Sent returns the values that were last sent to the device, after they were
+// capped and merged.
+func (o *deviceOutput) Sent() scheduler.Batch {
+	return o.sched.Last()
+}
+
+// Vibrate schedules the capped motor speeds.
+func (o *deviceOutput) Vibrate(speeds map[int]float64) {
+	if o.isHalted() {
+		return
+	}
+
+	limits := settings.DeviceLimits(string(o.ctrl.Name))
+
+	capped := make(map[int]float64, len(speeds))
+	for motor, speed := range speeds {
+		capped[motor] = limits.Apply(buttplug.VibrateCmdMessage, motor, speed)
+	}
+
+	o.sched.Vibrate(capped)
+}
+
+// Rotate schedules the capped rotation speeds.
+func (o *deviceOutput) Rotate(rotations map[int]device.Rotation) {
+	if o.isHalted() {
+		return
+	}
+
+	limits := settings.DeviceLimits(string(o.ctrl.Name))
+
+	capped := make(map[int]device.Rotation, len(rotations))
+	for motor, rotation := range rotations {
+		rotation.Speed = limits.Apply(buttplug.RotateCmdMessage, motor, rotation.Speed)
+		capped[motor] = rotation
+	}
+
+	o.sched.Rotate(capped)
+}
+
+// Linear schedules the linear vectors with their positions capped, which
+// limits the stroke depth.
+func (o *deviceOutput) Linear(vectors map[int]device.Vector) {
+	if o.isHalted() {
+		return
+	}
+
+	limits := settings.DeviceLimits(string(o.ctrl.Name))
+
+	capped := make(map[int]device.Vector, len(vectors))
+	for motor, vector := range vectors {
+		vector.Position = limits.Apply(buttplug.LinearCmdMessage, motor, vector.Position)
+		capped[motor] = vector
+	}
+
+	o.sched.Linear(capped)
+}
@@ -3,17 +3,24 @@ package ui
 import (
 	"fmt"
 	"html"
-	"os"
+	"log"
 	"path/filepath"
 	"time"
 
+	"github.com/diamondburned/go-buttplug"
+	"github.com/diamondburned/go-buttplug/device"
 	"github.com/diamondburned/go-lovense/pattern"
+	"github.com/diamondburned/gotk4/pkg/gdk/v4"
 	"github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/diamondburned/gotk4/pkg/pango"
 	"github.com/diamondburned/intiface-gtk/internal/app"
-	"github.com/diamondburned/intiface-gtk/internal/gticker"
-	"github.com/pkg/errors"
+	"github.com/diamondburned/intiface-gtk/internal/library"
+	"github.com/diamondburned/intiface-gtk/internal/patternedit"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+	"github.com/diamondburned/intiface-gtk/internal/playlist"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+	"github.com/diamondburned/intiface-gtk/internal/shutdown"
 )
 
 type patternBox struct {
@@ -23,9 +30,12 @@ type patternBox struct {
 	stack   *gtk.Stack
 	loadBox *gtk.Box
 	loadErr *gtk.Label
+	resume  *gtk.Button
 
 	currBox *gtk.Box
 	current *patternState
+	// queue is the playlist that's playing, if any.
+	queue *patternQueue
 }
 
 func newPatternBox(page *DevicePage) *patternBox {
@@ -50,13 +60,34 @@ func newPatternBox(page *DevicePage) *patternBox {
 	browseBtn.SetHExpand(true)
 	browseBtn.ConnectClicked(b.browsePatterns)
 
+	libraryBtn := gtk.NewButtonWithLabel("Library")
+	libraryBtn.SetHExpand(true)
+	libraryBtn.ConnectClicked(b.browseLibrary)
+
+	playlistsBtn := gtk.NewButtonWithLabel("Playlists")
+	playlistsBtn.SetHExpand(true)
+	playlistsBtn.ConnectClicked(b.editPlaylists)
+
+	createBtn := gtk.NewMenuButton()
+	createBtn.SetLabel("Create")
+	createBtn.SetHExpand(true)
+	createBtn.SetPopover(b.newCreateMenu())
+
 	actionBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
 	actionBox.Append(loadBtn)
 	actionBox.Append(browseBtn)
+	actionBox.Append(libraryBtn)
+	actionBox.Append(playlistsBtn)
+	actionBox.Append(createBtn)
+
+	b.resume = gtk.NewButton()
+	b.resume.AddCSSClass("pattern-resume")
+	b.resume.SetVisible(false)
 
 	b.loadBox = gtk.NewBox(gtk.OrientationVertical, 0)
 	b.loadBox.AddCSSClass("pattern-loadfile")
 	b.loadBox.Append(b.loadErr)
+	b.loadBox.Append(b.resume)
 	b.loadBox.Append(actionBox)
 
 	b.currBox = gtk.NewBox(gtk.OrientationVertical, 0)
@@ -69,14 +100,92 @@ func newPatternBox(page *DevicePage) *patternBox {
 
 	b.Frame.SetChild(b.stack)
 
+	page.onStop = append(page.onStop, b.halt)
+	b.loadSession()
+
 	return b
 }
 
+// newCreateMenu lists the ways to make a new pattern.
+func (b *patternBox) newCreateMenu() *gtk.Popover {
+	popover := gtk.NewPopover()
+
+	draw := gtk.NewButtonWithLabel("Pattern Editor")
+	draw.AddCSSClass("flat")
+	draw.ConnectClicked(func() {
+		popover.Popdown()
+		newPatternEditor(b.page).Show()
+	})
+
+	steps := gtk.NewButtonWithLabel("Step Sequencer")
+	steps.AddCSSClass("flat")
+	steps.ConnectClicked(func() {
+		popover.Popdown()
+		newStepSequencer(b.page).Show()
+	})
+
+	list := gtk.NewBox(gtk.OrientationVertical, 2)
+	list.Append(draw)
+	list.Append(steps)
+	popover.SetChild(list)
+
+	return popover
+}
+
+// loadSession offers to resume the playlist that was queued on the device the
+// last time.
+func (b *patternBox) loadSession() {
+	device := b.page.deviceName()
+
+	go func() {
+		store, err := playlistStore()
+		if err != nil {
+			log.Println("cannot open playlists:", err)
+			return
+		}
+
+		session, ok := store.Session(device)
+		if !ok || len(session.Playlist.Entries) == 0 {
+			return
+		}
+
+		glib.IdleAdd(func() {
+			b.resume.SetLabel(fmt.Sprintf("Resume %q", session.Playlist.Name))
+			b.resume.SetTooltipText("Queue the playlist where it was left off")
+			b.resume.SetVisible(true)
+			b.resume.ConnectClicked(func() {
+				b.playQueue(store, session.Playlist, session.Index, false)
+			})
+		})
+	}()
+}
+
+// playQueue queues the playlist on the box's device, starting at the entry
+// at index. See playlist.NewQueue.
+func (b *patternBox) playQueue(store *playlist.Store, p playlist.Playlist, index int, play bool) {
+	b.stop()
+	b.queue = newPatternQueue(b, store, p, index)
+	b.queue.open(play)
+}
+
+func (b *patternBox) editPlaylists() {
+	editor := newPlaylistEditor(b)
+	editor.Show()
+}
+
 func (b *patternBox) browsePatterns() {
 	browser := NewPatternBrowser(b.page)
 	browser.Show()
 }
 
+func (b *patternBox) browseLibrary() {
+	browser := newLibraryBrowser("Play", func(lib *library.Library, entry library.Entry) bool {
+		b.openEntry(lib, entry)
+		return true
+	})
+	browser.Show()
+}
+
 func (b *patternBox) loadPattern() {
 	b.stop()
 
@@ -106,6 +215,17 @@ func (b *patternBox) loadPattern() {
 }
 
 func (b *patternBox) open(path string) {
+	b.openAs(path, filepath.Base(path))
+}
+
+// openEntry opens a pattern from the library.
+func (b *patternBox) openEntry(lib *library.Library, entry library.Entry) {
+	b.stop()
+	b.openAs(lib.Path(entry), entry.Name)
+}
+
+// openAs opens the pattern file at path and shows it with the given name.
+func (b *patternBox) openAs(path, name string) {
 	b.SetSensitive(false)
 
 	go func() {
@@ -116,20 +236,11 @@ func (b *patternBox) open(path string) {
 			})
 		}
 
-		f, err := os.Open(path)
+		p, err := playback.Open(path)
 		if err != nil {
 			onErr(err)
 			return
 		}
-		defer f.Close()
-
-		p, err := pattern.Parse(f)
-		if err != nil {
-			onErr(errors.Wrap(err, "pattern error"))
-			return
-		}
-
-		name := filepath.Base(path)
 
 		glib.IdleAdd(func() {
 			b.setPattern(p, name)
@@ -156,10 +267,25 @@ func (b *patternBox) setLoadErr(err string) {
 	b.loadErr.SetVisible(true)
 }
 
+// stop stops and unloads the pattern. The queued playlist is forgotten.
 func (b *patternBox) stop() {
 	b.loadErr.SetVisible(false)
 	b.loadErr.SetText("")
 
+	if b.queue != nil {
+		b.queue.halt()
+		if err := b.queue.store.ClearSession(b.page.deviceName()); err != nil {
+			log.Println("cannot clear playlist session:", err)
+		}
+		b.queue = nil
+		b.resume.SetVisible(false)
+	}
+
+	b.unload()
+}
+
+// unload stops and removes the current pattern.
+func (b *patternBox) unload() {
 	if b.current == nil {
 		return
 	}
@@ -174,51 +300,78 @@ func (b *patternBox) stop() {
 	b.current = nil
 }
 
+// halt is called once the device has been stopped.
+func (b *patternBox) halt() {
+	if b.queue != nil {
+		b.queue.halt()
+	}
+}
+
+// sink returns the sink that the player of the next pattern should send to.
+func (b *patternBox) sink() playback.Sink {
+	if b.queue != nil {
+		return b.queue.newSource()
+	}
+	return patternSink{b.page.stack}
+}
+
 type patternState struct {
 	*gtk.Box
 	pattern *pattern.Pattern
 	page    *DevicePage
 	player  *patternPlayer
 
+	toggle   *gtk.Button
+	options  *patternOptions
+	timeline *patternTimeline
+	seeker   *patternSeeker
 	duration *gtk.Label
 }
 
 func newPatternState(b *patternBox, p *pattern.Pattern, name string) *patternState {
 	s := &patternState{
+		Box:     gtk.NewBox(gtk.OrientationVertical, 0),
 		pattern: p,
 		page:    b.page,
-		player:  newPatternPlayer(b.page, p),
 	}
+
+	s.player = newPatternPlayerTo(b.page, p, s, b.sink())
 	s.player.F = s.tick
+	s.player.OnHalt = s.pause
 
 	stop := gtk.NewButtonFromIconName("media-playback-stop-symbolic")
 	stop.ConnectClicked(b.stop)
 
-	togglePlay := gtk.NewButtonFromIconName("media-playback-start-symbolic")
-	togglePlay.ConnectClicked(func() {
+	s.toggle = gtk.NewButtonFromIconName("media-playback-start-symbolic")
+	s.toggle.ConnectClicked(func() {
 		if s.player.IsStarted() {
-			s.player.Stop()
-			s.page.setZeroValues()
-			s.RemoveCSSClass("pattern-playing")
-			togglePlay.SetIconName("media-playback-start-symbolic")
+			s.pause()
 		} else {
-			s.player.Start()
-			s.AddCSSClass("pattern-playing")
-			togglePlay.SetIconName("media-playback-pause-symbolic")
+			s.play()
 		}
 	})
 
+	edit := gtk.NewButtonFromIconName("document-edit-symbolic")
+	edit.SetTooltipText("Edit a copy of the pattern")
+	edit.ConnectClicked(func() {
+		newPatternEditorOf(b.page, patternedit.Open(p), name).Show()
+	})
+
+	s.options = newPatternOptions(s.player)
+
 	controls := gtk.NewBox(gtk.OrientationHorizontal, 0)
 	controls.AddCSSClass("pattern-controls")
-	controls.Append(togglePlay)
+	controls.Append(s.toggle)
 	controls.Append(stop)
+	controls.Append(edit)
+	controls.Append(s.options)
 
 	nameLabel := gtk.NewLabel(name)
 	nameLabel.SetXAlign(0)
 	nameLabel.SetEllipsize(pango.EllipsizeEnd)
 	nameLabel.SetTooltipText(name)
 
-	s.duration = gtk.NewLabel(s.player.TotalDuration)
+	s.duration = gtk.NewLabel(fmtDuration(patternDuration(p)))
 	s.duration.SetXAlign(0)
 	s.duration.SetHExpand(true)
 
@@ -226,20 +379,54 @@ func newPatternState(b *patternBox, p *pattern.Pattern, name string) *patternSta
 	infoBox.Append(nameLabel)
 	infoBox.Append(s.duration)
 
-	s.Box = gtk.NewBox(gtk.OrientationHorizontal, 0)
-	s.Box.Append(infoBox)
-	s.Box.Append(controls)
+	top := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	top.Append(infoBox)
+	top.Append(controls)
+
+	s.timeline = newPatternTimeline(s.player)
+	s.timeline.OnSeek = s.tick
 
+	s.seeker = newPatternSeeker(s.player)
+	s.seeker.Scale.ConnectValueChanged(s.timeline.update)
+
+	s.Box.Append(top)
+	s.Box.Append(s.timeline)
+	s.Box.Append(s.seeker)
+
+	if b.queue != nil {
+		b.queue.attach(s)
+	}
+
+	s.tick()
 	return s
 }
 
+func (s *patternState) play() {
+	s.player.Start()
+	s.AddCSSClass("pattern-playing")
+	s.toggle.SetIconName("media-playback-pause-symbolic")
+}
+
+func (s *patternState) pause() {
+	s.player.Stop()
+	s.page.setZeroValues()
+	s.RemoveCSSClass("pattern-playing")
+	s.toggle.SetIconName("media-playback-start-symbolic")
+}
+
 func (s *patternState) tick() {
-	s.player.tick()
+	s.timeline.update()
+	s.seeker.update()
 
-	s.duration.SetMarkup(fmt.Sprintf(
+	markup := fmt.Sprintf(
 		"<b>%s</b>/%s",
 		fmtDuration(s.player.CurrentDuration()), s.player.TotalDuration,
-	))
+	)
+	if loops := loopText(s.player); loops != "" {
+		markup += " · " + loops
+	}
+
+	s.duration.SetMarkup(markup)
 }
 
 func fmtDuration(d time.Duration) string {
@@ -258,54 +445,301 @@ func (s *patternState) detach() {
 	s.player.Stop()
 }
 
+// patternPlayer plays a pattern on a device page. The pattern is played by a
+// playback.Engine outside the UI thread, which sends its frames straight to
+// the page's output. The UI only observes the engine's position.
 type patternPlayer struct {
-	gticker.Func
-
-	pattern *pattern.Pattern
-	page    *DevicePage
+	*playback.Engine
+	page *DevicePage
+	view *gtk.Widget
+	// pages holds the pages that the player is registered to while it's
+	// playing, which are those of every device that it drives.
+	pages []*DevicePage
+
+	// F is called on every frame drawn by view while the player is playing.
+	F func()
+	// OnHalt is called instead of Stop when the player must be stopped from
+	// outside, such as by the emergency stop. It should stop the player and
+	// update whatever widgets show its state.
+	OnHalt func()
+	// OnFinish is called after the player has been halted because the
+	// pattern has finished playing by itself.
+	OnFinish func()
 
 	TotalDuration string
-	Frame         int
+
+	tick   uint
+	unhook func()
+}
+
+// newPatternPlayer creates a new player. view is the widget that shows the
+// player's state; F is called on its frame clock.
+func newPatternPlayer(page *DevicePage, p *pattern.Pattern, view gtk.Widgetter) *patternPlayer {
+	return newPatternPlayerTo(page, p, view, patternSink{page.stack})
 }
 
-func newPatternPlayer(page *DevicePage, pattern *pattern.Pattern) *patternPlayer {
-	p := &patternPlayer{
-		pattern: pattern,
-		page:    page,
+// newPatternPlayerTo creates a new player that sends to the given sink instead
+// of straight to the devices.
+func newPatternPlayerTo(page *DevicePage, p *pattern.Pattern, view gtk.Widgetter, sink playback.Sink) *patternPlayer {
+	mapping, ok := settings.ChannelMapping(page.deviceName(), playback.Layout(p))
+	if ok {
+		mapping = mapping.OnDevice(page.deviceIndex())
+	} else {
+		mapping = defaultMapping(page, p)
+	}
+
+	player := &patternPlayer{
+		Engine: playback.NewEngine(p, sink, mapping),
+		page:   page,
+		view:   gtk.BaseWidget(view),
 	}
-	p.TotalDuration = fmtDuration(patternDuration(pattern))
-	p.D = pattern.Interval
-	p.F = p.tick
+	player.TotalDuration = fmtDuration(player.Duration())
+	player.OnEnd(func() { glib.IdleAdd(player.ended) })
+	player.SetInterpolation(playback.ParseInterpolation(settings.Get().PatternInterpolation))
+	player.SetSteps(maxInt(page.VibrationSteps()))
 
-	return p
+	return player
 }
 
-func (p *patternPlayer) CurrentDuration() time.Duration {
-	return pointDuration(p.pattern, p.Frame)
+// defaultMapping returns the mapping of p onto the page's actuators.
+func defaultMapping(page *DevicePage, p *pattern.Pattern) playback.Mapping {
+	return playback.DefaultMapping(p, page.deviceIndex(), page.motors())
 }
 
-func (p *patternPlayer) tick() {
-	p.onTick()
+// motors returns the indices of the page's actuators by their type.
+func (p *DevicePage) motors() playback.Motors {
+	motors := make(playback.Motors, len(playback.Actuators))
+	for _, actuator := range playback.Actuators {
+		steps := p.actuatorSteps(actuatorMessage(actuator))
+		for motor := range steps {
+			motors[actuator] = append(motors[actuator], motor)
+		}
+	}
+	return motors
+}
 
-	if p.Frame++; p.Frame >= len(p.pattern.Points) {
-		p.Frame = 0
+// actuatorMessage returns the message that drives the given type of actuator.
+func actuatorMessage(actuator playback.Actuator) buttplug.MessageType {
+	switch actuator {
+	case playback.Rotator:
+		return buttplug.RotateCmdMessage
+	case playback.Linear:
+		return buttplug.LinearCmdMessage
+	default:
+		return buttplug.VibrateCmdMessage
 	}
 }
 
-func (p *patternPlayer) onTick() {
-	ranges := p.page.ranges
-	points := p.pattern.Points[p.Frame]
-	if len(points) == 0 {
-		setRanges(ranges, 0)
+// IsStarted returns true if the player is playing.
+func (p *patternPlayer) IsStarted() bool {
+	return p.IsPlaying()
+}
+
+// Start starts playing the pattern and registers the player to its page.
+func (p *patternPlayer) Start() {
+	if p.IsPlaying() {
 		return
 	}
 
-	if len(ranges) != len(points) {
-		setRanges(ranges, points[0].Scale(p.pattern.Version)*100)
+	p.register()
+	p.Play()
+	p.unhook = shutdown.OnStop(p.Pause)
+
+	p.tick = p.view.AddTickCallback(func(gtk.Widgetter, gdk.FrameClocker) bool {
+		p.observe()
+		return true
+	})
+}
+
+// Stop stops playing the pattern. Nothing is sent by the player once it
+// returns.
+func (p *patternPlayer) Stop() {
+	p.unregister()
+	p.Pause()
+
+	if p.unhook != nil {
+		p.unhook()
+		p.unhook = nil
+	}
+
+	if p.tick != 0 {
+		p.view.RemoveTickCallback(p.tick)
+		p.tick = 0
+	}
+}
+
+// SetMapping sets the channel mapping and registers the player to the pages
+// of the devices that it now drives.
+func (p *patternPlayer) SetMapping(m playback.Mapping) {
+	p.Engine.SetMapping(m)
+	if p.IsPlaying() {
+		p.register()
+	}
+}
+
+// register registers the player to its page and to the pages of the other
+// devices that it drives, so that stopping any of them halts the player.
+func (p *patternPlayer) register() {
+	p.unregister()
+
+	p.pages = []*DevicePage{p.page}
+	if p.page.stack != nil {
+		devices := p.Mapping().Devices()
+		for _, page := range p.page.stack.Devices() {
+			if page != p.page && containsInt(devices, page.deviceIndex()) {
+				p.pages = append(p.pages, page)
+			}
+		}
+	}
+
+	for _, page := range p.pages {
+		page.players[p] = struct{}{}
+	}
+}
+
+func (p *patternPlayer) unregister() {
+	for _, page := range p.pages {
+		delete(page.players, p)
+	}
+	p.pages = nil
+}
+
+// ended is called once the pattern has finished playing by itself.
+func (p *patternPlayer) ended() {
+	if p.IsPlaying() {
+		// Restarted in the meantime.
 		return
 	}
+	p.halt()
+
+	if p.OnFinish != nil {
+		p.OnFinish()
+	}
+}
 
-	for motor, strength := range p.pattern.Points[p.Frame] {
-		ranges[motor].SetValue(strength.Scale(p.pattern.Version) * 100)
+func (p *patternPlayer) halt() {
+	if p.OnHalt != nil {
+		p.OnHalt()
+	} else {
+		p.Stop()
+	}
+}
+
+// CurrentDuration returns the current position into the pattern.
+func (p *patternPlayer) CurrentDuration() time.Duration {
+	return p.Position()
+}
+
+// observe shows the last played values of the page's device on its ranges.
+func (p *patternPlayer) observe() {
+	if frame, values := p.Frame(); frame >= 0 {
+		index := p.page.deviceIndex()
+		for output, value := range values {
+			if output.Device != index {
+				continue
+			}
+			kind := actuatorMessage(output.Actuator)
+			for _, vrange := range p.page.ranges {
+				if vrange.Kind == kind && vrange.Motor == output.Motor {
+					p.page.showValue(vrange, value*100)
+				}
+			}
+		}
+	}
+
+	if p.F != nil {
+		p.F()
+	}
+}
+
+func maxInt(ints []int) int {
+	var max int
+	for _, i := range ints {
+		if i > max {
+			max = i
+		}
+	}
+	return max
+}
+
+// patternSink routes what a pattern engine plays to the outputs of the devices
+// that it's mapped to. Outputs of devices that aren't connected are dropped.
+// Rotators always turn clockwise, and linear actuators move to their position
+// over one command interval.
+type patternSink struct {
+	stack *DeviceStack
+}
+
+// sinkBatch holds the values that a pattern sends to one device.
+type sinkBatch struct {
+	vibrate map[int]float64
+	rotate  map[int]device.Rotation
+	linear  map[int]device.Vector
+}
+
+func (s patternSink) Send(values map[playback.Output]float64) {
+	duration := currentCommandInterval()
+
+	batches := make(map[int]*sinkBatch, 1)
+	for output, value := range values {
+		batch, ok := batches[output.Device]
+		if !ok {
+			batch = &sinkBatch{}
+			batches[output.Device] = batch
+		}
+
+		switch output.Actuator {
+		case playback.Vibrator:
+			if batch.vibrate == nil {
+				batch.vibrate = make(map[int]float64, len(values))
+			}
+			batch.vibrate[output.Motor] = value
+		case playback.Rotator:
+			if batch.rotate == nil {
+				batch.rotate = make(map[int]device.Rotation, 1)
+			}
+			batch.rotate[output.Motor] = device.Rotation{Speed: value, Clockwise: true}
+		case playback.Linear:
+			if batch.linear == nil {
+				batch.linear = make(map[int]device.Vector, 1)
+			}
+			batch.linear[output.Motor] = device.Vector{Duration: duration, Position: value}
+		}
+	}
+
+	for index, batch := range batches {
+		output := s.stack.output(index)
+		if output == nil {
+			continue
+		}
+
+		if output.Paused() {
+			batch.zero()
+		}
+
+		if len(batch.vibrate) > 0 {
+			output.Vibrate(batch.vibrate)
+		}
+		if len(batch.rotate) > 0 {
+			output.Rotate(batch.rotate)
+		}
+		if len(batch.linear) > 0 {
+			output.Linear(batch.linear)
+		}
+	}
+}
+
+// zero sets every value of the batch to 0.
+func (b *sinkBatch) zero() {
+	for motor := range b.vibrate {
+		b.vibrate[motor] = 0
+	}
+	for motor, rotation := range b.rotate {
+		rotation.Speed = 0
+		b.rotate[motor] = rotation
+	}
+	for motor, vector := range b.linear {
+		vector.Position = 0
+		b.linear[motor] = vector
 	}
 }
@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -20,6 +19,11 @@ import (
 	"github.com/diamondburned/gotk4/pkg/pango"
 	"github.com/diamondburned/intiface-gtk/internal/app"
 	"github.com/diamondburned/intiface-gtk/internal/httpcache"
+	"github.com/diamondburned/intiface-gtk/internal/library"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+	"github.com/diamondburned/intiface-gtk/internal/playlist"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+	"github.com/diamondburned/intiface-gtk/internal/sparklines"
 	"github.com/diamondburned/intiface-gtk/internal/ui/components"
 )
 
@@ -105,6 +109,7 @@ func NewPatternBrowser(page *DevicePage) *PatternBrowser {
 	box.Append(b.body)
 	box.SetFocusChild(b.body)
 
+	b.Dialog.SetApplication(app.Require())
 	b.Dialog.AddCSSClass("pattern-browser-dialog")
 	b.Dialog.SetDefaultSize(350, 500)
 	b.Dialog.SetChild(box)
@@ -219,13 +224,17 @@ type patternRow struct {
 	loadBox *gtk.Box
 	tryout  *patternTryout
 	actions *patternActions
+	// added is the library entry of the pattern once the row has added it.
+	added *library.Entry
 
 	loaded bool
 }
 
 type patternActions struct {
 	*gtk.Box
-	save *components.IconLabelButton
+	save     *components.IconLabelButton
+	library  *components.IconLabelButton
+	playlist *gtk.MenuButton
 }
 
 func newPatternRow(page *pageDialog, apiPattern *api.Pattern) *patternRow {
@@ -276,7 +285,7 @@ func (r *patternRow) load(apiPattern *api.Pattern) {
 			return
 		}
 
-		p, err := pattern.Parse(bytes.NewReader(b))
+		p, err := playback.Load(bytes.NewReader(b))
 		if err != nil {
 			glib.IdleAdd(func() { r.loading.SetError(err) })
 			return
@@ -285,6 +294,7 @@ func (r *patternRow) load(apiPattern *api.Pattern) {
 		glib.IdleAdd(func() {
 			r.tryout = newPatternTryout(r.info.page, p)
 			r.tryout.SetSizeRequest(-1, 50)
+			r.info.setThumbnail(p)
 
 			r.finish(patternLoadedData{
 				pattern:  p,
@@ -315,9 +325,21 @@ func (r *patternRow) finish(data patternLoadedData) {
 		fm.Show()
 	})
 
+	actions.library = components.NewIconLabelButton("list-add-symbolic", "Add to Library", gtk.PosLeft)
+	actions.library.SetHasFrame(true)
+	actions.library.ConnectClicked(func() {
+		r.addToLibrary(data, func(*library.Library, library.Entry) {})
+	})
+
+	actions.playlist = gtk.NewMenuButton()
+	actions.playlist.SetLabel("Add to Playlist")
+	actions.playlist.SetPopover(r.newPlaylistMenu(data))
+
 	actions.Box = gtk.NewBox(gtk.OrientationHorizontal, 0)
 	actions.Box.AddCSSClass("pattern-browse-actions")
 	actions.Box.Append(actions.save)
+	actions.Box.Append(actions.library)
+	actions.Box.Append(actions.playlist)
 
 	r.actions = actions
 
@@ -351,6 +373,111 @@ func (r *patternRow) save(f gio.Filer, data patternLoadedData) {
 	}()
 }
 
+// addToLibrary adds the pattern to the library unless the row has already
+// added it, then calls done with its entry.
+func (r *patternRow) addToLibrary(data patternLoadedData, done func(*library.Library, library.Entry)) {
+	apiPattern := r.info.pattern
+	added := r.added
+
+	r.loading.SetLoading()
+	go func() {
+		var entry library.Entry
+
+		lib, err := patternLibrary()
+		if err == nil {
+			if added != nil {
+				entry = *added
+			} else {
+				entry, err = lib.Add(data.rawBytes, library.Entry{
+					Name:   apiPattern.DecodedName(),
+					Author: apiPattern.AuthorOrAnon(),
+					Source: apiPattern.CDNPath,
+				})
+			}
+		}
+
+		glib.IdleAdd(func() {
+			if err != nil {
+				r.loading.SetError(err)
+				return
+			}
+
+			r.added = &entry
+			r.loading.SetChild(r.loadBox)
+			r.actions.library.SetSensitive(false)
+			r.actions.library.IconLabel.SetIconLabel("object-select-symbolic", "Added")
+
+			done(lib, entry)
+		})
+	}()
+}
+
+// newPlaylistMenu creates a popover that lists the playlists. Choosing one
+// adds the pattern to the library, then to the end of the playlist.
+func (r *patternRow) newPlaylistMenu(data patternLoadedData) *gtk.Popover {
+	list := gtk.NewBox(gtk.OrientationVertical, 2)
+
+	popover := gtk.NewPopover()
+	popover.SetChild(list)
+	popover.ConnectShow(func() {
+		for child := list.FirstChild(); child != nil; child = list.FirstChild() {
+			list.Remove(child)
+		}
+
+		go func() {
+			store, err := playlistStore()
+			if err != nil {
+				log.Println("cannot open playlists:", err)
+				return
+			}
+
+			glib.IdleAdd(func() {
+				add := func(name string) {
+					popover.Popdown()
+					r.addToLibrary(data, func(lib *library.Library, entry library.Entry) {
+						r.addToPlaylist(store, name, lib, entry)
+					})
+				}
+
+				for _, p := range store.Playlists() {
+					name := p.Name
+
+					button := gtk.NewButtonWithLabel(name)
+					button.AddCSSClass("flat")
+					button.ConnectClicked(func() { add(name) })
+					list.Append(button)
+				}
+
+				newButton := gtk.NewButtonWithLabel("New Playlist")
+				newButton.AddCSSClass("flat")
+				newButton.ConnectClicked(func() { add(store.NewName("Playlist")) })
+				list.Append(newButton)
+			})
+		}()
+	})
+
+	return popover
+}
+
+func (r *patternRow) addToPlaylist(store *playlist.Store, name string, lib *library.Library, entry library.Entry) {
+	p, ok := store.Get(name)
+	if !ok {
+		p = playlist.Playlist{Name: name}
+	}
+
+	p.Entries = append(p.Entries, playlist.Entry{
+		Path: lib.Path(entry),
+		Name: entry.Name,
+	})
+
+	if err := store.Put(name, p); err != nil {
+		r.loading.SetError(err)
+		return
+	}
+
+	r.actions.playlist.SetLabel("Added to " + name)
+}
+
 type patternInfo struct {
 	*gtk.Box // vertical
 	page     *pageDialog
@@ -361,6 +488,8 @@ type patternInfo struct {
 	name   *gtk.Label
 	meta   *gtk.Label
 
+	thumbnail *sparklines.Plot
+
 	right     *gtk.Box
 	length    *components.IconLabel
 	favorites *components.IconLabel
@@ -441,9 +570,46 @@ func newPatternInfo(page *pageDialog, apiPattern *api.Pattern) *patternInfo {
 	p.Box.Append(p.left)
 	p.Box.Append(p.right)
 
+	if settings.Get().PatternThumbnails {
+		go p.loadThumbnail()
+	}
+
 	return p
 }
 
+// loadThumbnail shows a thumbnail of the pattern if it's already cached.
+// Patterns aren't downloaded just for their thumbnails.
+func (p *patternInfo) loadThumbnail() {
+	b, ok := httpcache.CachedPatternBytes(p.pattern)
+	if !ok {
+		return
+	}
+
+	pat, err := playback.Load(bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+
+	glib.IdleAdd(func() { p.setThumbnail(pat) })
+}
+
+// setThumbnail shows a small overview of the pattern's channels, unless
+// thumbnails are disabled or one is already shown.
+func (p *patternInfo) setThumbnail(pat *pattern.Pattern) {
+	if p.thumbnail != nil || !settings.Get().PatternThumbnails {
+		return
+	}
+
+	p.thumbnail = sparklines.NewStaticPlot(patternDuration(pat).Seconds())
+	p.thumbnail.AddCSSClass("pattern-thumbnail")
+	p.thumbnail.SetRange(0, 100)
+	p.thumbnail.SetPadding(0, 1)
+	p.thumbnail.SetSizeRequest(80, -1)
+	plotPattern(p.thumbnail, pat, 1)
+
+	p.Box.InsertChildAfter(p.thumbnail, p.left)
+}
+
 func stringifyFeatures(feats []pattern.Feature) string {
 	var b strings.Builder
 	for i, feat := range feats {
@@ -460,62 +626,59 @@ type patternTryout struct {
 	page    *pageDialog
 	pattern *pattern.Pattern
 
-	// plot   *sparklines.Plot
-	// lines  []*sparklines.Line
-	player *patternPlayer
+	player   *patternPlayer
+	timeline *patternTimeline
 
 	controls *gtk.Box
 	toggle   *gtk.Button
-	seeker   *gtk.Scale
-
-	updating bool
+	seeker   *patternSeeker
+	options  *patternOptions
 }
 
 func newPatternTryout(page *pageDialog, p *pattern.Pattern) *patternTryout {
 	t := &patternTryout{
+		Box:     gtk.NewBox(gtk.OrientationVertical, 0),
 		page:    page,
 		pattern: p,
-		player:  newPatternPlayer(page.DevicePage, p),
 	}
-	t.player.F = t.tick
 
-	// b.plot = sparklines.NewPlot()
-	// b.plot.AddCSSClass("pattern-tryout-sparkline")
-	// b.plot.SetDuration(5 * time.Second)
-	// b.plot.SetRange(0, 20)
-	// b.plot.SetPadding(4, 6)
-	// b.plot.SetMinHeight(80)
+	t.player = newPatternPlayer(page.DevicePage, p, t)
+	t.player.F = t.tick
+	t.player.OnHalt = t.pause
 
-	// for i := 0; i < len(p.Features); i++ {
-	// 	b.lines = append(b.lines, b.plot.AddLine())
-	// }
+	t.timeline = newPatternTimeline(t.player)
+	t.timeline.AddCSSClass("pattern-tryout-sparkline")
+	t.timeline.OnSeek = t.tick
 
 	t.toggle = gtk.NewButtonFromIconName("media-playback-start-symbolic")
 	t.toggle.ConnectClicked(t.togglePlay)
 
-	t.seeker = gtk.NewScaleWithRange(
-		gtk.OrientationHorizontal,
-		0, patternDuration(p).Seconds(), 1,
-	)
+	t.seeker = newPatternSeeker(t.player)
 	t.seeker.SetHExpand(true)
-	t.seeker.SetDrawValue(true)
-	t.seeker.SetValuePos(gtk.PosRight)
-	t.seeker.ConnectValueChanged(t.seekToBar)
+	t.seeker.Scale.SetDrawValue(true)
+	t.seeker.Scale.SetValuePos(gtk.PosRight)
+	t.seeker.Scale.ConnectValueChanged(t.timeline.update)
 
 	totalDuration := fmtDuration(patternDuration(p))
-	t.seeker.SetFormatValueFunc(func(_ *gtk.Scale, secs float64) string {
-		return fmtDuration(secsToDuration(secs)) + "/" + totalDuration
+	t.seeker.Scale.SetFormatValueFunc(func(_ *gtk.Scale, secs float64) string {
+		text := fmtDuration(secsToDuration(secs)) + "/" + totalDuration
+		if loops := loopText(t.player); loops != "" {
+			text += " · " + loops
+		}
+		return text
 	})
 
+	t.options = newPatternOptions(t.player)
+
 	t.controls = gtk.NewBox(gtk.OrientationHorizontal, 4)
 	t.controls.SetHExpand(true)
 	t.controls.SetVAlign(gtk.AlignCenter)
 	t.controls.Append(t.toggle)
 	t.controls.Append(t.seeker)
+	t.controls.Append(t.options)
 
-	t.Box = gtk.NewBox(gtk.OrientationHorizontal, 0)
 	t.Box.AddCSSClass("pattern-tryout-body")
-	// b.Box.Append(b.plot)
+	t.Box.Append(t.timeline)
 	t.Box.Append(t.controls)
 
 	return t
@@ -546,24 +709,7 @@ func (t *patternTryout) play() {
 	t.toggle.SetIconName("media-playback-pause-symbolic")
 }
 
-func (t *patternTryout) seekToBar() {
-	if t.updating {
-		return
-	}
-
-	t.player.Frame = int(math.Floor(
-		// Scale the seeker value (in seconds) down, then scale it up according
-		// to the number of points. That gives us the new frame index.
-		t.seeker.Value() / patternDuration(t.pattern).Seconds() * float64(len(t.pattern.Points)),
-	))
-
-	// Don't call the tick callback. Let the background routine tick by itself,
-	// so the point-per-minute stays consistent.
-}
-
 func (t *patternTryout) tick() {
-	t.player.tick()
-	t.updating = true
-	t.seeker.SetValue(t.player.CurrentDuration().Seconds())
-	t.updating = false
+	t.timeline.update()
+	t.seeker.update()
 }
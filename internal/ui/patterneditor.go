@@ -0,0 +1,638 @@
+package ui
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+	"github.com/diamondburned/gotk4/pkg/gdk/v4"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/intiface-gtk/internal/app"
+	"github.com/diamondburned/intiface-gtk/internal/library"
+	"github.com/diamondburned/intiface-gtk/internal/patternedit"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+	"github.com/diamondburned/intiface-gtk/internal/sparklines"
+)
+
+// editorClip holds the points that were last copied in any pattern editor.
+var editorClip patternedit.Clip
+
+// editorFeatures lists the features that a channel can be set to.
+var editorFeatures = []pattern.Feature{
+	pattern.Vibrate,
+	pattern.Vibrate1,
+	pattern.Vibrate2,
+	pattern.Rotate,
+	pattern.AirPump,
+}
+
+// patternEditor is a window that edits a pattern. Values are drawn on the
+// plot of each channel with the primary button, while the secondary button
+// selects a range of points to copy. The pattern can be previewed on any
+// device while it's being edited.
+type patternEditor struct {
+	*gtk.Window
+	page *DevicePage
+	doc  *patternedit.Document
+
+	name     *gtk.Entry
+	interval *gtk.SpinButton
+	length   *gtk.SpinButton
+	from     *gtk.SpinButton
+	to       *gtk.SpinButton
+	channels *gtk.Box
+	rows     []*editorChannel
+	info     *gtk.Label
+	status   *gtk.Label
+	undo     *gtk.Button
+	redo     *gtk.Button
+
+	preview *patternPreview
+
+	// updating is true while the widgets are set from the document, so that
+	// their handlers don't edit it.
+	updating bool
+	// warned is true once closing has been refused because of unsaved
+	// changes.
+	warned bool
+}
+
+// newPatternEditor creates an editor of a new pattern, which drives every
+// actuator of the page's device.
+func newPatternEditor(page *DevicePage) *patternEditor {
+	features := playback.Features(page.motors())
+	doc := patternedit.New(features, 100*time.Millisecond, 50)
+	return newPatternEditorOf(page, doc, "New Pattern")
+}
+
+// newPatternEditorOf creates an editor of the given document. page is the
+// device that it's opened from, which is previewed on by default.
+func newPatternEditorOf(page *DevicePage, doc *patternedit.Document, name string) *patternEditor {
+	e := &patternEditor{
+		page: page,
+		doc:  doc,
+	}
+
+	e.name = gtk.NewEntry()
+	e.name.SetText(name)
+	e.name.SetPlaceholderText("Name")
+	e.name.SetHExpand(true)
+	e.name.ConnectChanged(e.updateTitle)
+
+	e.interval = gtk.NewSpinButtonWithRange(1, 10000, 10)
+	e.interval.SetTooltipText("Time between two points")
+	e.interval.ConnectValueChanged(func() {
+		if !e.updating {
+			e.doc.SetInterval(time.Duration(e.interval.ValueAsInt()) * time.Millisecond)
+			e.changed()
+		}
+	})
+
+	e.length = gtk.NewSpinButtonWithRange(1, 100000, 1)
+	e.length.SetTooltipText("Number of points; points are added or removed at the end")
+	e.length.ConnectValueChanged(func() {
+		if !e.updating {
+			e.doc.SetLength(e.length.ValueAsInt())
+			e.changed()
+		}
+	})
+
+	options := gtk.NewGrid()
+	options.SetRowSpacing(4)
+	options.SetColumnSpacing(8)
+	attachOption(options, 0, "Name", e.name)
+	attachOption(options, 1, "Interval (ms)", e.interval)
+	attachOption(options, 2, "Length (points)", e.length)
+
+	e.channels = gtk.NewBox(gtk.OrientationVertical, 4)
+	e.channels.AddCSSClass("pattern-editor-channels")
+
+	channelScroll := gtk.NewScrolledWindow()
+	channelScroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	channelScroll.SetPropagateNaturalHeight(true)
+	channelScroll.SetMaxContentHeight(400)
+	channelScroll.SetVExpand(true)
+	channelScroll.SetChild(e.channels)
+
+	addChannel := gtk.NewButtonWithLabel("Add Channel")
+	addChannel.SetHAlign(gtk.AlignStart)
+	addChannel.ConnectClicked(func() {
+		e.doc.AddChannel(pattern.Vibrate)
+		e.changed()
+	})
+
+	e.from = gtk.NewSpinButtonWithRange(0, 1, 0.1)
+	e.from.SetDigits(2)
+	e.from.SetTooltipText("Start of the selection in seconds")
+
+	e.to = gtk.NewSpinButtonWithRange(0, 1, 0.1)
+	e.to.SetDigits(2)
+	e.to.SetTooltipText("End of the selection in seconds")
+
+	copyBtn := gtk.NewButtonFromIconName("edit-copy-symbolic")
+	copyBtn.SetTooltipText("Copy the selection")
+	copyBtn.ConnectClicked(e.copy)
+
+	cut := gtk.NewButtonFromIconName("edit-cut-symbolic")
+	cut.SetTooltipText("Cut the selection")
+	cut.ConnectClicked(e.cut)
+
+	paste := gtk.NewButtonFromIconName("edit-paste-symbolic")
+	paste.SetTooltipText("Paste over the points from the start of the selection")
+	paste.ConnectClicked(e.paste)
+
+	selection := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	selection.AddCSSClass("pattern-editor-selection")
+	selection.Append(gtk.NewLabel("Selection"))
+	selection.Append(e.from)
+	selection.Append(gtk.NewLabel("to"))
+	selection.Append(e.to)
+	selection.Append(copyBtn)
+	selection.Append(cut)
+	selection.Append(paste)
+
+	e.preview = newPatternPreview(page, e.doc.Pattern)
+	e.preview.OnTick = e.tick
+
+	openBtn := gtk.NewButtonWithLabel("Open in Player")
+	openBtn.SetTooltipText("Play the pattern on " + page.deviceName())
+	openBtn.ConnectClicked(e.openInPlayer)
+
+	e.preview.Append(openBtn)
+
+	e.info = gtk.NewLabel("")
+	e.info.SetXAlign(0)
+	e.info.AddCSSClass("dim-label")
+
+	e.status = gtk.NewLabel("")
+	e.status.SetXAlign(0)
+	e.status.SetWrap(true)
+	e.status.SetWrapMode(pango.WrapWordChar)
+	e.status.SetVisible(false)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 8)
+	box.AddCSSClass("pattern-editor")
+	box.Append(options)
+	box.Append(channelScroll)
+	box.Append(addChannel)
+	box.Append(selection)
+	box.Append(e.preview)
+	box.Append(e.info)
+	box.Append(e.status)
+
+	e.undo = gtk.NewButtonFromIconName("edit-undo-symbolic")
+	e.undo.SetTooltipText("Undo")
+	e.undo.ConnectClicked(e.undoEdit)
+
+	e.redo = gtk.NewButtonFromIconName("edit-redo-symbolic")
+	e.redo.SetTooltipText("Redo")
+	e.redo.ConnectClicked(e.redoEdit)
+
+	saveFile := gtk.NewButtonWithLabel("Save As…")
+	saveFile.ConnectClicked(e.saveFile)
+
+	saveLibrary := gtk.NewButtonWithLabel("Save to Library")
+	saveLibrary.AddCSSClass("suggested-action")
+	saveLibrary.ConnectClicked(e.saveLibrary)
+
+	header := gtk.NewHeaderBar()
+	header.PackStart(e.undo)
+	header.PackStart(e.redo)
+	header.PackEnd(saveLibrary)
+	header.PackEnd(saveFile)
+
+	e.Window = gtk.NewWindow()
+	e.Window.SetApplication(app.Require())
+	e.Window.SetTransientFor(app.Require().ActiveWindow())
+	e.Window.SetDefaultSize(600, -1)
+	e.Window.SetTitlebar(header)
+	e.Window.SetChild(box)
+	e.Window.AddController(e.newShortcuts())
+	e.Window.ConnectCloseRequest(e.closeRequest)
+
+	e.update()
+	return e
+}
+
+// newShortcuts binds the usual keyboard shortcuts of an editor.
+func (e *patternEditor) newShortcuts() *gtk.ShortcutController {
+	shortcuts := gtk.NewShortcutController()
+
+	bind := func(trigger string, f func()) {
+		shortcuts.AddShortcut(gtk.NewShortcut(
+			gtk.NewShortcutTriggerParseString(trigger),
+			gtk.NewCallbackAction(func(gtk.Widgetter, *glib.Variant) bool {
+				f()
+				return true
+			}),
+		))
+	}
+
+	bind("<Primary>z", e.undoEdit)
+	bind("<Primary><Shift>z", e.redoEdit)
+	bind("<Primary>y", e.redoEdit)
+	bind("<Primary>c", e.copy)
+	bind("<Primary>x", e.cut)
+	bind("<Primary>v", e.paste)
+	bind("<Primary>s", e.saveFile)
+
+	return shortcuts
+}
+
+// update shows the whole document.
+func (e *patternEditor) update() {
+	e.updating = true
+	defer func() { e.updating = false }()
+
+	e.interval.SetValue(float64(e.doc.Interval().Milliseconds()))
+	e.length.SetValue(float64(e.doc.Len()))
+
+	duration := e.doc.Duration().Seconds()
+	step := e.doc.Interval().Seconds()
+	for _, spin := range []*gtk.SpinButton{e.from, e.to} {
+		spin.SetRange(0, duration)
+		spin.SetIncrements(step, step*10)
+	}
+
+	for child := e.channels.FirstChild(); child != nil; child = e.channels.FirstChild() {
+		e.channels.Remove(child)
+	}
+
+	e.rows = make([]*editorChannel, e.doc.Channels())
+	for ch := range e.rows {
+		e.rows[ch] = newEditorChannel(e, ch)
+		e.channels.Append(e.rows[ch])
+	}
+
+	e.updateState()
+}
+
+// updateState shows the parts of the state that don't depend on the points.
+func (e *patternEditor) updateState() {
+	e.undo.SetSensitive(e.doc.CanUndo())
+	e.redo.SetSensitive(e.doc.CanRedo())
+
+	e.info.SetText(fmt.Sprintf(
+		"%s · %d points · %s",
+		fmtDuration(e.doc.Duration()), e.doc.Len(), stringifyFeatures(e.doc.Features()),
+	))
+
+	e.updateTitle()
+}
+
+func (e *patternEditor) updateTitle() {
+	title := "Pattern Editor ⁠— " + e.name.Text()
+	if e.doc.Modified() {
+		title = "*" + title
+	}
+	e.Window.SetTitle(title)
+}
+
+// changed shows the document after an edit and previews it.
+func (e *patternEditor) changed() {
+	e.warned = false
+	e.status.SetVisible(false)
+	e.update()
+	e.preview.restart()
+}
+
+// stroked is called after values have been drawn, which doesn't change the
+// document's structure.
+func (e *patternEditor) stroked() {
+	e.warned = false
+	e.updateState()
+	e.preview.restart()
+}
+
+func (e *patternEditor) undoEdit() {
+	if e.doc.Undo() {
+		e.changed()
+	}
+}
+
+func (e *patternEditor) redoEdit() {
+	if e.doc.Redo() {
+		e.changed()
+	}
+}
+
+// selection returns the selected range of points.
+func (e *patternEditor) selection() (from, to int) {
+	interval := e.doc.Interval().Seconds()
+	from = int(math.Round(e.from.Value() / interval))
+	to = int(math.Round(e.to.Value() / interval))
+	if to < from {
+		from, to = to, from
+	}
+	return from, to
+}
+
+// setSelection selects the points within [from, to).
+func (e *patternEditor) setSelection(from, to int) {
+	interval := e.doc.Interval().Seconds()
+	e.from.SetValue(float64(from) * interval)
+	e.to.SetValue(float64(to) * interval)
+}
+
+func (e *patternEditor) copy() {
+	from, to := e.selection()
+	if clip := e.doc.Copy(from, to); clip.Len() > 0 {
+		editorClip = clip
+		e.setStatus(fmt.Sprintf("Copied %d points.", clip.Len()))
+	}
+}
+
+func (e *patternEditor) cut() {
+	from, to := e.selection()
+	if clip := e.doc.Cut(from, to); clip.Len() > 0 {
+		editorClip = clip
+		e.changed()
+		e.setSelection(from, from)
+	}
+}
+
+func (e *patternEditor) paste() {
+	if editorClip.Len() == 0 {
+		e.setStatus("Nothing has been copied yet.")
+		return
+	}
+
+	from, _ := e.selection()
+	e.doc.Paste(from, editorClip)
+	e.changed()
+	e.setSelection(from, from+editorClip.Len())
+}
+
+// tick moves the playheads to the preview's position.
+func (e *patternEditor) tick(pos time.Duration) {
+	x := float64(pos) / float64(e.doc.Interval())
+	for _, row := range e.rows {
+		row.plot.SetNeedleX(x)
+	}
+}
+
+func (e *patternEditor) openInPlayer() {
+	if e.page.patterns == nil {
+		return
+	}
+
+	e.preview.stop()
+	e.page.patterns.stop()
+	e.page.patterns.setPattern(e.doc.Pattern(), e.name.Text())
+}
+
+// closeRequest stops the preview once the window is closed. Closing is
+// refused once if there are unsaved changes.
+func (e *patternEditor) closeRequest() bool {
+	if e.doc.Modified() && !e.warned {
+		e.warned = true
+		e.setStatus("The pattern has unsaved changes. Close the window again to discard them.")
+		return true
+	}
+
+	e.preview.stop()
+	return false
+}
+
+// marshal encodes the document. Errors are shown.
+func (e *patternEditor) marshal() ([]byte, bool) {
+	data, err := playback.Marshal(e.doc.Pattern())
+	if err != nil {
+		e.setError(err)
+		return nil, false
+	}
+	return data, true
+}
+
+func (e *patternEditor) saveFile() {
+	if data, ok := e.marshal(); ok {
+		savePatternFile(e.Window, e.name.Text(), data, e.saved)
+	}
+}
+
+func (e *patternEditor) saveLibrary() {
+	if data, ok := e.marshal(); ok {
+		savePatternLibrary(data, library.Entry{Name: e.name.Text()}, e.saved)
+	}
+}
+
+func (e *patternEditor) saved(status string, err error) {
+	if err != nil {
+		e.setError(err)
+		return
+	}
+
+	e.doc.MarkSaved()
+	e.updateTitle()
+	e.setStatus(status)
+}
+
+func (e *patternEditor) setStatus(status string) {
+	e.status.SetText(status)
+	e.status.SetVisible(true)
+}
+
+func (e *patternEditor) setError(err error) {
+	e.status.SetMarkup(fmt.Sprintf(
+		`<span color="red"><b>Error:</b></span> %s`,
+		html.EscapeString(err.Error()),
+	))
+	e.status.SetVisible(true)
+}
+
+// editorChannel is the row of a channel in a pattern editor.
+type editorChannel struct {
+	*gtk.Box
+	editor *patternEditor
+	ch     int
+
+	plot *sparklines.Plot
+	line *sparklines.Line
+}
+
+func newEditorChannel(e *patternEditor, ch int) *editorChannel {
+	c := &editorChannel{
+		editor: e,
+		ch:     ch,
+	}
+
+	feature := newFeatureDropDown(e.doc.Features()[ch], func(f pattern.Feature) {
+		if !e.updating {
+			e.doc.SetFeature(ch, f)
+			e.changed()
+		}
+	})
+	feature.SetTooltipText("What the channel drives")
+
+	remove := gtk.NewButtonFromIconName("list-remove-symbolic")
+	remove.SetTooltipText("Remove the channel")
+	remove.SetSensitive(e.doc.Channels() > 1)
+	remove.ConnectClicked(func() {
+		e.doc.RemoveChannel(ch)
+		e.changed()
+	})
+
+	top := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	top.Append(feature)
+	top.Append(remove)
+
+	c.plot = sparklines.NewStaticPlot(float64(e.doc.Len()))
+	c.plot.AddCSSClass("pattern-editor-plot")
+	c.plot.SetRange(0, 100)
+	c.plot.SetPadding(0, 2)
+	c.plot.SetMinHeight(80)
+	c.plot.SetHExpand(true)
+	c.plot.SetNeedle(0, nil, 1)
+
+	c.line = c.plot.AddLine()
+	c.line.Smooth = false
+	c.line.SetWidth(1.5)
+	c.line.SetColor(sparklines.HashColor("channel", 2<<((ch+1)*8)))
+
+	c.plot.AddController(c.newDrawGesture())
+	c.plot.AddController(c.newSelectGesture())
+
+	c.Box = gtk.NewBox(gtk.OrientationVertical, 2)
+	c.Box.AddCSSClass("pattern-editor-channel")
+	c.Box.Append(top)
+	c.Box.Append(c.plot)
+
+	c.update()
+	return c
+}
+
+// newFeatureDropDown creates a drop-down of editorFeatures with current
+// selected, which is added to them if it isn't one. set is called with the
+// feature that the user selects.
+func newFeatureDropDown(current pattern.Feature, set func(pattern.Feature)) *gtk.DropDown {
+	features := editorFeatures
+
+	selected := -1
+	for i, feature := range features {
+		if feature == current {
+			selected = i
+		}
+	}
+	if selected < 0 {
+		features = append(features[:len(features):len(features)], current)
+		selected = len(features) - 1
+	}
+
+	labels := make([]string, len(features))
+	for i, feature := range features {
+		labels[i] = featureLabel(feature)
+	}
+
+	dropDown := gtk.NewDropDownFromStrings(labels)
+	dropDown.SetSelected(uint(selected))
+	dropDown.Connect("notify::selected", func() {
+		if i := int(dropDown.Selected()); i < len(features) {
+			set(features[i])
+		}
+	})
+
+	return dropDown
+}
+
+// featureLabel returns the name of a feature that's shown to the user.
+func featureLabel(f pattern.Feature) string {
+	for _, known := range editorFeatures {
+		if f == known {
+			return f.String()
+		}
+	}
+	return string(f)
+}
+
+// update shows the values of the channel.
+func (c *editorChannel) update() {
+	values := c.editor.doc.Values(c.ch)
+	for i := range values {
+		values[i] *= 100
+	}
+	c.line.SetValues(values)
+}
+
+// locate returns the point and the value at the given coordinates on the
+// plot.
+func (c *editorChannel) locate(x, y float64) (int, float64) {
+	width := float64(c.plot.Width())
+	height := float64(c.plot.Height())
+	if width <= 0 || height <= 0 {
+		return 0, 0
+	}
+
+	n := c.editor.doc.Len()
+	i := int(x / width * float64(n))
+	if i < 0 {
+		i = 0
+	}
+	if i >= n {
+		i = n - 1
+	}
+
+	return i, 1 - y/height
+}
+
+// newDrawGesture draws values with the primary button. Every drag is one edit.
+func (c *editorChannel) newDrawGesture() *gtk.GestureDrag {
+	doc := c.editor.doc
+
+	var lastI int
+	var lastV float64
+
+	drag := gtk.NewGestureDrag()
+	drag.SetButton(gdk.BUTTON_PRIMARY)
+	drag.ConnectDragBegin(func(x, y float64) {
+		doc.Checkpoint()
+		lastI, lastV = c.locate(x, y)
+		doc.Set(c.ch, lastI, lastV)
+		c.update()
+	})
+	drag.ConnectDragUpdate(func(offsetX, offsetY float64) {
+		x, y, ok := drag.StartPoint()
+		if !ok {
+			return
+		}
+		i, v := c.locate(x+offsetX, y+offsetY)
+		doc.Line(c.ch, lastI, lastV, i, v)
+		lastI, lastV = i, v
+		c.update()
+	})
+	drag.ConnectDragEnd(func(_, _ float64) {
+		c.editor.stroked()
+	})
+
+	return drag
+}
+
+// newSelectGesture selects the points that are dragged over with the
+// secondary button.
+func (c *editorChannel) newSelectGesture() *gtk.GestureDrag {
+	var start int
+
+	drag := gtk.NewGestureDrag()
+	drag.SetButton(gdk.BUTTON_SECONDARY)
+	drag.ConnectDragBegin(func(x, y float64) {
+		start, _ = c.locate(x, y)
+		c.editor.setSelection(start, start+1)
+	})
+	drag.ConnectDragUpdate(func(offsetX, offsetY float64) {
+		x, y, ok := drag.StartPoint()
+		if !ok {
+			return
+		}
+		i, _ := c.locate(x+offsetX, y+offsetY)
+		if i < start {
+			c.editor.setSelection(i, start+1)
+		} else {
+			c.editor.setSelection(start, i+1)
+		}
+	})
+
+	return drag
+}
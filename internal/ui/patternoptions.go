@@ -0,0 +1,144 @@
+package ui
+
+import (
+	"log"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+)
+
+// patternOptions is a menu button with the playback options of a pattern
+// player. Options take effect right away.
+type patternOptions struct {
+	*gtk.MenuButton
+	player *patternPlayer
+
+	grid      *gtk.Grid
+	rows      int
+	interp    *gtk.DropDown
+	speed     *gtk.SpinButton
+	intensity *gtk.SpinButton
+	loop      *gtk.DropDown
+	loopCount *gtk.SpinButton
+}
+
+func newPatternOptions(player *patternPlayer) *patternOptions {
+	o := &patternOptions{player: player}
+
+	o.grid = gtk.NewGrid()
+	o.grid.AddCSSClass("pattern-options")
+	o.grid.SetRowSpacing(4)
+	o.grid.SetColumnSpacing(8)
+
+	labels := make([]string, len(playback.Interpolations))
+	for i, interp := range playback.Interpolations {
+		labels[i] = interp.Label()
+	}
+
+	o.interp = gtk.NewDropDownFromStrings(labels)
+	o.interp.SetTooltipText("How values between two points are sent")
+	o.interp.SetSelected(uint(playback.ParseInterpolation(settings.Get().PatternInterpolation)))
+	o.interp.Connect("notify::selected", o.setInterpolation)
+	o.addRow("Smoothing", o.interp)
+
+	o.speed = gtk.NewSpinButtonWithRange(playback.MinSpeed, playback.MaxSpeed, 0.25)
+	o.speed.SetDigits(2)
+	o.speed.SetValue(1)
+	o.speed.SetTooltipText("Playback speed")
+	o.speed.ConnectValueChanged(func() {
+		o.player.SetSpeed(o.speed.Value())
+	})
+	o.addRow("Speed (×)", o.speed)
+
+	o.intensity = gtk.NewSpinButtonWithRange(0, playback.MaxIntensity*100, 5)
+	o.intensity.SetValue(100)
+	o.intensity.SetTooltipText("Intensity in percents; values above 100% are capped")
+	o.intensity.ConnectValueChanged(func() {
+		o.player.SetIntensity(o.intensity.Value() / 100)
+	})
+	o.addRow("Intensity (%)", o.intensity)
+
+	loopLabels := make([]string, len(playback.LoopModes))
+	for i, mode := range playback.LoopModes {
+		loopLabels[i] = mode.Label()
+	}
+
+	o.loop = gtk.NewDropDownFromStrings(loopLabels)
+	o.loop.SetTooltipText("What to do at the end of the pattern or loop region")
+	o.loop.Connect("notify::selected", o.setLoop)
+	o.addRow("Loop", o.loop)
+
+	o.loopCount = gtk.NewSpinButtonWithRange(1, 999, 1)
+	o.loopCount.SetValue(2)
+	o.loopCount.SetSensitive(false)
+	o.loopCount.SetTooltipText("Number of times to play the pattern")
+	o.loopCount.ConnectValueChanged(o.setLoop)
+	o.addRow("Times", o.loopCount)
+
+	popover := gtk.NewPopover()
+	popover.SetChild(o.grid)
+
+	devices := gtk.NewButtonWithLabel("Edit…")
+	devices.SetTooltipText("Choose the devices and motors that the pattern plays on")
+	devices.ConnectClicked(func() {
+		popover.Popdown()
+		newMappingEditor(o.player).Show()
+	})
+	o.addRow("Devices", devices)
+
+	o.MenuButton = gtk.NewMenuButton()
+	o.MenuButton.SetIconName("emblem-system-symbolic")
+	o.MenuButton.SetTooltipText("Playback options")
+	o.MenuButton.SetPopover(popover)
+
+	return o
+}
+
+func (o *patternOptions) addRow(name string, widget gtk.Widgetter) {
+	label := gtk.NewLabel(name)
+	label.SetXAlign(0)
+
+	o.grid.Attach(label, 0, o.rows, 1, 1)
+	o.grid.Attach(widget, 1, o.rows, 1, 1)
+	o.rows++
+}
+
+func (o *patternOptions) setInterpolation() {
+	selected := int(o.interp.Selected())
+	if selected < 0 || selected >= len(playback.Interpolations) {
+		return
+	}
+
+	interp := playback.Interpolations[selected]
+	o.player.SetInterpolation(interp)
+
+	// Remember the choice for the next pattern.
+	err := settings.Update(func(s *settings.Settings) {
+		s.PatternInterpolation = interp.String()
+	})
+	if err != nil {
+		log.Println("cannot save interpolation:", err)
+	}
+}
+
+// setLoopMode selects the given loop mode, which sets it on the player.
+func (o *patternOptions) setLoopMode(mode playback.LoopMode) {
+	for i, m := range playback.LoopModes {
+		if m == mode {
+			o.loop.SetSelected(uint(i))
+			return
+		}
+	}
+}
+
+func (o *patternOptions) setLoop() {
+	selected := int(o.loop.Selected())
+	if selected < 0 || selected >= len(playback.LoopModes) {
+		return
+	}
+
+	mode := playback.LoopModes[selected]
+	o.loopCount.SetSensitive(mode == playback.LoopRepeat)
+	o.player.SetLoop(mode, o.loopCount.ValueAsInt())
+}
@@ -0,0 +1,192 @@
+package ui
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/intiface-gtk/internal/atomicfile"
+	"github.com/diamondburned/intiface-gtk/internal/library"
+)
+
+// patternPreview plays a pattern that's being made on a device that the user
+// picks, looping forever. The pattern is asked for every time that the
+// preview is started, so it's restarted after every change.
+type patternPreview struct {
+	*gtk.Box
+	page   *DevicePage
+	pages  []*DevicePage
+	device *gtk.DropDown
+	toggle *gtk.Button
+	player *patternPlayer
+
+	pattern func() *pattern.Pattern
+	// OnTick is called with the position on every frame while the preview
+	// is playing, and with 0 once it's stopped.
+	OnTick func(time.Duration)
+}
+
+// newPatternPreview creates a preview that plays on the device of page by
+// default.
+func newPatternPreview(page *DevicePage, pattern func() *pattern.Pattern) *patternPreview {
+	p := &patternPreview{
+		page:    page,
+		pattern: pattern,
+	}
+
+	p.pages = []*DevicePage{page}
+	labels := []string{page.deviceName()}
+	if page.stack != nil {
+		labels[0] = page.stack.deviceLabel(page.deviceIndex())
+		for _, other := range page.stack.Devices() {
+			if other != page {
+				p.pages = append(p.pages, other)
+				labels = append(labels, page.stack.deviceLabel(other.deviceIndex()))
+			}
+		}
+	}
+
+	p.device = gtk.NewDropDownFromStrings(labels)
+	p.device.SetTooltipText("Device to preview the pattern on")
+	p.device.SetHExpand(true)
+	p.device.Connect("notify::selected", p.restart)
+
+	p.toggle = gtk.NewButtonFromIconName("media-playback-start-symbolic")
+	p.toggle.ConnectClicked(func() {
+		if p.player != nil {
+			p.stop()
+		} else {
+			p.start(0)
+		}
+	})
+
+	p.Box = gtk.NewBox(gtk.OrientationHorizontal, 4)
+	p.Box.AddCSSClass("pattern-preview")
+	p.Box.Append(p.toggle)
+	p.Box.Append(p.device)
+
+	p.update()
+	return p
+}
+
+func (p *patternPreview) update() {
+	if p.player != nil {
+		p.toggle.SetIconName("media-playback-stop-symbolic")
+		p.toggle.SetTooltipText("Stop the preview")
+	} else {
+		p.toggle.SetIconName("media-playback-start-symbolic")
+		p.toggle.SetTooltipText("Preview the pattern")
+	}
+}
+
+// devicePage returns the page of the device that the pattern is previewed on.
+func (p *patternPreview) devicePage() *DevicePage {
+	if i := int(p.device.Selected()); i < len(p.pages) {
+		return p.pages[i]
+	}
+	return p.page
+}
+
+// start plays the pattern from pos.
+func (p *patternPreview) start(pos time.Duration) {
+	p.stop()
+
+	page := p.devicePage()
+	page.Load()
+
+	p.player = newPatternPlayer(page, p.pattern(), p.Box)
+	p.player.F = p.tick
+	p.player.OnHalt = p.stop
+	if pos < p.player.Duration() {
+		p.player.Seek(pos)
+	}
+	p.player.Start()
+
+	p.update()
+}
+
+// restart plays the pattern again from the same position if it's being
+// previewed, so that changes are felt right away.
+func (p *patternPreview) restart() {
+	if p.player != nil {
+		p.start(p.player.Position())
+	}
+}
+
+func (p *patternPreview) stop() {
+	if p.player == nil {
+		return
+	}
+
+	p.player.Stop()
+	p.player.page.setZeroValues()
+	p.player = nil
+
+	if p.OnTick != nil {
+		p.OnTick(0)
+	}
+
+	p.update()
+}
+
+func (p *patternPreview) tick() {
+	if p.player != nil && p.OnTick != nil {
+		p.OnTick(p.player.Position())
+	}
+}
+
+// savePatternFile asks where to save a pattern, then writes data there. done
+// is called with the status to show once it's saved, or with the error.
+func savePatternFile(parent *gtk.Window, name string, data []byte, done func(string, error)) {
+	chooser := gtk.NewFileChooserNative(
+		"Save Pattern",
+		parent,
+		gtk.FileChooserActionSave,
+		"Save", "Cancel",
+	)
+	chooser.SetModal(true)
+	chooser.SetCurrentName(name + ".pattern")
+
+	chooser.ConnectResponse(func(respID int) {
+		if respID != int(gtk.ResponseAccept) {
+			return
+		}
+
+		path := chooser.File().Path()
+		if path == "" {
+			done("", fmt.Errorf("chosen file is not local"))
+			return
+		}
+
+		go func() {
+			err := atomicfile.WriteFile(path, data)
+			glib.IdleAdd(func() {
+				done("Saved as "+filepath.Base(path)+".", err)
+			})
+		}()
+	})
+
+	chooser.Show()
+}
+
+// savePatternLibrary adds a pattern to the library. done is called with the
+// status to show once it's saved, or with the error.
+func savePatternLibrary(data []byte, meta library.Entry, done func(string, error)) {
+	go func() {
+		lib, err := patternLibrary()
+		if err == nil {
+			_, err = lib.Add(data, meta)
+		}
+		if err != nil {
+			log.Println("cannot save pattern to library:", err)
+		}
+
+		glib.IdleAdd(func() {
+			done("Saved to the library.", err)
+		})
+	}()
+}
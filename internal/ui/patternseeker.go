@@ -0,0 +1,153 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+)
+
+// patternSeeker is a seek bar for a pattern player. It also picks the A-B loop
+// region: A and B mark the region at the current position.
+type patternSeeker struct {
+	*gtk.Box
+	Scale  *gtk.Scale
+	player *patternPlayer
+
+	setA  *gtk.Button
+	setB  *gtk.Button
+	clear *gtk.Button
+
+	// pendingA is the start of a region whose end hasn't been picked yet. It
+	// is -1 if there's none.
+	pendingA time.Duration
+	updating bool
+}
+
+func newPatternSeeker(player *patternPlayer) *patternSeeker {
+	s := &patternSeeker{
+		player:   player,
+		pendingA: -1,
+	}
+
+	s.Scale = gtk.NewScaleWithRange(
+		gtk.OrientationHorizontal,
+		0, player.Duration().Seconds(), 1,
+	)
+	s.Scale.SetHExpand(true)
+	s.Scale.ConnectValueChanged(func() {
+		if !s.updating {
+			player.Seek(secsToDuration(s.Scale.Value()))
+		}
+	})
+
+	s.setA = newSeekerButton("A", "Start the loop region here")
+	s.setA.ConnectClicked(s.markA)
+
+	s.setB = newSeekerButton("B", "End the loop region here")
+	s.setB.ConnectClicked(s.markB)
+
+	s.clear = gtk.NewButtonFromIconName("edit-clear-symbolic")
+	s.clear.AddCSSClass("flat")
+	s.clear.SetTooltipText("Clear the loop region")
+	s.clear.SetSensitive(false)
+	s.clear.ConnectClicked(s.clearRegion)
+
+	s.Box = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	s.Box.AddCSSClass("pattern-seeker")
+	s.Box.Append(s.Scale)
+	s.Box.Append(s.setA)
+	s.Box.Append(s.setB)
+	s.Box.Append(s.clear)
+
+	return s
+}
+
+func newSeekerButton(label, tooltip string) *gtk.Button {
+	button := gtk.NewButtonWithLabel(label)
+	button.AddCSSClass("flat")
+	button.SetTooltipText(tooltip)
+	return button
+}
+
+// update moves the seek bar to the player's position.
+func (s *patternSeeker) update() {
+	s.updating = true
+	s.Scale.SetValue(s.player.Position().Seconds())
+	s.updating = false
+}
+
+func (s *patternSeeker) markA() {
+	a := secsToDuration(s.Scale.Value())
+
+	if s.player.HasRegion() {
+		_, b := s.player.Region()
+		s.setRegion(a, b)
+		return
+	}
+
+	s.pendingA = a
+	s.updateMarks()
+}
+
+func (s *patternSeeker) markB() {
+	b := secsToDuration(s.Scale.Value())
+
+	a := s.pendingA
+	if a < 0 {
+		a, _ = s.player.Region()
+	}
+
+	s.setRegion(a, b)
+}
+
+func (s *patternSeeker) setRegion(a, b time.Duration) {
+	if b < a {
+		a, b = b, a
+	}
+
+	s.pendingA = -1
+	s.player.SetRegion(a, b)
+	s.updateMarks()
+}
+
+func (s *patternSeeker) clearRegion() {
+	s.pendingA = -1
+	s.player.SetRegion(0, 0)
+	s.updateMarks()
+}
+
+func (s *patternSeeker) updateMarks() {
+	s.Scale.ClearMarks()
+
+	hasRegion := s.player.HasRegion()
+	s.clear.SetSensitive(hasRegion || s.pendingA >= 0)
+
+	switch {
+	case hasRegion:
+		a, b := s.player.Region()
+		s.Scale.AddMark(a.Seconds(), gtk.PosBottom, "A")
+		s.Scale.AddMark(b.Seconds(), gtk.PosBottom, "B")
+	case s.pendingA >= 0:
+		s.Scale.AddMark(s.pendingA.Seconds(), gtk.PosBottom, "A")
+	}
+}
+
+// loopText describes how many times the player has looped, or an empty string
+// if it doesn't loop.
+func loopText(player *patternPlayer) string {
+	mode, count := player.Loop()
+	pass := player.Pass()
+
+	switch mode {
+	case playback.LoopRepeat:
+		return fmt.Sprintf("%d/%d", pass+1, count)
+	case playback.LoopForever, playback.LoopPingPong:
+		if pass > 0 {
+			return fmt.Sprintf("×%d", pass+1)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,98 @@
+package ui
+
+import (
+	"github.com/diamondburned/go-lovense/pattern"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+	"github.com/diamondburned/intiface-gtk/internal/sparklines"
+)
+
+// patternTimeline draws every channel of a pattern player's pattern with a
+// playhead at the player's position. Clicking or dragging on it seeks.
+type patternTimeline struct {
+	*sparklines.Plot
+	player *patternPlayer
+
+	// OnSeek is called after the user has seeked.
+	OnSeek func()
+}
+
+func newPatternTimeline(player *patternPlayer) *patternTimeline {
+	t := &patternTimeline{player: player}
+
+	t.Plot = sparklines.NewStaticPlot(player.Duration().Seconds())
+	t.Plot.AddCSSClass("pattern-timeline")
+	t.Plot.SetRange(0, 100)
+	t.Plot.SetPadding(0, 2)
+	t.Plot.SetMinHeight(60)
+	t.Plot.SetHExpand(true)
+	t.Plot.SetNeedle(0, nil, 1)
+
+	plotPattern(t.Plot, player.Pattern(), 1.5)
+
+	drag := gtk.NewGestureDrag()
+	drag.ConnectDragBegin(func(x, _ float64) {
+		t.seekTo(x)
+	})
+	drag.ConnectDragUpdate(func(offsetX, _ float64) {
+		if x, _, ok := drag.StartPoint(); ok {
+			t.seekTo(x + offsetX)
+		}
+	})
+	t.Plot.AddController(drag)
+
+	t.update()
+	return t
+}
+
+// update moves the playhead to the player's position.
+func (t *patternTimeline) update() {
+	t.Plot.SetNeedleX(t.player.Position().Seconds())
+}
+
+// seekTo seeks to the position at x pixels from the timeline's left edge.
+func (t *patternTimeline) seekTo(x float64) {
+	width := float64(t.Plot.Width())
+	if width <= 0 {
+		return
+	}
+
+	progress := x / width
+	if progress < 0 {
+		progress = 0
+	}
+	if progress > 1 {
+		progress = 1
+	}
+
+	t.player.Seek(secsToDuration(progress * t.player.Duration().Seconds()))
+	t.update()
+
+	if t.OnSeek != nil {
+		t.OnSeek()
+	}
+}
+
+// plotPattern adds a line for every channel of p to a static plot.
+func plotPattern(plot *sparklines.Plot, p *pattern.Pattern, width float64) {
+	channels := make([][]float64, playback.Channels(p))
+	for ch := range channels {
+		channels[ch] = make([]float64, len(p.Points))
+	}
+
+	for i, point := range p.Points {
+		for ch, v := range point.Scale(p.Version) {
+			if ch < len(channels) {
+				channels[ch][i] = v * 100
+			}
+		}
+	}
+
+	for ch, values := range channels {
+		line := plot.AddLine()
+		line.Smooth = false
+		line.SetWidth(width)
+		line.SetColor(sparklines.HashColor("channel", 2<<((ch+1)*8)))
+		line.SetValues(values)
+	}
+}
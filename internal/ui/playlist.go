@@ -0,0 +1,363 @@
+package ui
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+	"github.com/diamondburned/intiface-gtk/internal/playlist"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+)
+
+// queueSaveDelay is how long a queue waits after the last change before
+// saving it.
+const queueSaveDelay = 500 * time.Millisecond
+
+var (
+	playlistMutex sync.Mutex
+	playlistsOpen *playlist.Store
+)
+
+// playlistStore returns the store of playlists. It reads the disk the first
+// time, so it should be called outside the UI thread.
+func playlistStore() (*playlist.Store, error) {
+	playlistMutex.Lock()
+	defer playlistMutex.Unlock()
+
+	if playlistsOpen != nil {
+		return playlistsOpen, nil
+	}
+
+	store, err := playlist.Open(filepath.Join(settings.ConfigDir(), playlist.FileName))
+	if err != nil {
+		return nil, err
+	}
+
+	playlistsOpen = store
+	return store, nil
+}
+
+// patternQueue plays a playlist in a pattern box. Each entry is played once
+// by its own player, then replaced by the next one. Players send through a
+// mixer, so that two of them can be crossfaded. The queue is saved as the
+// session of the box's device after every change.
+type patternQueue struct {
+	box   *patternBox
+	store *playlist.Store
+	queue *playlist.Queue
+	mixer *playback.Mixer
+
+	// source is what the player of the current entry sends to.
+	source *playback.MixerSource
+	// fading is the player of the previous entry while it fades out.
+	fading       *patternPlayer
+	fadingSource *playback.MixerSource
+
+	gap     glib.SourceHandle
+	saving  glib.SourceHandle
+	loading int // increased to drop the entry that's being loaded
+	failed  int // entries that have failed to load in a row
+}
+
+func newPatternQueue(b *patternBox, store *playlist.Store, p playlist.Playlist, index int) *patternQueue {
+	return &patternQueue{
+		box:   b,
+		store: store,
+		queue: playlist.NewQueue(p, index),
+		mixer: playback.NewMixer(patternSink{b.page.stack}),
+	}
+}
+
+// newSource returns the sink of the next player.
+func (q *patternQueue) newSource() playback.Sink {
+	q.source = q.mixer.Source()
+	return q.source
+}
+
+// attach sets up the state of the current entry to be played as part of the
+// queue and adds the queue's controls to it.
+func (q *patternQueue) attach(s *patternState) {
+	pl := q.queue.Playlist()
+
+	s.options.setLoopMode(playback.LoopOnce)
+	if pl.Transition == playlist.TransitionCrossfade {
+		d := pl.TransitionDuration()
+		s.player.SetFade(d, d)
+	}
+
+	player := s.player
+	player.OnFinish = func() { q.ended(player) }
+	player.Engine.OnFadeOut(func() {
+		glib.IdleAdd(func() { q.fadeOut(player) })
+	})
+
+	s.Box.Append(q.newControls())
+}
+
+func (q *patternQueue) newControls() *gtk.Box {
+	pl := q.queue.Playlist()
+
+	prev := gtk.NewButtonFromIconName("media-skip-backward-symbolic")
+	prev.SetTooltipText("Previous pattern")
+	prev.ConnectClicked(q.previous)
+
+	next := gtk.NewButtonFromIconName("media-skip-forward-symbolic")
+	next.SetTooltipText("Next pattern")
+	next.ConnectClicked(q.skip)
+
+	label := gtk.NewLabel(fmt.Sprintf(
+		"%d of %d · %s", q.queue.Position()+1, q.queue.Len(), pl.Name,
+	))
+	label.SetHExpand(true)
+	label.SetXAlign(0)
+	label.SetEllipsize(pango.EllipsizeEnd)
+	label.SetTooltipText(pl.Name)
+
+	shuffle := gtk.NewToggleButton()
+	shuffle.SetIconName("media-playlist-shuffle-symbolic")
+	shuffle.SetTooltipText("Shuffle")
+	shuffle.SetActive(pl.Shuffle)
+	shuffle.ConnectToggled(func() {
+		q.queue.SetShuffle(shuffle.Active())
+		label.SetText(fmt.Sprintf(
+			"%d of %d · %s", q.queue.Position()+1, q.queue.Len(), pl.Name,
+		))
+		q.save()
+	})
+
+	repeat := gtk.NewButton()
+	setRepeat := func(r playlist.Repeat) {
+		repeat.SetIconName(repeatIcon(r))
+		repeat.SetTooltipText(r.Label())
+		q.queue.SetRepeat(r)
+	}
+	setRepeat(pl.Repeat)
+	repeat.ConnectClicked(func() {
+		r := q.queue.Playlist().Repeat
+		setRepeat(playlist.Repeats[(int(r)+1)%len(playlist.Repeats)])
+		q.save()
+	})
+
+	controls := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	controls.AddCSSClass("pattern-queue")
+	controls.Append(prev)
+	controls.Append(label)
+	controls.Append(shuffle)
+	controls.Append(repeat)
+	controls.Append(next)
+
+	return controls
+}
+
+func repeatIcon(r playlist.Repeat) string {
+	switch r {
+	case playlist.RepeatOne:
+		return "media-playlist-repeat-song-symbolic"
+	case playlist.RepeatAll:
+		return "media-playlist-repeat-symbolic"
+	default:
+		return "media-playlist-consecutive-symbolic"
+	}
+}
+
+// open loads the current entry and shows it in place of the last one. If play
+// is true, then it's played right away.
+func (q *patternQueue) open(play bool) {
+	entry, ok := q.queue.Current()
+	if !ok {
+		q.box.stop()
+		q.box.setLoadErr("the playlist is empty")
+		return
+	}
+
+	q.stopGap()
+	q.loading++
+	loading := q.loading
+
+	q.save()
+
+	go func() {
+		p, err := playback.Open(entry.Path)
+
+		glib.IdleAdd(func() {
+			if q.box.queue != q || q.loading != loading {
+				return
+			}
+
+			if err != nil {
+				q.failedToOpen(entry, err, play)
+				return
+			}
+
+			q.failed = 0
+			q.show(p, entry.Name, play)
+		})
+	}()
+}
+
+// failedToOpen skips an entry that cannot be opened. The queue is stopped
+// once every entry has failed.
+func (q *patternQueue) failedToOpen(entry playlist.Entry, err error, play bool) {
+	log.Printf("cannot open %s: %v", entry.Path, err)
+
+	q.failed++
+	if q.failed < q.queue.Len() {
+		if _, ok := q.queue.Skip(); ok {
+			q.open(play)
+			return
+		}
+	}
+
+	q.box.stop()
+	q.box.setLoadErr(fmt.Sprintf("cannot open %s: %v", entry.Name, err))
+}
+
+// show replaces the state of the last entry with one for p. The player of
+// the last entry is left alone if it's fading out.
+func (q *patternQueue) show(p *pattern.Pattern, name string, play bool) {
+	b := q.box
+
+	if b.current != nil && b.current.player == q.fading {
+		b.currBox.Remove(b.current)
+		b.current = nil
+	}
+
+	b.unload()
+	b.setPattern(p, name)
+
+	if play {
+		b.current.play()
+	}
+}
+
+// fadeOut starts the next entry once the player of the current one has
+// started fading out.
+func (q *patternQueue) fadeOut(player *patternPlayer) {
+	b := q.box
+	if b.queue != q || b.current == nil || b.current.player != player || !player.IsPlaying() {
+		return
+	}
+
+	if _, ok := q.queue.Next(); !ok {
+		return
+	}
+
+	q.stopFading()
+	q.fading = player
+	q.fadingSource = q.source
+	// The player isn't shown anymore, so halting it only has to stop it.
+	player.OnHalt = nil
+
+	q.open(true)
+}
+
+// ended is called once a player has finished playing its entry.
+func (q *patternQueue) ended(player *patternPlayer) {
+	if q.box.queue != q {
+		return
+	}
+
+	if player == q.fading {
+		q.stopFading()
+		return
+	}
+
+	if q.box.current == nil || q.box.current.player != player {
+		return
+	}
+
+	if _, ok := q.queue.Next(); !ok {
+		// Go back to the start, but wait for the user to play it again.
+		q.queue = playlist.NewQueue(q.queue.Playlist(), -1)
+		q.open(false)
+		return
+	}
+
+	pl := q.queue.Playlist()
+	if pl.Transition != playlist.TransitionGap || pl.TransitionMillis <= 0 {
+		q.open(true)
+		return
+	}
+
+	q.gap = glib.TimeoutAdd(uint(pl.TransitionMillis), func() {
+		q.gap = 0
+		q.open(true)
+	})
+}
+
+// skip plays the next entry.
+func (q *patternQueue) skip() {
+	if _, ok := q.queue.Skip(); ok {
+		q.replay()
+	}
+}
+
+// previous plays the previous entry.
+func (q *patternQueue) previous() {
+	q.queue.Previous()
+	q.replay()
+}
+
+// replay opens the current entry, which is played if the last one was.
+func (q *patternQueue) replay() {
+	play := q.box.current != nil && q.box.current.player.IsStarted()
+	q.stopFading()
+	q.open(play)
+}
+
+// halt stops the queue from going on by itself, such as after the device has
+// been stopped.
+func (q *patternQueue) halt() {
+	q.stopGap()
+	q.stopFading()
+	q.loading++
+
+	if q.saving != 0 {
+		glib.SourceRemove(q.saving)
+		q.saving = 0
+	}
+}
+
+func (q *patternQueue) stopGap() {
+	if q.gap != 0 {
+		glib.SourceRemove(q.gap)
+		q.gap = 0
+	}
+}
+
+func (q *patternQueue) stopFading() {
+	if q.fading == nil {
+		return
+	}
+
+	q.fading.Stop()
+	q.fadingSource.Close()
+	q.fading = nil
+	q.fadingSource = nil
+}
+
+// save saves the queue as the session of the box's device after a short
+// delay, so that skipping through the queue only writes it once.
+func (q *patternQueue) save() {
+	if q.saving != 0 {
+		glib.SourceRemove(q.saving)
+	}
+
+	q.saving = glib.TimeoutAdd(uint(queueSaveDelay.Milliseconds()), func() bool {
+		q.saving = 0
+
+		device := q.box.page.deviceName()
+		if err := q.store.SetSession(device, q.queue.Session()); err != nil {
+			log.Println("cannot save playlist session:", err)
+		}
+
+		return false
+	})
+}
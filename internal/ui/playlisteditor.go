@@ -0,0 +1,464 @@
+package ui
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/diamondburned/adaptive"
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/intiface-gtk/internal/app"
+	"github.com/diamondburned/intiface-gtk/internal/library"
+	"github.com/diamondburned/intiface-gtk/internal/playlist"
+)
+
+// playlistEditor is a window that creates, edits and deletes playlists, and
+// queues them on a pattern box. Changes are saved right away.
+type playlistEditor struct {
+	*gtk.Window
+	box   *patternBox
+	store *playlist.Store
+
+	page   *adaptive.LoadablePage
+	body   *gtk.Box
+	stack  *gtk.Stack
+	empty  *gtk.Label
+	remove *gtk.Button
+
+	pages  map[string]*playlistPage // by stack child name
+	nextID int
+}
+
+func newPlaylistEditor(box *patternBox) *playlistEditor {
+	e := &playlistEditor{
+		box:   box,
+		pages: make(map[string]*playlistPage),
+	}
+
+	e.stack = gtk.NewStack()
+	e.stack.SetHExpand(true)
+	e.stack.SetTransitionType(gtk.StackTransitionTypeCrossfade)
+
+	sidebar := gtk.NewStackSidebar()
+	sidebar.SetStack(e.stack)
+	sidebar.SetSizeRequest(150, -1)
+
+	e.empty = gtk.NewLabel("There are no playlists yet.")
+	e.empty.AddCSSClass("dim-label")
+	e.empty.SetHExpand(true)
+	e.empty.SetWrap(true)
+
+	e.body = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	e.body.Append(sidebar)
+	e.body.Append(gtk.NewSeparator(gtk.OrientationVertical))
+	e.body.Append(e.stack)
+	e.body.Append(e.empty)
+
+	e.page = adaptive.NewLoadablePage()
+	e.page.SetVExpand(true)
+
+	add := gtk.NewButtonFromIconName("list-add-symbolic")
+	add.SetTooltipText("New playlist")
+	add.ConnectClicked(e.add)
+
+	e.remove = gtk.NewButtonFromIconName("user-trash-symbolic")
+	e.remove.SetTooltipText("Delete playlist")
+	e.remove.ConnectClicked(e.delete)
+
+	header := gtk.NewHeaderBar()
+	header.PackStart(add)
+	header.PackStart(e.remove)
+
+	e.Window = gtk.NewWindow()
+	e.Window.SetTitle("Playlists ⁠— " + box.page.deviceName())
+	e.Window.SetApplication(app.Require())
+	e.Window.SetTransientFor(app.Require().ActiveWindow())
+	e.Window.SetDefaultSize(600, 450)
+	e.Window.SetTitlebar(header)
+	e.Window.SetChild(e.page)
+
+	e.load()
+	return e
+}
+
+// load opens the store, then adds a page for every playlist.
+func (e *playlistEditor) load() {
+	e.page.SetLoading()
+
+	go func() {
+		store, err := playlistStore()
+
+		glib.IdleAdd(func() {
+			if err != nil {
+				e.page.SetError(err)
+				return
+			}
+
+			e.store = store
+			for _, p := range store.Playlists() {
+				e.addPage(p)
+			}
+
+			e.page.SetChild(e.body)
+			e.updateEmpty()
+		})
+	}()
+}
+
+func (e *playlistEditor) addPage(p playlist.Playlist) *playlistPage {
+	e.nextID++
+	id := fmt.Sprintf("playlist-%d", e.nextID)
+
+	page := newPlaylistPage(e, p)
+	e.pages[id] = page
+	e.stack.AddTitled(page, id, p.Name)
+
+	return page
+}
+
+// current returns the page of the playlist that's shown.
+func (e *playlistEditor) current() *playlistPage {
+	return e.pages[e.stack.VisibleChildName()]
+}
+
+func (e *playlistEditor) updateEmpty() {
+	hasPages := len(e.pages) > 0
+	e.stack.SetVisible(hasPages)
+	e.empty.SetVisible(!hasPages)
+	e.remove.SetSensitive(hasPages)
+}
+
+func (e *playlistEditor) add() {
+	if e.store == nil {
+		return
+	}
+
+	p := playlist.Playlist{Name: e.store.NewName("Playlist")}
+	if err := e.store.Put(p.Name, p); err != nil {
+		log.Println("cannot add playlist:", err)
+		e.page.SetError(err)
+		return
+	}
+
+	page := e.addPage(p)
+	e.stack.SetVisibleChild(page)
+	e.updateEmpty()
+}
+
+func (e *playlistEditor) delete() {
+	page := e.current()
+	if page == nil {
+		return
+	}
+
+	if err := e.store.Delete(page.name); err != nil {
+		log.Println("cannot delete playlist:", err)
+		page.setError(err)
+		return
+	}
+
+	delete(e.pages, e.stack.VisibleChildName())
+	e.stack.Remove(page)
+	e.updateEmpty()
+}
+
+// playlistPage edits one playlist.
+type playlistPage struct {
+	*gtk.Box
+	editor *playlistEditor
+	// name is the name of the playlist in the store.
+	name string
+
+	title      *gtk.Entry
+	list       *gtk.ListBox
+	shuffle    *gtk.Switch
+	repeat     *gtk.DropDown
+	transition *gtk.DropDown
+	duration   *gtk.SpinButton
+	error      *gtk.Label
+}
+
+func newPlaylistPage(e *playlistEditor, p playlist.Playlist) *playlistPage {
+	page := &playlistPage{
+		editor: e,
+		name:   p.Name,
+	}
+
+	page.title = gtk.NewEntry()
+	page.title.SetText(p.Name)
+	page.title.SetPlaceholderText("Name")
+	page.title.ConnectChanged(page.rename)
+
+	empty := gtk.NewLabel("Add patterns from files or from the library.")
+	empty.AddCSSClass("dim-label")
+	empty.SetWrap(true)
+
+	page.list = gtk.NewListBox()
+	page.list.SetSelectionMode(gtk.SelectionNone)
+	page.list.SetPlaceholder(empty)
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetVExpand(true)
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetChild(page.list)
+
+	addFiles := gtk.NewButtonWithLabel("Add Files…")
+	addFiles.ConnectClicked(page.addFiles)
+
+	addLibrary := gtk.NewButtonWithLabel("Add from Library…")
+	addLibrary.ConnectClicked(page.addFromLibrary)
+
+	adds := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	adds.Append(addFiles)
+	adds.Append(addLibrary)
+
+	grid := gtk.NewGrid()
+	grid.SetRowSpacing(4)
+	grid.SetColumnSpacing(8)
+
+	page.shuffle = gtk.NewSwitch()
+	page.shuffle.SetHAlign(gtk.AlignStart)
+	page.shuffle.SetActive(p.Shuffle)
+	page.shuffle.Connect("notify::active", page.setOptions)
+	attachOption(grid, 0, "Shuffle", page.shuffle)
+
+	repeats := make([]string, len(playlist.Repeats))
+	for i, r := range playlist.Repeats {
+		repeats[i] = r.Label()
+	}
+
+	page.repeat = gtk.NewDropDownFromStrings(repeats)
+	page.repeat.SetSelected(uint(p.Repeat))
+	page.repeat.Connect("notify::selected", page.setOptions)
+	attachOption(grid, 1, "Repeat", page.repeat)
+
+	transitions := make([]string, len(playlist.Transitions))
+	for i, t := range playlist.Transitions {
+		transitions[i] = t.Label()
+	}
+
+	page.transition = gtk.NewDropDownFromStrings(transitions)
+	page.transition.SetSelected(uint(p.Transition))
+	page.transition.SetTooltipText("What happens between two patterns")
+	page.transition.Connect("notify::selected", page.setOptions)
+	attachOption(grid, 2, "Transition", page.transition)
+
+	page.duration = gtk.NewSpinButtonWithRange(0, 30, 0.5)
+	page.duration.SetDigits(1)
+	page.duration.SetValue(p.TransitionDuration().Seconds())
+	page.duration.SetTooltipText("Length of the gap or crossfade")
+	page.duration.SetSensitive(p.Transition != playlist.TransitionNone)
+	page.duration.ConnectValueChanged(page.setOptions)
+	attachOption(grid, 3, "Transition (s)", page.duration)
+
+	page.error = gtk.NewLabel("")
+	page.error.SetXAlign(0)
+	page.error.SetWrap(true)
+	page.error.SetWrapMode(pango.WrapWordChar)
+	page.error.SetVisible(false)
+
+	play := gtk.NewButtonWithLabel("Play")
+	play.AddCSSClass("suggested-action")
+	play.SetHAlign(gtk.AlignEnd)
+	play.SetTooltipText("Queue the playlist on " + e.box.page.deviceName())
+	play.ConnectClicked(func() { page.play(-1) })
+
+	page.Box = gtk.NewBox(gtk.OrientationVertical, 8)
+	page.Box.AddCSSClass("playlist-editor")
+	page.Box.Append(page.title)
+	page.Box.Append(scroll)
+	page.Box.Append(adds)
+	page.Box.Append(grid)
+	page.Box.Append(page.error)
+	page.Box.Append(play)
+
+	page.updateList(p)
+	return page
+}
+
+// update applies f to the stored playlist and saves it. The playlist is read
+// again first, since it may have been added to from elsewhere.
+func (p *playlistPage) update(f func(pl *playlist.Playlist)) (playlist.Playlist, error) {
+	pl, ok := p.editor.store.Get(p.name)
+	if !ok {
+		pl = playlist.Playlist{Name: p.name}
+	}
+
+	f(&pl)
+
+	if err := p.editor.store.Put(p.name, pl); err != nil {
+		p.setError(err)
+		return pl, err
+	}
+
+	p.name = strings.TrimSpace(pl.Name)
+	p.error.SetVisible(false)
+	return pl, nil
+}
+
+// updateEntries applies f to the stored playlist, then lists its entries.
+func (p *playlistPage) updateEntries(f func(pl *playlist.Playlist)) {
+	if pl, err := p.update(f); err == nil {
+		p.updateList(pl)
+	}
+}
+
+func (p *playlistPage) updateList(pl playlist.Playlist) {
+	for child := p.list.FirstChild(); child != nil; child = p.list.FirstChild() {
+		p.list.Remove(child)
+	}
+
+	for i, entry := range pl.Entries {
+		p.list.Append(p.newEntry(i, len(pl.Entries), entry))
+	}
+}
+
+func (p *playlistPage) newEntry(i, n int, entry playlist.Entry) *gtk.ListBoxRow {
+	name := gtk.NewLabel(entry.Name)
+	name.SetHExpand(true)
+	name.SetXAlign(0)
+	name.SetEllipsize(pango.EllipsizeEnd)
+	name.SetTooltipText(entry.Path)
+
+	play := gtk.NewButtonFromIconName("media-playback-start-symbolic")
+	play.AddCSSClass("flat")
+	play.SetTooltipText("Play from here")
+	play.ConnectClicked(func() { p.play(i) })
+
+	up := gtk.NewButtonFromIconName("go-up-symbolic")
+	up.AddCSSClass("flat")
+	up.SetTooltipText("Move up")
+	up.SetSensitive(i > 0)
+	up.ConnectClicked(func() {
+		p.updateEntries(func(pl *playlist.Playlist) { pl.Move(i, i-1) })
+	})
+
+	down := gtk.NewButtonFromIconName("go-down-symbolic")
+	down.AddCSSClass("flat")
+	down.SetTooltipText("Move down")
+	down.SetSensitive(i < n-1)
+	down.ConnectClicked(func() {
+		p.updateEntries(func(pl *playlist.Playlist) { pl.Move(i, i+1) })
+	})
+
+	remove := gtk.NewButtonFromIconName("list-remove-symbolic")
+	remove.AddCSSClass("flat")
+	remove.SetTooltipText("Remove")
+	remove.ConnectClicked(func() {
+		p.updateEntries(func(pl *playlist.Playlist) { pl.Remove(i) })
+	})
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 2)
+	box.AddCSSClass("playlist-entry")
+	box.Append(name)
+	box.Append(play)
+	box.Append(up)
+	box.Append(down)
+	box.Append(remove)
+
+	row := gtk.NewListBoxRow()
+	row.SetChild(box)
+	return row
+}
+
+func (p *playlistPage) rename() {
+	name := p.title.Text()
+	if _, err := p.update(func(pl *playlist.Playlist) { pl.Name = name }); err != nil {
+		return
+	}
+
+	p.editor.stack.Page(p).SetTitle(p.name)
+}
+
+func (p *playlistPage) setOptions() {
+	repeat := int(p.repeat.Selected())
+	transition := int(p.transition.Selected())
+	if repeat >= len(playlist.Repeats) || transition >= len(playlist.Transitions) {
+		return
+	}
+
+	p.duration.SetSensitive(playlist.Transitions[transition] != playlist.TransitionNone)
+
+	p.update(func(pl *playlist.Playlist) {
+		pl.Shuffle = p.shuffle.Active()
+		pl.Repeat = playlist.Repeats[repeat]
+		pl.Transition = playlist.Transitions[transition]
+		pl.TransitionMillis = int64(p.duration.Value() * 1000)
+	})
+}
+
+func (p *playlistPage) addFiles() {
+	chooser := gtk.NewFileChooserNative(
+		"Add Patterns",
+		p.editor.Window,
+		gtk.FileChooserActionOpen,
+		"Add", "Cancel",
+	)
+	chooser.SetModal(true)
+	chooser.SetSelectMultiple(true)
+
+	chooser.ConnectResponse(func(respID int) {
+		if respID != int(gtk.ResponseAccept) {
+			return
+		}
+
+		var entries []playlist.Entry
+		files := chooser.Files()
+		for i := uint(0); i < files.NItems(); i++ {
+			file := &gio.File{Object: files.Item(i)}
+			path := file.Path()
+			if path == "" {
+				continue
+			}
+
+			name := filepath.Base(path)
+			entries = append(entries, playlist.Entry{
+				Path: path,
+				Name: strings.TrimSuffix(name, filepath.Ext(name)),
+			})
+		}
+
+		p.updateEntries(func(pl *playlist.Playlist) {
+			pl.Entries = append(pl.Entries, entries...)
+		})
+	})
+
+	chooser.Show()
+}
+
+func (p *playlistPage) addFromLibrary() {
+	browser := newLibraryBrowser("Add", func(lib *library.Library, entry library.Entry) bool {
+		p.updateEntries(func(pl *playlist.Playlist) {
+			pl.Entries = append(pl.Entries, playlist.Entry{
+				Path: lib.Path(entry),
+				Name: entry.Name,
+			})
+		})
+		return false
+	})
+	browser.Show()
+}
+
+// play queues the playlist on the editor's pattern box, starting at the entry
+// at index.
+func (p *playlistPage) play(index int) {
+	pl, ok := p.editor.store.Get(p.name)
+	if !ok || len(pl.Entries) == 0 {
+		return
+	}
+
+	p.editor.box.playQueue(p.editor.store, pl, index, true)
+}
+
+func (p *playlistPage) setError(err error) {
+	p.error.SetMarkup(fmt.Sprintf(
+		`<span color="red"><b>Error:</b></span> %s`,
+		html.EscapeString(err.Error()),
+	))
+	p.error.SetVisible(true)
+}
@@ -0,0 +1,226 @@
+package ui
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/intiface-gtk/internal/app"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+)
+
+// Preferences is a window that edits the persistent settings.
+type Preferences struct {
+	*gtk.Window
+	grid  *gtk.Grid
+	error *gtk.Label
+	rows  int
+
+	cliPath   *gtk.Entry
+	cliArgs   *gtk.Entry
+	port      *gtk.SpinButton
+	useServer *gtk.Switch
+	serverURL *gtk.Entry
+	sparkline *gtk.SpinButton
+	battery   *gtk.SpinButton
+	interval  *gtk.SpinButton
+	autoScan  *gtk.Switch
+	scanFor   *gtk.SpinButton
+	cachePath *gtk.Entry
+	library   *gtk.Entry
+	thumbs    *gtk.Switch
+	record    *gtk.SpinButton
+}
+
+// NewPreferences creates a new Preferences window filled with the current
+// settings.
+func NewPreferences() *Preferences {
+	p := &Preferences{}
+	s := settings.Get()
+
+	p.grid = gtk.NewGrid()
+	p.grid.AddCSSClass("preferences-grid")
+	p.grid.SetRowSpacing(6)
+	p.grid.SetColumnSpacing(12)
+
+	p.addSection("Connection")
+
+	p.cliPath = gtk.NewEntry()
+	p.cliPath.SetText(s.CLIPath)
+	p.addRow("Intiface CLI", p.cliPath)
+
+	p.cliArgs = gtk.NewEntry()
+	p.cliArgs.SetText(strings.Join(s.CLIArgs, " "))
+	p.cliArgs.SetPlaceholderText("No extra arguments")
+	p.addRow("CLI arguments", p.cliArgs)
+
+	p.port = gtk.NewSpinButtonWithRange(1, 65535, 1)
+	p.port.SetValue(float64(s.Port))
+	p.addRow("Websocket port", p.port)
+
+	p.useServer = gtk.NewSwitch()
+	p.useServer.SetHAlign(gtk.AlignStart)
+	p.useServer.SetActive(s.UseServer)
+	p.addRow("Connect to a server", p.useServer)
+
+	p.serverURL = gtk.NewEntry()
+	p.serverURL.SetText(s.ServerURL)
+	p.addRow("Server address", p.serverURL)
+
+	p.addSection("Devices")
+
+	p.sparkline = gtk.NewSpinButtonWithRange(1, 60, 0.5)
+	p.sparkline.SetDigits(1)
+	p.sparkline.SetValue(s.SparklineSeconds)
+	p.addRow("Sparkline window (s)", p.sparkline)
+
+	p.battery = gtk.NewSpinButtonWithRange(1, 600, 1)
+	p.battery.SetValue(float64(s.BatteryIntervalSeconds))
+	p.addRow("Battery poll interval (s)", p.battery)
+
+	p.interval = gtk.NewSpinButtonWithRange(10, 1000, 10)
+	p.interval.SetValue(float64(s.CommandIntervalMillis))
+	p.interval.SetTooltipText("Shortest time between two commands to a device")
+	p.addRow("Command interval (ms)", p.interval)
+
+	p.autoScan = gtk.NewSwitch()
+	p.autoScan.SetHAlign(gtk.AlignStart)
+	p.autoScan.SetActive(s.ScanOnConnect)
+	p.addRow("Scan on connect", p.autoScan)
+
+	p.scanFor = gtk.NewSpinButtonWithRange(0, 3600, 5)
+	p.scanFor.SetValue(float64(s.ScanTimeoutSeconds))
+	p.scanFor.SetTooltipText("0 scans until stopped")
+	p.addRow("Scan for (s)", p.scanFor)
+
+	p.addSection("Patterns")
+
+	p.cachePath = gtk.NewEntry()
+	p.cachePath.SetText(s.CachePath)
+	p.addRow("Pattern cache", p.cachePath)
+
+	p.library = gtk.NewEntry()
+	p.library.SetText(s.LibraryPath)
+	p.addRow("Pattern library", p.library)
+
+	p.thumbs = gtk.NewSwitch()
+	p.thumbs.SetHAlign(gtk.AlignStart)
+	p.thumbs.SetActive(s.PatternThumbnails)
+	p.thumbs.SetTooltipText("Preview cached patterns in the pattern browser")
+	p.addRow("Pattern thumbnails", p.thumbs)
+
+	p.record = gtk.NewSpinButtonWithRange(10, 1000, 10)
+	p.record.SetValue(float64(s.RecordIntervalMillis))
+	p.record.SetTooltipText("Time between two points of recorded patterns")
+	p.addRow("Recording interval (ms)", p.record)
+
+	note := gtk.NewLabel("Connection changes apply on the next connection.")
+	note.SetXAlign(0)
+	note.SetWrap(true)
+	note.AddCSSClass("dim-label")
+
+	p.error = gtk.NewLabel("")
+	p.error.SetXAlign(0)
+	p.error.SetWrap(true)
+	p.error.SetWrapMode(pango.WrapWordChar)
+	p.error.SetVisible(false)
+	p.error.AddCSSClass("error-label")
+
+	box := gtk.NewBox(gtk.OrientationVertical, 8)
+	box.AddCSSClass("preferences")
+	box.Append(p.grid)
+	box.Append(note)
+	box.Append(p.error)
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetPropagateNaturalHeight(true)
+	scroll.SetChild(box)
+
+	cancel := gtk.NewButtonWithLabel("Cancel")
+	cancel.ConnectClicked(func() { p.Window.Destroy() })
+
+	save := gtk.NewButtonWithLabel("Save")
+	save.AddCSSClass("suggested-action")
+	save.ConnectClicked(p.save)
+
+	header := gtk.NewHeaderBar()
+	header.SetShowTitleButtons(false)
+	header.PackStart(cancel)
+	header.PackEnd(save)
+
+	p.Window = gtk.NewWindow()
+	p.Window.SetTitle("Preferences ⁠— Intiface")
+	p.Window.SetApplication(app.Require())
+	p.Window.SetTransientFor(app.Require().ActiveWindow())
+	p.Window.SetDefaultSize(400, -1)
+	p.Window.SetTitlebar(header)
+	p.Window.SetChild(scroll)
+
+	return p
+}
+
+func (p *Preferences) addSection(title string) {
+	label := gtk.NewLabel("")
+	label.SetXAlign(0)
+	label.SetMarkup("<b>" + html.EscapeString(title) + "</b>")
+	label.AddCSSClass("preferences-section")
+
+	p.grid.Attach(label, 0, p.rows, 2, 1)
+	p.rows++
+}
+
+func (p *Preferences) addRow(name string, widget gtk.Widgetter) {
+	label := gtk.NewLabel(name)
+	label.SetXAlign(0)
+
+	w := gtk.BaseWidget(widget)
+	w.SetHExpand(true)
+
+	p.grid.Attach(label, 0, p.rows, 1, 1)
+	p.grid.Attach(widget, 1, p.rows, 1, 1)
+	p.rows++
+}
+
+func (p *Preferences) setError(err error) {
+	p.error.SetMarkup(fmt.Sprintf(
+		`<span color="red"><b>Error:</b></span> %s`,
+		html.EscapeString(err.Error()),
+	))
+	p.error.SetVisible(true)
+}
+
+func (p *Preferences) save() {
+	serverURL := strings.TrimSpace(p.serverURL.Text())
+	if err := settings.ValidateServerURL(serverURL); err != nil {
+		p.setError(err)
+		return
+	}
+
+	err := settings.Update(func(s *settings.Settings) {
+		s.CLIPath = strings.TrimSpace(p.cliPath.Text())
+		s.CLIArgs = strings.Fields(p.cliArgs.Text())
+		s.Port = p.port.ValueAsInt()
+		s.UseServer = p.useServer.Active()
+		s.ServerURL = serverURL
+		s.SparklineSeconds = p.sparkline.Value()
+		s.BatteryIntervalSeconds = p.battery.ValueAsInt()
+		s.CommandIntervalMillis = p.interval.ValueAsInt()
+		s.ScanOnConnect = p.autoScan.Active()
+		s.ScanTimeoutSeconds = p.scanFor.ValueAsInt()
+		s.CachePath = strings.TrimSpace(p.cachePath.Text())
+		s.LibraryPath = strings.TrimSpace(p.library.Text())
+		s.PatternThumbnails = p.thumbs.Active()
+		s.RecordIntervalMillis = p.record.ValueAsInt()
+	})
+	if err != nil {
+		log.Println("cannot save settings:", err)
+		p.setError(err)
+		return
+	}
+
+	p.Window.Destroy()
+}
@@ -0,0 +1,403 @@
+package ui
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"time"
+
+	"github.com/diamondburned/go-buttplug"
+	"github.com/diamondburned/go-lovense/pattern"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/intiface-gtk/internal/app"
+	"github.com/diamondburned/intiface-gtk/internal/library"
+	"github.com/diamondburned/intiface-gtk/internal/patternedit"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+	"github.com/diamondburned/intiface-gtk/internal/scheduler"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+	"github.com/diamondburned/intiface-gtk/internal/sparklines"
+)
+
+// patternRecorder is a toggle button that records the values sent to a
+// device page's motors into a pattern, wherever they come from. Every actuator
+// is a channel of the pattern. Once the recording is stopped, it's shown in a
+// recordingWindow.
+type patternRecorder struct {
+	*gtk.ToggleButton
+	page *DevicePage
+
+	channels []recordedMotor
+	recorder *playback.Recorder
+	started  time.Time
+	tick     glib.SourceHandle
+}
+
+func newPatternRecorder(page *DevicePage) *patternRecorder {
+	r := &patternRecorder{page: page}
+
+	r.ToggleButton = gtk.NewToggleButton()
+	r.ToggleButton.AddCSSClass("pattern-recorder")
+	r.ToggleButton.ConnectClicked(func() {
+		if r.ToggleButton.Active() {
+			r.start()
+		} else {
+			r.stop()
+		}
+	})
+
+	// The page is gone once its device is removed or the stack is replaced,
+	// so the recording can't be replayed on it anymore.
+	r.ToggleButton.ConnectUnrealize(r.discard)
+
+	r.update()
+	return r
+}
+
+// recordedMotor is the motor that a channel of the recording is sampled from.
+type recordedMotor struct {
+	kind  buttplug.MessageType
+	motor int
+}
+
+// update shows whether the recorder is recording.
+func (r *patternRecorder) update() {
+	if r.recorder == nil {
+		r.ToggleButton.SetIconName("media-record-symbolic")
+		r.ToggleButton.SetTooltipText("Record the motor controls into a pattern")
+		r.ToggleButton.RemoveCSSClass("destructive-action")
+		return
+	}
+
+	r.ToggleButton.SetLabel(fmtDuration(r.recorder.Duration()))
+	r.ToggleButton.SetTooltipText("Stop recording")
+	r.ToggleButton.AddCSSClass("destructive-action")
+}
+
+func (r *patternRecorder) start() {
+	motors := r.page.motors()
+
+	r.channels = nil
+	for _, actuator := range playback.Actuators {
+		kind := actuatorMessage(actuator)
+		for _, motor := range motors[actuator] {
+			r.channels = append(r.channels, recordedMotor{kind, motor})
+		}
+	}
+
+	if len(r.channels) == 0 {
+		r.ToggleButton.SetActive(false)
+		return
+	}
+
+	interval := settings.Get().RecordInterval()
+
+	r.recorder = playback.NewRecorder(playback.Features(motors), interval)
+	r.started = time.Now()
+	r.sample()
+
+	r.tick = glib.TimeoutAdd(uint(interval/time.Millisecond), func() bool {
+		r.sample()
+		return true
+	})
+}
+
+// sample records the values that were last sent to the motors. Patterns
+// have no direction, so rotations are recorded by their speed.
+func (r *patternRecorder) sample() {
+	sent := r.page.output.Sent()
+
+	values := make([]float64, len(r.channels))
+	for ch, channel := range r.channels {
+		values[ch] = math.Abs(sentValue(sent, channel.kind, channel.motor))
+	}
+
+	r.recorder.Sample(time.Since(r.started), values)
+	r.update()
+}
+
+func (r *patternRecorder) stop() {
+	if r.recorder == nil {
+		return
+	}
+
+	glib.SourceRemove(r.tick)
+	r.tick = 0
+
+	r.sample()
+	p := r.recorder.Pattern()
+
+	r.recorder = nil
+	r.channels = nil
+	r.update()
+
+	newRecordingWindow(r.page, p).Show()
+}
+
+// discard stops recording without showing the recording.
+func (r *patternRecorder) discard() {
+	if r.recorder == nil {
+		return
+	}
+
+	glib.SourceRemove(r.tick)
+	r.tick = 0
+
+	r.recorder = nil
+	r.channels = nil
+	r.ToggleButton.SetActive(false)
+	r.update()
+}
+
+// sentValue returns the value within [0, 1] of a motor in a batch that was
+// sent, or 0 if the motor isn't in it. Rotations are negative when they're
+// counter-clockwise, and linear motors give the position that they move to.
+func sentValue(batch scheduler.Batch, kind buttplug.MessageType, motor int) float64 {
+	switch kind {
+	case buttplug.VibrateCmdMessage:
+		return batch.Vibrate[motor]
+	case buttplug.RotateCmdMessage:
+		rotation := batch.Rotate[motor]
+		if !rotation.Clockwise {
+			return -rotation.Speed
+		}
+		return rotation.Speed
+	case buttplug.LinearCmdMessage:
+		return batch.Linear[motor].Position
+	default:
+		return 0
+	}
+}
+
+// recordingWindow shows a recorded pattern. It can be trimmed, replayed on its
+// device, and saved to a file or to the library.
+type recordingWindow struct {
+	*gtk.Window
+	page      *DevicePage
+	recording *pattern.Pattern
+
+	name    *gtk.Entry
+	start   *gtk.SpinButton
+	end     *gtk.SpinButton
+	preview *gtk.Box
+	info    *gtk.Label
+	status  *gtk.Label
+	play    *gtk.Button
+	edit    *gtk.Button
+}
+
+func newRecordingWindow(page *DevicePage, p *pattern.Pattern) *recordingWindow {
+	w := &recordingWindow{
+		page:      page,
+		recording: p,
+	}
+
+	w.name = gtk.NewEntry()
+	w.name.SetText("Recording " + time.Now().Format("2006-01-02 15:04"))
+	w.name.SetPlaceholderText("Name")
+
+	w.preview = gtk.NewBox(gtk.OrientationVertical, 0)
+	w.preview.AddCSSClass("recording-preview")
+
+	w.info = gtk.NewLabel("")
+	w.info.SetXAlign(0)
+	w.info.AddCSSClass("dim-label")
+
+	duration := patternDuration(p).Seconds()
+	step := p.Interval.Seconds()
+
+	w.start = gtk.NewSpinButtonWithRange(0, duration, step)
+	w.start.SetDigits(2)
+	w.start.SetValue(0)
+	w.start.SetTooltipText("Drop everything before this time")
+	w.start.ConnectValueChanged(w.update)
+
+	w.end = gtk.NewSpinButtonWithRange(0, duration, step)
+	w.end.SetDigits(2)
+	w.end.SetValue(duration)
+	w.end.SetTooltipText("Drop everything after this time")
+	w.end.ConnectValueChanged(w.update)
+
+	grid := gtk.NewGrid()
+	grid.SetRowSpacing(4)
+	grid.SetColumnSpacing(8)
+	attachOption(grid, 0, "Name", w.name)
+	attachOption(grid, 1, "Start (s)", w.start)
+	attachOption(grid, 2, "End (s)", w.end)
+	w.name.SetHExpand(true)
+
+	w.status = gtk.NewLabel("")
+	w.status.SetXAlign(0)
+	w.status.SetWrap(true)
+	w.status.SetWrapMode(pango.WrapWordChar)
+	w.status.SetVisible(false)
+
+	w.play = gtk.NewButtonWithLabel("Play")
+	w.play.SetTooltipText("Open the recording in the pattern player")
+	w.play.ConnectClicked(w.playRecording)
+
+	w.edit = gtk.NewButtonWithLabel("Edit")
+	w.edit.SetTooltipText("Open the recording in the pattern editor")
+	w.edit.ConnectClicked(w.editRecording)
+
+	saveFile := gtk.NewButtonWithLabel("Save As…")
+	saveFile.ConnectClicked(w.saveFile)
+
+	saveLibrary := gtk.NewButtonWithLabel("Save to Library")
+	saveLibrary.AddCSSClass("suggested-action")
+	saveLibrary.ConnectClicked(w.saveLibrary)
+
+	actions := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	actions.SetHAlign(gtk.AlignEnd)
+	actions.Append(w.play)
+	actions.Append(w.edit)
+	actions.Append(saveFile)
+	actions.Append(saveLibrary)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 8)
+	box.AddCSSClass("recording-window")
+	box.Append(w.preview)
+	box.Append(w.info)
+	box.Append(grid)
+	box.Append(w.status)
+	box.Append(actions)
+
+	discard := gtk.NewButtonWithLabel("Discard")
+	discard.ConnectClicked(func() { w.Window.Destroy() })
+
+	header := gtk.NewHeaderBar()
+	header.SetShowTitleButtons(false)
+	header.PackStart(discard)
+
+	w.Window = gtk.NewWindow()
+	w.Window.SetTitle("Recording ⁠— " + page.deviceName())
+	w.Window.SetApplication(app.Require())
+	w.Window.SetTransientFor(app.Require().ActiveWindow())
+	w.Window.SetDefaultSize(450, -1)
+	w.Window.SetTitlebar(header)
+	w.Window.SetChild(box)
+
+	// The recording can still be saved once its device is gone, but not
+	// played on it anymore.
+	gone := page.ConnectUnrealize(w.deviceGone)
+	w.Window.ConnectDestroy(func() { page.HandlerDisconnect(gone) })
+
+	w.update()
+	return w
+}
+
+func (w *recordingWindow) deviceGone() {
+	for _, button := range []*gtk.Button{w.play, w.edit} {
+		button.SetSensitive(false)
+		button.SetTooltipText("The device is no longer connected")
+	}
+}
+
+// trimmed returns the recording between the start and end times.
+func (w *recordingWindow) trimmed() (*pattern.Pattern, error) {
+	return playback.Trim(w.recording, secsToDuration(w.start.Value()), secsToDuration(w.end.Value()))
+}
+
+// update previews the trimmed recording.
+func (w *recordingWindow) update() {
+	for child := w.preview.FirstChild(); child != nil; child = w.preview.FirstChild() {
+		w.preview.Remove(child)
+	}
+
+	p, err := w.trimmed()
+	if err != nil {
+		w.info.SetText("")
+		w.setError(err)
+		return
+	}
+
+	w.status.SetVisible(false)
+
+	plot := sparklines.NewStaticPlot(patternDuration(p).Seconds())
+	plot.SetRange(0, 100)
+	plot.SetPadding(0, 2)
+	plot.SetMinHeight(80)
+	plot.SetHExpand(true)
+	plotPattern(plot, p, 1.5)
+	w.preview.Append(plot)
+
+	w.info.SetText(fmt.Sprintf(
+		"%s · %s · %d ms",
+		fmtDuration(patternDuration(p)), stringifyFeatures(p.Features), p.Interval.Milliseconds(),
+	))
+}
+
+func (w *recordingWindow) playRecording() {
+	p, err := w.trimmed()
+	if err != nil {
+		w.setError(err)
+		return
+	}
+
+	w.page.patterns.stop()
+	w.page.patterns.setPattern(p, w.name.Text())
+}
+
+func (w *recordingWindow) editRecording() {
+	p, err := w.trimmed()
+	if err != nil {
+		w.setError(err)
+		return
+	}
+
+	newPatternEditorOf(w.page, patternedit.Open(p), w.name.Text()).Show()
+}
+
+func (w *recordingWindow) saveFile() {
+	if data, ok := w.marshal(); ok {
+		savePatternFile(w.Window, w.name.Text(), data, w.saved)
+	}
+}
+
+func (w *recordingWindow) saveLibrary() {
+	data, ok := w.marshal()
+	if !ok {
+		return
+	}
+
+	savePatternLibrary(data, library.Entry{
+		Name: w.name.Text(),
+		Tags: []string{"recording"},
+	}, w.saved)
+}
+
+func (w *recordingWindow) saved(status string, err error) {
+	if err != nil {
+		w.setError(err)
+		return
+	}
+	w.setStatus(status)
+}
+
+// marshal encodes the trimmed recording. Errors are shown.
+func (w *recordingWindow) marshal() ([]byte, bool) {
+	p, err := w.trimmed()
+	if err == nil {
+		var data []byte
+		if data, err = playback.Marshal(p); err == nil {
+			return data, true
+		}
+	}
+
+	w.setError(err)
+	return nil, false
+}
+
+func (w *recordingWindow) setStatus(status string) {
+	w.status.SetText(status)
+	w.status.SetVisible(true)
+}
+
+func (w *recordingWindow) setError(err error) {
+	w.status.SetMarkup(fmt.Sprintf(
+		`<span color="red"><b>Error:</b></span> %s`,
+		html.EscapeString(err.Error()),
+	))
+	w.status.SetVisible(true)
+}
@@ -0,0 +1,157 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/diamondburned/go-buttplug"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+	"github.com/diamondburned/intiface-gtk/internal/shutdown"
+)
+
+const scanCommandTimeout = 5 * time.Second
+
+// ScanButton is a toggle button that starts and stops scanning for devices. A
+// spinner is shown while the server is scanning.
+type ScanButton struct {
+	*gtk.Box
+	Manager *Manager
+
+	toggle  *gtk.ToggleButton
+	spinner *gtk.Spinner
+
+	timeout  glib.SourceHandle
+	scanning bool
+	updating bool
+}
+
+// NewScanButton creates a new ScanButton.
+func NewScanButton(manager *Manager) *ScanButton {
+	s := &ScanButton{Manager: manager}
+
+	s.spinner = gtk.NewSpinner()
+	s.spinner.SetVisible(false)
+
+	s.toggle = gtk.NewToggleButton()
+	s.toggle.SetIconName("bluetooth-symbolic")
+	s.toggle.SetTooltipText("Scan for devices")
+	s.toggle.ConnectToggled(func() {
+		if s.updating {
+			return
+		}
+		if s.toggle.Active() {
+			s.Start()
+		} else {
+			s.Stop()
+		}
+	})
+
+	s.Box = gtk.NewBox(gtk.OrientationHorizontal, 4)
+	s.Box.AddCSSClass("scan-button")
+	s.Box.Append(s.spinner)
+	s.Box.Append(s.toggle)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Box.ConnectDestroy(func() {
+		cancel()
+		s.removeTimeout()
+	})
+
+	ch := manager.Broadcaster.Listen()
+	go func() {
+		defer shutdown.Recover()
+
+		for ev := range ch {
+			// The Broadcaster cannot unsubscribe, so keep draining the
+			// channel after the button is gone, or it would block.
+			if ctx.Err() != nil {
+				continue
+			}
+
+			switch ev.(type) {
+			case *buttplug.ScanningFinished:
+				glib.IdleAdd(func() { s.setScanning(false) })
+			}
+		}
+	}()
+
+	return s
+}
+
+// IsScanning returns true if the server is currently scanning.
+func (s *ScanButton) IsScanning() bool {
+	return s.scanning
+}
+
+// Start asks the server to start scanning for devices. If the scan timeout
+// setting is non-zero, then scanning is stopped after that long.
+func (s *ScanButton) Start() {
+	s.removeTimeout()
+	s.setScanning(true)
+
+	if secs := settings.Get().ScanTimeoutSeconds; secs > 0 {
+		s.timeout = glib.TimeoutSecondsAdd(uint(secs), func() bool {
+			s.timeout = 0
+			s.Stop()
+			return false
+		})
+	}
+
+	s.send(&buttplug.StartScanning{}, "Scanning failed")
+}
+
+// Stop asks the server to stop scanning for devices.
+func (s *ScanButton) Stop() {
+	s.setScanning(false)
+	s.send(&buttplug.StopScanning{}, "Cannot stop scanning")
+}
+
+// send sends msg to the server. If it fails, the error is shown after the
+// given message.
+func (s *ScanButton) send(msg buttplug.Message, failed string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), scanCommandTimeout)
+		defer cancel()
+
+		if _, err := s.Manager.Command(ctx, msg); err != nil {
+			log.Printf("cannot send %s: %v", msg.MessageType(), err)
+			glib.IdleAdd(func() {
+				s.setScanning(false)
+				s.toggle.SetTooltipText(fmt.Sprintf("%s: %v", failed, err))
+			})
+		}
+	}()
+}
+
+func (s *ScanButton) removeTimeout() {
+	if s.timeout != 0 {
+		glib.SourceRemove(s.timeout)
+		s.timeout = 0
+	}
+}
+
+func (s *ScanButton) setScanning(scanning bool) {
+	if !scanning {
+		s.removeTimeout()
+	}
+
+	s.scanning = scanning
+
+	s.updating = true
+	s.toggle.SetActive(scanning)
+	s.updating = false
+
+	if scanning {
+		s.toggle.SetTooltipText("Stop scanning")
+		s.spinner.SetVisible(true)
+		s.spinner.Start()
+	} else {
+		s.toggle.SetTooltipText("Scan for devices")
+		s.spinner.SetVisible(false)
+		s.spinner.Stop()
+	}
+}
@@ -0,0 +1,394 @@
+package ui
+
+import (
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/diamondburned/go-lovense/pattern"
+	"github.com/diamondburned/gotk4/pkg/gdk/v4"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/intiface-gtk/internal/app"
+	"github.com/diamondburned/intiface-gtk/internal/library"
+	"github.com/diamondburned/intiface-gtk/internal/patternedit"
+	"github.com/diamondburned/intiface-gtk/internal/playback"
+)
+
+// sequencerLevels lists the values that a cell goes through when it's
+// clicked.
+var sequencerLevels = []float64{0, 0.25, 0.5, 0.75, 1}
+
+// stepSequencer is a window that makes a pattern out of a grid of steps, with
+// a row for every actuator. Clicking a cell raises its intensity, and
+// clicking it with the secondary button clears it. The steps are played at a
+// tempo, and they can be previewed on any device.
+type stepSequencer struct {
+	*gtk.Window
+	page *DevicePage
+	seq  *patternedit.Sequence
+
+	name    *gtk.Entry
+	bpm     *gtk.SpinButton
+	perBeat *gtk.SpinButton
+	steps   *gtk.SpinButton
+	grid    *gtk.Grid
+	cells   [][]*sequencerCell // by row, then by step
+	current int                // step shown as playing, or -1
+	info    *gtk.Label
+	status  *gtk.Label
+	preview *patternPreview
+
+	// updating is true while the widgets are set from the sequence, so that
+	// their handlers don't change it.
+	updating bool
+}
+
+// newStepSequencer creates a sequencer of 16 empty steps, with a row for
+// every actuator of the page's device.
+func newStepSequencer(page *DevicePage) *stepSequencer {
+	s := &stepSequencer{
+		page:    page,
+		seq:     patternedit.NewSequence(playback.Features(page.motors()), 16),
+		current: -1,
+	}
+
+	s.name = gtk.NewEntry()
+	s.name.SetText("New Sequence")
+	s.name.SetPlaceholderText("Name")
+	s.name.SetHExpand(true)
+	s.name.ConnectChanged(s.updateTitle)
+
+	s.bpm = gtk.NewSpinButtonWithRange(patternedit.MinBPM, patternedit.MaxBPM, 1)
+	s.bpm.SetTooltipText("Tempo in beats per minute")
+	s.bpm.ConnectValueChanged(func() {
+		if !s.updating {
+			s.seq.BPM = s.bpm.Value()
+			s.changed()
+		}
+	})
+
+	s.perBeat = gtk.NewSpinButtonWithRange(1, 8, 1)
+	s.perBeat.SetTooltipText("Number of steps in a beat")
+	s.perBeat.ConnectValueChanged(func() {
+		if !s.updating {
+			s.seq.StepsPerBeat = s.perBeat.ValueAsInt()
+			s.rebuild()
+		}
+	})
+
+	s.steps = gtk.NewSpinButtonWithRange(1, 256, 1)
+	s.steps.SetTooltipText("Number of steps; steps are added or removed at the end")
+	s.steps.ConnectValueChanged(func() {
+		if !s.updating {
+			s.seq.SetSteps(s.steps.ValueAsInt())
+			s.rebuild()
+		}
+	})
+
+	options := gtk.NewGrid()
+	options.SetRowSpacing(4)
+	options.SetColumnSpacing(8)
+	attachOption(options, 0, "Name", s.name)
+	attachOption(options, 1, "Tempo (BPM)", s.bpm)
+	attachOption(options, 2, "Steps per beat", s.perBeat)
+	attachOption(options, 3, "Length (steps)", s.steps)
+
+	s.grid = gtk.NewGrid()
+	s.grid.AddCSSClass("sequencer-grid")
+	s.grid.SetRowSpacing(4)
+	s.grid.SetColumnSpacing(2)
+
+	gridScroll := gtk.NewScrolledWindow()
+	gridScroll.SetPolicy(gtk.PolicyAutomatic, gtk.PolicyAutomatic)
+	gridScroll.SetPropagateNaturalHeight(true)
+	gridScroll.SetMaxContentHeight(400)
+	gridScroll.SetVExpand(true)
+	gridScroll.SetChild(s.grid)
+
+	addRow := gtk.NewButtonWithLabel("Add Row")
+	addRow.SetHAlign(gtk.AlignStart)
+	addRow.ConnectClicked(func() {
+		s.seq.AddRow(pattern.Vibrate)
+		s.rebuild()
+	})
+
+	s.preview = newPatternPreview(page, s.seq.Pattern)
+	s.preview.OnTick = s.tick
+
+	edit := gtk.NewButtonWithLabel("Edit")
+	edit.SetTooltipText("Open the steps in the pattern editor")
+	edit.ConnectClicked(s.edit)
+
+	openBtn := gtk.NewButtonWithLabel("Open in Player")
+	openBtn.SetTooltipText("Play the pattern on " + page.deviceName())
+	openBtn.ConnectClicked(s.openInPlayer)
+
+	s.preview.Append(edit)
+	s.preview.Append(openBtn)
+
+	s.info = gtk.NewLabel("")
+	s.info.SetXAlign(0)
+	s.info.AddCSSClass("dim-label")
+
+	s.status = gtk.NewLabel("")
+	s.status.SetXAlign(0)
+	s.status.SetWrap(true)
+	s.status.SetWrapMode(pango.WrapWordChar)
+	s.status.SetVisible(false)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 8)
+	box.AddCSSClass("step-sequencer")
+	box.Append(options)
+	box.Append(gridScroll)
+	box.Append(addRow)
+	box.Append(s.preview)
+	box.Append(s.info)
+	box.Append(s.status)
+
+	saveFile := gtk.NewButtonWithLabel("Save As…")
+	saveFile.ConnectClicked(s.saveFile)
+
+	saveLibrary := gtk.NewButtonWithLabel("Save to Library")
+	saveLibrary.AddCSSClass("suggested-action")
+	saveLibrary.ConnectClicked(s.saveLibrary)
+
+	header := gtk.NewHeaderBar()
+	header.PackEnd(saveLibrary)
+	header.PackEnd(saveFile)
+
+	s.Window = gtk.NewWindow()
+	s.Window.SetApplication(app.Require())
+	s.Window.SetTransientFor(app.Require().ActiveWindow())
+	s.Window.SetDefaultSize(600, -1)
+	s.Window.SetTitlebar(header)
+	s.Window.SetChild(box)
+	s.Window.ConnectCloseRequest(func() bool {
+		s.preview.stop()
+		return false
+	})
+
+	s.rebuild()
+	return s
+}
+
+func (s *stepSequencer) updateTitle() {
+	s.Window.SetTitle("Step Sequencer ⁠— " + s.name.Text())
+}
+
+// rebuild shows the whole sequence after its rows or steps have changed.
+func (s *stepSequencer) rebuild() {
+	s.updating = true
+	defer func() { s.updating = false }()
+
+	s.bpm.SetValue(s.seq.BPM)
+	s.perBeat.SetValue(float64(s.seq.StepsPerBeat))
+	s.steps.SetValue(float64(s.seq.Steps()))
+
+	for child := s.grid.FirstChild(); child != nil; child = s.grid.FirstChild() {
+		s.grid.Remove(child)
+	}
+
+	s.current = -1
+	s.cells = make([][]*sequencerCell, s.seq.Rows())
+	for row := range s.cells {
+		s.grid.Attach(s.newRowHeader(row), 0, row, 1, 1)
+
+		s.cells[row] = make([]*sequencerCell, s.seq.Steps())
+		for step := range s.cells[row] {
+			cell := newSequencerCell(s, row, step)
+			s.cells[row][step] = cell
+			s.grid.Attach(cell, step+1, row, 1, 1)
+		}
+	}
+
+	s.changed()
+}
+
+// newRowHeader creates the widgets that set the feature of a row and remove
+// it.
+func (s *stepSequencer) newRowHeader(row int) *gtk.Box {
+	feature := newFeatureDropDown(s.seq.Features[row], func(f pattern.Feature) {
+		if !s.updating {
+			s.seq.Features[row] = f
+			s.changed()
+		}
+	})
+	feature.SetTooltipText("What the row drives")
+
+	remove := gtk.NewButtonFromIconName("list-remove-symbolic")
+	remove.SetTooltipText("Remove the row")
+	remove.SetSensitive(s.seq.Rows() > 1)
+	remove.ConnectClicked(func() {
+		s.seq.RemoveRow(row)
+		s.rebuild()
+	})
+
+	header := gtk.NewBox(gtk.OrientationHorizontal, 2)
+	header.AddCSSClass("sequencer-row-header")
+	header.SetVAlign(gtk.AlignCenter)
+	header.Append(feature)
+	header.Append(remove)
+
+	return header
+}
+
+// changed shows the length of the sequence and previews it again.
+func (s *stepSequencer) changed() {
+	p := s.seq.Pattern()
+
+	s.info.SetText(fmt.Sprintf(
+		"%s · %d ms per step · %s",
+		fmtDuration(patternDuration(p)), p.Interval.Milliseconds(), stringifyFeatures(p.Features),
+	))
+
+	s.status.SetVisible(false)
+	s.updateTitle()
+	s.preview.restart()
+}
+
+// tick shows the step that's playing.
+func (s *stepSequencer) tick(pos time.Duration) {
+	step := -1
+	if s.preview.player != nil {
+		step = int(pos / s.seq.StepDuration())
+	}
+
+	if step == s.current {
+		return
+	}
+
+	s.setCurrent(s.current, false)
+	s.setCurrent(step, true)
+	s.current = step
+}
+
+func (s *stepSequencer) setCurrent(step int, current bool) {
+	if step < 0 || step >= s.seq.Steps() {
+		return
+	}
+
+	for _, cells := range s.cells {
+		if current {
+			cells[step].AddCSSClass("sequencer-cell-current")
+		} else {
+			cells[step].RemoveCSSClass("sequencer-cell-current")
+		}
+	}
+}
+
+func (s *stepSequencer) edit() {
+	doc := patternedit.Open(s.seq.Pattern())
+	newPatternEditorOf(s.page, doc, s.name.Text()).Show()
+}
+
+func (s *stepSequencer) openInPlayer() {
+	if s.page.patterns == nil {
+		return
+	}
+
+	s.preview.stop()
+	s.page.patterns.stop()
+	s.page.patterns.setPattern(s.seq.Pattern(), s.name.Text())
+}
+
+func (s *stepSequencer) saveFile() {
+	if data, ok := s.marshal(); ok {
+		savePatternFile(s.Window, s.name.Text(), data, s.saved)
+	}
+}
+
+func (s *stepSequencer) saveLibrary() {
+	if data, ok := s.marshal(); ok {
+		savePatternLibrary(data, library.Entry{Name: s.name.Text()}, s.saved)
+	}
+}
+
+// marshal encodes the sequence as a pattern. Errors are shown.
+func (s *stepSequencer) marshal() ([]byte, bool) {
+	data, err := playback.Marshal(s.seq.Pattern())
+	if err != nil {
+		s.setError(err)
+		return nil, false
+	}
+	return data, true
+}
+
+func (s *stepSequencer) saved(status string, err error) {
+	if err != nil {
+		s.setError(err)
+		return
+	}
+	s.status.SetText(status)
+	s.status.SetVisible(true)
+}
+
+func (s *stepSequencer) setError(err error) {
+	s.status.SetMarkup(fmt.Sprintf(
+		`<span color="red"><b>Error:</b></span> %s`,
+		html.EscapeString(err.Error()),
+	))
+	s.status.SetVisible(true)
+}
+
+// sequencerCell is a step of a row in a step sequencer. It shows its value as
+// a bar.
+type sequencerCell struct {
+	*gtk.Button
+	seq *stepSequencer
+	row int
+	bar *gtk.LevelBar
+}
+
+func newSequencerCell(s *stepSequencer, row, step int) *sequencerCell {
+	c := &sequencerCell{seq: s, row: row}
+
+	c.bar = gtk.NewLevelBarForInterval(0, 1)
+	c.bar.SetOrientation(gtk.OrientationVertical)
+	c.bar.SetInverted(true)
+	c.bar.SetMode(gtk.LevelBarModeContinuous)
+
+	c.Button = gtk.NewButton()
+	c.Button.AddCSSClass("sequencer-cell")
+	c.Button.SetChild(c.bar)
+	if step%s.seq.StepsPerBeat == 0 {
+		c.Button.AddCSSClass("sequencer-cell-beat")
+	}
+
+	c.Button.ConnectClicked(func() {
+		c.set(step, nextLevel(s.seq.Cells[row][step]))
+	})
+
+	clear := gtk.NewGestureClick()
+	clear.SetButton(gdk.BUTTON_SECONDARY)
+	clear.ConnectPressed(func(int, float64, float64) {
+		c.set(step, 0)
+	})
+	c.Button.AddController(clear)
+
+	c.update(step)
+	return c
+}
+
+func (c *sequencerCell) set(step int, v float64) {
+	c.seq.seq.Cells[c.row][step] = v
+	c.update(step)
+	c.seq.changed()
+}
+
+func (c *sequencerCell) update(step int) {
+	v := c.seq.seq.Cells[c.row][step]
+	c.bar.SetValue(v)
+	c.Button.SetTooltipText(fmt.Sprintf("Step %d · %.0f%%", step+1, v*100))
+}
+
+// nextLevel returns the level that follows v, going back to 0 after the
+// highest one.
+func nextLevel(v float64) float64 {
+	for _, level := range sequencerLevels {
+		if level > v+0.01 {
+			return level
+		}
+	}
+	return 0
+}
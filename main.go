@@ -2,33 +2,43 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"html"
 	"log"
 	"os"
-	"os/exec"
 	"os/signal"
+	"time"
 
 	_ "embed"
 
 	"github.com/diamondburned/adaptive"
-	"github.com/diamondburned/go-buttplug"
-	"github.com/diamondburned/go-buttplug/device"
 	"github.com/diamondburned/go-buttplug/intiface"
 	"github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/diamondburned/gotk4/pkg/pango"
 	"github.com/diamondburned/intiface-gtk/internal/app"
+	"github.com/diamondburned/intiface-gtk/internal/httpcache"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+	"github.com/diamondburned/intiface-gtk/internal/shutdown"
 	"github.com/diamondburned/intiface-gtk/internal/ui"
 )
 
 func main() {
+	// Stop all devices if the main loop panics. Background goroutines defer
+	// this on their own.
+	defer shutdown.Recover()
+
 	intiface.EnableConsole = true
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
+	settings.Observe(func(s settings.Settings) { httpcache.SetPath(s.CachePath) })
+	if err := settings.Load(); err != nil {
+		log.Println("cannot load settings:", err)
+	}
+	httpcache.SetPath(settings.Get().CachePath)
+
 	app := app.Init("com.github.diamondburned.intiface-gtk")
 
 	var w *Window
@@ -48,11 +58,17 @@ func main() {
 //go:embed style.css
 var styleCSS string
 
-func activate(ctx context.Context, app *gtk.Application) *Window {
+func activate(ctx context.Context, gtkapp *gtk.Application) *Window {
 	adaptive.Init()
 
-	w := NewWindow(ctx, app)
+	app.AddAction("preferences", []string{"<Primary>comma"}, func() {
+		ui.NewPreferences().Show()
+	})
+
+	w := NewWindow(ctx, gtkapp)
 	w.StartLoading()
+
+	app.AddAction("stop-all", []string{"<Primary>space"}, w.StopAll)
 	defer w.Show()
 
 	css := gtk.NewCSSProvider()
@@ -69,13 +85,36 @@ type Window struct {
 	main *mainContent
 	done chan struct{}
 	ctx  context.Context
-	cli  string
+
+	// cli, server and mode hold what's entered in the connection prompt.
+	cli    string
+	server string
+	mode   connectMode
+	// env is true until the user has chosen the connection settings in the
+	// prompt, which then take precedence over the environment variables.
+	env bool
 }
 
+// connectMode describes how the window connects to Intiface.
+type connectMode uint8
+
+const (
+	// connectCLI spawns the Intiface CLI locally and connects to it.
+	connectCLI connectMode = iota
+	// connectServer connects to an already-running Intiface server.
+	connectServer
+)
+
+const defaultServer = "ws://localhost:12345"
+
 type mainContent struct {
-	*adaptive.Fold
+	*gtk.Overlay
+	fold    *adaptive.Fold
 	sidebar *gtk.StackSidebar
 	stack   *ui.DeviceStack
+
+	banner      *gtk.Revealer
+	bannerLabel *gtk.Label
 }
 
 func NewWindow(ctx context.Context, app *gtk.Application) *Window {
@@ -91,15 +130,10 @@ func NewWindow(ctx context.Context, app *gtk.Application) *Window {
 	ctx, cancel := context.WithCancel(ctx)
 	w.ConnectDestroy(cancel)
 
-	cli := "intiface-cli"
-	if v := os.Getenv("INTIFACE_CLI"); v != "" {
-		cli = v
-	}
-
 	return &Window{
 		ApplicationWindow: w,
-		cli:               cli,
 		ctx:               ctx,
+		env:               true,
 	}
 }
 
@@ -138,8 +172,25 @@ func (w *Window) Loaded(manager *ui.Manager) {
 	reveal.SetIconName("phone-symbolic")
 	reveal.ConnectFold(fold)
 
+	scan := ui.NewScanButton(manager)
+	if settings.Get().ScanOnConnect {
+		scan.Start()
+	}
+
+	stopAll := gtk.NewButtonFromIconName("process-stop-symbolic")
+	stopAll.AddCSSClass("destructive-action")
+	stopAll.SetTooltipText("Stop everything (Ctrl+Space)")
+	stopAll.SetActionName("app.stop-all")
+
+	prefs := gtk.NewButtonFromIconName("preferences-system-symbolic")
+	prefs.SetTooltipText("Preferences")
+	prefs.SetActionName("app.preferences")
+
 	header := gtk.NewHeaderBar()
 	header.PackStart(reveal)
+	header.PackStart(stopAll)
+	header.PackEnd(prefs)
+	header.PackEnd(scan)
 
 	w.SetChild(fold)
 	w.SetTitlebar(header)
@@ -156,17 +207,66 @@ func (w *Window) Loaded(manager *ui.Manager) {
 	reveal.Button.SetActive(true)
 	fold.SetRevealSide(true)
 
+	bannerSpinner := gtk.NewSpinner()
+	bannerSpinner.Start()
+
+	bannerLabel := gtk.NewLabel("Reconnecting…")
+	bannerLabel.SetWrap(true)
+	bannerLabel.SetWrapMode(pango.WrapWordChar)
+
+	bannerBox := gtk.NewBox(gtk.OrientationHorizontal, 6)
+	bannerBox.AddCSSClass("reconnect-banner")
+	bannerBox.Append(bannerSpinner)
+	bannerBox.Append(bannerLabel)
+
+	banner := gtk.NewRevealer()
+	banner.SetVAlign(gtk.AlignStart)
+	banner.SetHAlign(gtk.AlignCenter)
+	banner.SetTransitionType(gtk.RevealerTransitionTypeSlideDown)
+	banner.SetChild(bannerBox)
+	banner.SetRevealChild(false)
+
+	overlay := gtk.NewOverlay()
+	overlay.SetChild(fold)
+	overlay.AddOverlay(banner)
+
+	if w.main != nil {
+		w.main.stack.Close()
+	}
+
 	w.main = &mainContent{
-		Fold:    fold,
-		sidebar: sidebar,
-		stack:   stack,
+		Overlay:     overlay,
+		fold:        fold,
+		sidebar:     sidebar,
+		stack:       stack,
+		banner:      banner,
+		bannerLabel: bannerLabel,
 	}
 
 	w.SetChild(w.main)
 	w.SetTitle("Select a Device ⁠— Intiface")
 }
 
+// PromptCLI shows the connection prompt with the Intiface CLI form visible.
 func (w *Window) PromptCLI(err error) {
+	w.loadPrompt()
+	w.prompt(err, connectCLI)
+}
+
+// PromptServer shows the connection prompt with the server URL form visible.
+func (w *Window) PromptServer(err error) {
+	w.loadPrompt()
+	w.prompt(err, connectServer)
+}
+
+// loadPrompt fills the connection prompt with the current settings.
+func (w *Window) loadPrompt() {
+	cfg := loadConnectConfig(w.env)
+	w.cli = cfg.cli
+	w.server = cfg.server
+}
+
+func (w *Window) prompt(err error, mode connectMode) {
 	error := gtk.NewLabel("")
 	error.SetVAlign(gtk.AlignStart)
 	error.SetXAlign(0)
@@ -178,7 +278,43 @@ func (w *Window) PromptCLI(err error) {
 		html.EscapeString(err.Error()),
 	))
 
+	cliForm, cliEntry := w.cliForm()
+	serverForm, serverEntry := w.serverForm()
+
+	forms := gtk.NewStack()
+	forms.SetVExpand(true)
+	forms.SetTransitionType(gtk.StackTransitionTypeCrossfade)
+	forms.AddTitled(cliForm, "cli", "Local CLI")
+	forms.AddTitled(serverForm, "server", "Remote Server")
+
+	switcher := gtk.NewStackSwitcher()
+	switcher.SetHAlign(gtk.AlignCenter)
+	switcher.SetStack(forms)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.AddCSSClass("cli-prompt")
+	box.SetVExpand(true)
+	box.Append(error)
+	box.Append(switcher)
+	box.Append(forms)
+
+	w.SetChild(box)
+	w.SetTitle("Intiface Initialization")
+
+	switch mode {
+	case connectCLI:
+		forms.SetVisibleChild(cliForm)
+		cliEntry.GrabFocus()
+	case connectServer:
+		forms.SetVisibleChild(serverForm)
+		serverEntry.GrabFocus()
+	}
+}
+
+func (w *Window) cliForm() (*gtk.Box, *gtk.Entry) {
 	load := func() {
+		w.mode = connectCLI
+		w.saveConnection()
 		w.StartLoading()
 	}
 
@@ -194,6 +330,39 @@ func (w *Window) PromptCLI(err error) {
 	retry.AddCSSClass("suggested-action")
 	retry.ConnectClicked(load)
 
+	return newPromptForm(label, entry, retry), entry
+}
+
+func (w *Window) serverForm() (*gtk.Box, *gtk.Entry) {
+	load := func() {
+		w.mode = connectServer
+
+		if err := settings.ValidateServerURL(w.server); err != nil {
+			w.prompt(err, connectServer)
+			return
+		}
+
+		w.saveConnection()
+		w.StartLoading()
+	}
+
+	entry := gtk.NewEntry()
+	entry.SetText(w.server)
+	entry.SetPlaceholderText(defaultServer)
+	entry.ConnectChanged(func() { w.server = entry.Text() })
+	entry.ConnectActivate(load)
+
+	label := gtk.NewLabel("Intiface server address")
+	label.SetXAlign(0)
+
+	connect := gtk.NewButtonWithLabel("Connect")
+	connect.AddCSSClass("suggested-action")
+	connect.ConnectClicked(load)
+
+	return newPromptForm(label, entry, connect), entry
+}
+
+func newPromptForm(label *gtk.Label, entry *gtk.Entry, button *gtk.Button) *gtk.Box {
 	form := gtk.NewBox(gtk.OrientationVertical, 0)
 	form.AddCSSClass("cli-prompt-form")
 	form.SetVExpand(true)
@@ -201,18 +370,21 @@ func (w *Window) PromptCLI(err error) {
 	form.SetHAlign(gtk.AlignCenter)
 	form.Append(label)
 	form.Append(entry)
-	form.Append(retry)
-
-	box := gtk.NewBox(gtk.OrientationVertical, 0)
-	box.AddCSSClass("cli-prompt")
-	box.SetVExpand(true)
-	box.Append(error)
-	box.Append(form)
-
-	w.SetChild(box)
-	w.SetTitle("Intiface Initialization")
+	form.Append(button)
+	return form
+}
 
-	entry.GrabFocus()
+// saveConnection persists the connection settings chosen in the prompt.
+func (w *Window) saveConnection() {
+	err := settings.Update(func(s *settings.Settings) {
+		s.CLIPath = w.cli
+		s.ServerURL = w.server
+		s.UseServer = w.mode == connectServer
+	})
+	w.env = false
+	if err != nil {
+		log.Println("cannot save connection settings:", err)
+	}
 }
 
 func (w *Window) StartLoading() {
@@ -225,44 +397,15 @@ func (w *Window) StartLoading() {
 	w.SetChild(loading)
 	w.SetTitle("Loading Intiface")
 
-	cli := w.cli
+	env := w.env
 
 	done := make(chan struct{})
 	w.done = done
 
 	go func() {
-		ctx, cancel := context.WithCancel(w.ctx)
-		defer cancel()
-
-		ws := intiface.NewWebsocket(20000, cli)
-		devman := device.NewManager()
-
-		var ok bool
-
-		for ev := range devman.ListenPassthrough(ws.Open(ctx)) {
-			switch ev := ev.(type) {
-			case *buttplug.ServerInfo:
-				ok = true
-				glib.IdleAdd(func() {
-					w.Loaded(&ui.Manager{
-						Manager:   devman,
-						Websocket: ws.Websocket,
-					})
-				})
-				ws.Send(ctx,
-					&buttplug.StartScanning{},
-					&buttplug.RequestDeviceList{},
-				)
-			case error:
-				log.Println("buttplug error:", ev)
-
-				var execErr *exec.Error
-				if !ok && errors.As(ev, &execErr) {
-					cancel()
-					glib.IdleAdd(func() { w.PromptCLI(ev) })
-				}
-			}
-		}
+		defer shutdown.Recover()
+
+		newSupervisor(w, env).run(w.ctx)
 
 		// Event loop will break out after this.
 		log.Println("event loop exited")
@@ -270,3 +413,25 @@ func (w *Window) StartLoading() {
 		close(done)
 	}()
 }
+
+// StopAll stops every device and pattern.
+func (w *Window) StopAll() {
+	if w.main != nil {
+		w.main.stack.StopAll()
+	}
+}
+
+// Reconnecting shows a banner over the device stack telling the user that the
+// connection was lost and will be retried after the given delay.
+func (w *Window) Reconnecting(delay time.Duration) {
+	if w.main == nil {
+		w.SetTitle("Reconnecting to Intiface")
+		return
+	}
+
+	w.main.bannerLabel.SetText(fmt.Sprintf(
+		"Connection lost. Reconnecting in %s…", delay.Round(100*time.Millisecond),
+	))
+	w.main.banner.SetRevealChild(true)
+	w.main.stack.SetSensitive(false)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/diamondburned/go-buttplug"
+	"github.com/diamondburned/go-buttplug/device"
+	"github.com/diamondburned/go-buttplug/intiface"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/intiface-gtk/internal/backoff"
+	"github.com/diamondburned/intiface-gtk/internal/settings"
+	"github.com/diamondburned/intiface-gtk/internal/shutdown"
+	"github.com/diamondburned/intiface-gtk/internal/ui"
+)
+
+const (
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// connectConfig is a snapshot of the connection settings used for a single
+// session.
+type connectConfig struct {
+	mode    connectMode
+	cli     string
+	cliArgs []string
+	port    int
+	server  string
+}
+
+// loadConnectConfig reads the current connection settings. If env is true,
+// then the environment variables take precedence over the settings.
+func loadConnectConfig(env bool) connectConfig {
+	s := settings.Get()
+
+	cfg := connectConfig{
+		mode:    connectCLI,
+		cli:     s.CLIPath,
+		cliArgs: s.CLIArgs,
+		port:    s.Port,
+		server:  s.ServerURL,
+	}
+	if s.UseServer {
+		cfg.mode = connectServer
+	}
+
+	if env {
+		if v := os.Getenv("INTIFACE_CLI"); v != "" {
+			cfg.cli = v
+		}
+		if v := os.Getenv("INTIFACE_SERVER"); v != "" {
+			cfg.mode = connectServer
+			cfg.server = v
+		}
+	}
+
+	return cfg
+}
+
+func (c connectConfig) open(ctx context.Context) (*buttplug.Websocket, <-chan buttplug.Message) {
+	switch c.mode {
+	case connectServer:
+		ws := buttplug.NewWebsocket()
+		return ws, ws.Open(ctx, c.server)
+	default:
+		ws := intiface.NewWebsocket(c.port, c.cli, c.cliArgs...)
+		return ws.Websocket, ws.Open(ctx)
+	}
+}
+
+// sessionResult describes how a single connection session ended.
+type sessionResult uint8
+
+const (
+	// sessionCanceled is returned if the parent context is canceled.
+	sessionCanceled sessionResult = iota
+	// sessionPrompted is returned if the user was prompted to fix the
+	// connection settings, so the supervisor should stop.
+	sessionPrompted
+	// sessionDropped is returned if the connection was lost or could not be
+	// established, so the supervisor should try again.
+	sessionDropped
+)
+
+// supervisor keeps a connection to Intiface alive, reconnecting with an
+// exponential backoff whenever the connection or the CLI process drops.
+type supervisor struct {
+	w       *Window
+	env     bool
+	cfg     connectConfig // of the current session
+	backoff *backoff.Backoff
+	// connected is true if any session has ever reached the server.
+	connected bool
+}
+
+// newSupervisor creates a supervisor that reads the connection settings again
+// before every session, so changes apply on the next reconnect. See
+// loadConnectConfig for env.
+func newSupervisor(w *Window, env bool) *supervisor {
+	return &supervisor{
+		w:       w,
+		env:     env,
+		backoff: backoff.New(reconnectMinDelay, reconnectMaxDelay),
+	}
+}
+
+// run blocks until ctx is canceled or the user has to be prompted.
+func (s *supervisor) run(ctx context.Context) {
+	for {
+		switch s.session(ctx) {
+		case sessionCanceled, sessionPrompted:
+			return
+		}
+
+		delay := s.backoff.Next()
+		log.Println("connection lost, reconnecting in", delay)
+
+		glib.IdleAdd(func() { s.w.Reconnecting(delay) })
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *supervisor) session(parent context.Context) sessionResult {
+	s.cfg = loadConnectConfig(s.env)
+
+	// The connection outlives the parent context for a moment, so that all
+	// devices can be stopped before it's closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ws, evs := s.cfg.open(ctx)
+	defer shutdown.ClearConn(ws)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer shutdown.Recover()
+
+		select {
+		case <-parent.Done():
+			// Stop halts the players and the outputs' schedulers first, so
+			// that nothing else is sent after StopAllDevices.
+			if err := shutdown.Stop(); err != nil {
+				log.Println(err)
+			}
+			cancel()
+		case <-done:
+		}
+	}()
+
+	devman := device.NewManager()
+	// Commands are already merged and rate-limited per device by the UI. The
+	// manager's debouncer would drop commands for other motors.
+	devman.DebounceFrequency = -1
+
+	result := sessionDropped
+	var ok bool
+
+	for ev := range devman.ListenPassthrough(evs) {
+		switch ev := ev.(type) {
+		case *buttplug.ServerInfo:
+			if ok {
+				break
+			}
+
+			ok = true
+			s.connected = true
+			s.backoff.Reset()
+			shutdown.SetConn(ws)
+
+			glib.IdleAdd(func() {
+				s.w.Loaded(&ui.Manager{
+					Manager:   devman,
+					Websocket: ws,
+				})
+			})
+			ws.Send(ctx, &buttplug.RequestDeviceList{})
+		case error:
+			log.Println("buttplug error:", ev)
+
+			var execErr *exec.Error
+			var dialErr *buttplug.DialError
+
+			switch {
+			case ok:
+				// The websocket only dials again once it has lost its
+				// connection, so treat that as the server going away.
+				if errors.As(ev, &dialErr) {
+					shutdown.ClearConn(ws)
+					cancel()
+				}
+			case s.connected:
+				// We're reconnecting. Let the backoff pace the attempts
+				// instead of the websocket's own dial loop. The CLI needs a
+				// few dials while it's starting up, so leave that alone.
+				if s.cfg.mode == connectServer && errors.As(ev, &dialErr) {
+					cancel()
+				}
+				if errors.As(ev, &execErr) {
+					cancel()
+				}
+			case s.cfg.mode == connectCLI && errors.As(ev, &execErr):
+				result = sessionPrompted
+				cancel()
+				glib.IdleAdd(func() { s.w.PromptCLI(ev) })
+			case s.cfg.mode == connectServer && errors.As(ev, &dialErr):
+				result = sessionPrompted
+				cancel()
+				glib.IdleAdd(func() { s.w.PromptServer(ev) })
+			}
+		}
+	}
+
+	if parent.Err() != nil {
+		return sessionCanceled
+	}
+
+	return result
+}